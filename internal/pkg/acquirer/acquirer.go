@@ -25,6 +25,7 @@ import (
 
 type Acquirer interface {
 	acquire(dest string) error
+	hash(dest string) (string, error)
 	FullyQualifiedId() (string, error)
 	Id() string
 	Path() string
@@ -57,6 +58,12 @@ func Acquire(a Acquirer, dest string) error {
 	return a.acquire(dest)
 }
 
+// Returns a hash identifying the contents of a source acquired into `dest` so changes to it can
+// be detected
+func Hash(a Acquirer, dest string) (string, error) {
+	return a.hash(dest)
+}
+
 // Takes a list of Sources and returns a list of instantiated acquirers that represent them
 func GetAcquirersFromSources(sources map[string]structs.Source) (map[string]Acquirer, error) {
 	acquirers := make(map[string]Acquirer, len(sources))
@@ -17,9 +17,16 @@
 package acquirer
 
 import (
+	"bytes"
 	"github.com/stretchr/testify/assert"
 	"github.com/sugarkube/sugarkube/internal/pkg/log"
 	"github.com/sugarkube/sugarkube/internal/pkg/structs"
+	"github.com/sugarkube/sugarkube/internal/pkg/utils"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -144,3 +151,71 @@ func TestNewAcquirerGitExplicitId(t *testing.T) {
 	assert.Equal(t, expectedAcquirer, actual,
 		"Git acquirer with explicitly set ID incorrectly created")
 }
+
+func TestFileAcquirerHash(t *testing.T) {
+	dir, err := ioutil.TempDir("", "file-acquirer-")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "Makefile"), []byte("install:\n"), 0644))
+
+	acquirerObj, err := New(structs.Source{Uri: "file://" + dir})
+	assert.Nil(t, err)
+
+	hash1, err := Hash(acquirerObj, "")
+	assert.Nil(t, err)
+
+	hash2, err := Hash(acquirerObj, "")
+	assert.Nil(t, err)
+	assert.Equal(t, hash1, hash2)
+
+	// changing a file changes the hash
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "Makefile"), []byte("delete:\n"), 0644))
+	hash3, err := Hash(acquirerObj, "")
+	assert.Nil(t, err)
+	assert.NotEqual(t, hash1, hash3)
+
+	// so does adding a file
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "values.yaml"), []byte{}, 0644))
+	hash4, err := Hash(acquirerObj, "")
+	assert.Nil(t, err)
+	assert.NotEqual(t, hash3, hash4)
+}
+
+func TestGitAcquirerHash(t *testing.T) {
+	if _, err := exec.LookPath(GitPath); err != nil {
+		t.Skip("git isn't installed")
+	}
+
+	dir, err := ioutil.TempDir("", "git-acquirer-")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	git := func(args ...string) string {
+		var stdoutBuf, stderrBuf bytes.Buffer
+		err := utils.ExecCommand(GitPath, append([]string{"-c", "user.name=test", "-c",
+			"user.email=test@example.com"}, args...), map[string]string{}, &stdoutBuf, &stderrBuf, dir,
+			10, false)
+		assert.Nil(t, err)
+		return strings.TrimSpace(stdoutBuf.String())
+	}
+
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "Makefile"), []byte("install:\n"), 0644))
+	git("init")
+	git("add", "Makefile")
+	git("commit", "-m", "Initial commit")
+
+	acquirerObj, err := New(structs.Source{Uri: GoodGitUri})
+	assert.Nil(t, err)
+
+	// clean checkouts are identified by their tree
+	hash, err := Hash(acquirerObj, dir)
+	assert.Nil(t, err)
+	assert.Equal(t, git("rev-parse", "HEAD^{tree}"), hash)
+
+	// uncommitted changes change the hash
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "Makefile"), []byte("delete:\n"), 0644))
+	dirtyHash, err := Hash(acquirerObj, dir)
+	assert.Nil(t, err)
+	assert.True(t, strings.HasPrefix(dirtyHash, hash+"+"))
+}
@@ -17,10 +17,13 @@
 package acquirer
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"github.com/pkg/errors"
 	"github.com/sugarkube/sugarkube/internal/pkg/log"
 	"github.com/sugarkube/sugarkube/internal/pkg/structs"
+	"io"
 	"os"
 	"path/filepath"
 	"strings"
@@ -98,3 +101,62 @@ func (a FileAcquirer) acquire(dest string) error {
 
 	return nil
 }
+
+// Returns a hash of the paths and contents of all files under the source's path. Files aren't
+// acquired into `dest` so it's ignored.
+func (a FileAcquirer) hash(dest string) (string, error) {
+	root := a.Path()
+	hash := sha256.New()
+
+	// files are walked in lexical order so the hash is deterministic
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		if info.IsDir() {
+			if info.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		relPath, err := filepath.Rel(root, path)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		_, err = fmt.Fprintf(hash, "%s\n", relPath)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		// symlinks aren't followed, but changing their targets changes the hash
+		if info.Mode()&os.ModeSymlink != 0 {
+			target, err := os.Readlink(path)
+			if err != nil {
+				return errors.WithStack(err)
+			}
+			_, err = fmt.Fprintf(hash, "-> %s\n", target)
+			return errors.WithStack(err)
+		}
+
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		file, err := os.Open(path)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		defer file.Close()
+
+		_, err = io.Copy(hash, file)
+		return errors.WithStack(err)
+	})
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
@@ -18,6 +18,8 @@ package acquirer
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"github.com/pkg/errors"
 	"github.com/sugarkube/sugarkube/internal/pkg/log"
@@ -272,3 +274,29 @@ func (a GitAcquirer) update(dest string) error {
 
 	return nil
 }
+
+// Returns the hash of the checked out tree, plus a hash of any uncommitted changes to tracked files
+func (a GitAcquirer) hash(dest string) (string, error) {
+	var stdoutBuf, stderrBuf bytes.Buffer
+
+	err := utils.ExecCommand(GitPath, []string{"rev-parse", "HEAD^{tree}"},
+		map[string]string{}, &stdoutBuf, &stderrBuf, dest, 5, false)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	treeHash := strings.TrimSpace(stdoutBuf.String())
+
+	err = utils.ExecCommand(GitPath, []string{"diff", "HEAD"},
+		map[string]string{}, &stdoutBuf, &stderrBuf, dest, 30, false)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	if stdoutBuf.Len() == 0 {
+		return treeHash, nil
+	}
+
+	diffHash := sha256.Sum256(stdoutBuf.Bytes())
+	return treeHash + "+" + hex.EncodeToString(diffHash[:]), nil
+}
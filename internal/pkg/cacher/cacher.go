@@ -73,6 +73,16 @@ func CacheManifest(cacheGroup CacheGrouper, rootCacheDir string, dryRun bool) er
 	return nil
 }
 
+// Returns the directory a kapp's source is acquired into
+func SourceDir(kappCacheDir string, a acquirer.Acquirer) (string, error) {
+	acquirerId, err := a.FullyQualifiedId()
+	if err != nil {
+		return "", errors.Wrap(err, "Invalid acquirer ID")
+	}
+
+	return filepath.Join(kappCacheDir, CacheDir, acquirerId), nil
+}
+
 // Acquires each source and symlinks it to the target path in the cache directory.
 // Runs all acquirers in parallel.
 func acquireSources(manifestId string, acquirers map[string]acquirer.Acquirer, kappTopLevelCacheDir string,
@@ -93,16 +103,14 @@ func acquireSources(manifestId string, acquirers map[string]acquirer.Acquirer, k
 
 	for _, acquirerImpl := range acquirers {
 		go func(a acquirer.Acquirer) {
-			acquirerId, err := a.FullyQualifiedId()
+			// todo - the no-op file acquirer doesn't actually cache files, so we need some object whose job it is
+			// to create cache paths per-acquirer (or a method on each acquirer type)
+			sourceDest, err := SourceDir(kappTopLevelCacheDir, a)
 			if err != nil {
-				errCh <- errors.Wrap(err, "Invalid acquirer ID")
+				errCh <- errors.WithStack(err)
 				return
 			}
 
-			// todo - the no-op file acquirer doesn't actually cache files, so we need some object whose job it is
-			// to create cache paths per-acquirer (or a method on each acquirer type)
-			sourceDest := filepath.Join(kappHiddenCacheDir, acquirerId)
-
 			if dryRun {
 				log.Logger.Debugf("Dry run: Would acquire source into '%s'", sourceDest)
 			} else {
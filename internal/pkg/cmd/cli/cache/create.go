@@ -17,6 +17,7 @@
 package cache
 
 import (
+	"context"
 	"fmt"
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
@@ -155,13 +156,13 @@ func (c *createCmd) run() error {
 
 		// create a DAG to template all the kapps
 		dagObj, err := kapps.BuildDagForSelected(stackObj, c.cacheDir, []string{}, []string{},
-			false, "", c.out)
+			false, false, "", c.out)
 		if err != nil {
 			return errors.WithStack(err)
 		}
 
-		err = dagObj.Execute(constants.DagActionTemplate, stackObj, false, true, true,
-			true, true, c.dryRun)
+		_, err = dagObj.Execute(context.Background(), constants.DagActionTemplate, stackObj, false, true, true,
+			true, true, c.dryRun, constants.FailurePolicyFailFast)
 		if err != nil {
 			return errors.WithStack(err)
 		}
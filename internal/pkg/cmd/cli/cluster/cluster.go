@@ -20,6 +20,7 @@ import (
 	"fmt"
 	"github.com/spf13/cobra"
 	"github.com/sugarkube/sugarkube/internal/pkg/interfaces"
+	"github.com/sugarkube/sugarkube/internal/pkg/lock"
 	"github.com/sugarkube/sugarkube/internal/pkg/log"
 	"io"
 	"os"
@@ -29,6 +30,9 @@ import (
 
 var stackObj interfaces.IStack
 
+// held while commands modify the stack so other runs can't modify it concurrently
+var runLock *lock.Lock
+
 func NewClusterCmds(out io.Writer) *cobra.Command {
 
 	cmd := &cobra.Command{
@@ -38,12 +42,13 @@ func NewClusterCmds(out io.Writer) *cobra.Command {
 		Aliases: []string{"stack", "stacks", "clusters"},
 		PersistentPreRun: func(cmd *cobra.Command, args []string) {
 			log.Logger.Debug("Setting up signal handler")
-			// catch termination via CTRL-C
-			signals := make(chan os.Signal)
-			signal.Notify(signals, os.Interrupt, syscall.SIGTERM, syscall.SIGKILL)
+			// catch termination via CTRL-C. SIGKILL can't be caught
+			signals := make(chan os.Signal, 1)
+			signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
 			go func() {
 				<-signals
 				log.Logger.Info("Caught termination signal. Will try to gracefully terminate...")
+				releaseLock()
 				if stackObj != nil {
 					err2 := stackObj.GetProvisioner().Close()
 					if err2 != nil {
@@ -67,3 +72,12 @@ func NewClusterCmds(out io.Writer) *cobra.Command {
 
 	return cmd
 }
+
+// Releases the lock on the stack if it's held. Errors are logged since there's nothing else we can
+// do about them
+func releaseLock() {
+	err := runLock.Release()
+	if err != nil {
+		log.Logger.Warnf("%v", err)
+	}
+}
@@ -20,6 +20,7 @@ import (
 	"fmt"
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
+	"github.com/sugarkube/sugarkube/internal/pkg/lock"
 	"github.com/sugarkube/sugarkube/internal/pkg/stack"
 	"github.com/sugarkube/sugarkube/internal/pkg/structs"
 	"io"
@@ -27,6 +28,7 @@ import (
 
 type deleteCmd struct {
 	out         io.Writer
+	forceUnlock bool
 	dryRun      bool
 	approved    bool
 	stackName   string
@@ -63,6 +65,7 @@ func newDeleteCmd(out io.Writer) *cobra.Command {
 	f := cmd.Flags()
 	f.BoolVarP(&c.dryRun, "dry-run", "n", false, "show what would happen but don't create a cluster")
 	f.BoolVarP(&c.approved, "yes", "y", false, "actually delete the cluster")
+	f.BoolVar(&c.forceUnlock, "force-unlock", false, "release the lock on the stack held by another run before acquiring it")
 	f.StringVar(&c.provider, "provider", "", "name of provider, e.g. aws, local, etc.")
 	f.StringVar(&c.provisioner, "provisioner", "", "name of provisioner, e.g. kops, minikube, etc.")
 	f.StringVar(&c.profile, "profile", "", "launch profile, e.g. dev, test, prod, etc.")
@@ -88,6 +91,12 @@ func (c *deleteCmd) run() error {
 		return errors.WithStack(err)
 	}
 
+	runLock, err = lock.AcquireForStack(stackObj, c.forceUnlock)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer releaseLock()
+
 	dryRunPrefix := ""
 	if c.dryRun {
 		dryRunPrefix = "[Dry run] "
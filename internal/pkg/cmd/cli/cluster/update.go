@@ -21,6 +21,7 @@ import (
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
 	"github.com/sugarkube/sugarkube/internal/pkg/interfaces"
+	"github.com/sugarkube/sugarkube/internal/pkg/lock"
 	"github.com/sugarkube/sugarkube/internal/pkg/log"
 	"github.com/sugarkube/sugarkube/internal/pkg/provisioner"
 	"github.com/sugarkube/sugarkube/internal/pkg/stack"
@@ -32,6 +33,7 @@ import (
 
 type updateCmd struct {
 	out           io.Writer
+	forceUnlock   bool
 	dryRun        bool
 	skipCreate    bool
 	stackName     string
@@ -81,6 +83,7 @@ Note: Not all providers require all arguments. See documentation for help.
 	f := command.Flags()
 	f.BoolVarP(&c.dryRun, "dry-run", "n", false, "show what would happen but don't create a cluster")
 	f.BoolVar(&c.skipCreate, "no-create", false, "don't automatically create the target cluster if it's offline")
+	f.BoolVar(&c.forceUnlock, "force-unlock", false, "release the lock on the stack held by another run before acquiring it")
 	f.StringVar(&c.provider, "provider", "", "name of provider, e.g. aws, local, etc.")
 	f.StringVar(&c.provisioner, "provisioner", "", "name of provisioner, e.g. kops, minikube, etc.")
 	f.StringVar(&c.profile, "profile", "", "launch profile, e.g. dev, test, prod, etc.")
@@ -109,6 +112,12 @@ func (c *updateCmd) run() error {
 		return errors.WithStack(err)
 	}
 
+	runLock, err = lock.AcquireForStack(stackObj, c.forceUnlock)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer releaseLock()
+
 	stackObj.GetConfig().SetReadyTimeout(c.readyTimeout)
 	stackObj.GetConfig().SetOnlineTimeout(c.onlineTimeout)
 
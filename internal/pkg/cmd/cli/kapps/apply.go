@@ -0,0 +1,248 @@
+/*
+ * Copyright 2019 The Sugarkube Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package kapps
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"github.com/sugarkube/sugarkube/internal/pkg/constants"
+	"github.com/sugarkube/sugarkube/internal/pkg/lock"
+	"github.com/sugarkube/sugarkube/internal/pkg/plan"
+	"github.com/sugarkube/sugarkube/internal/pkg/stack"
+	"github.com/sugarkube/sugarkube/internal/pkg/structs"
+	"io"
+)
+
+type applyCmd struct {
+	out                 io.Writer
+	forceUnlock         bool
+	cacheDir            string
+	dryRun              bool
+	approved            bool
+	oneShot             bool
+	force               bool
+	skipPreActions      bool
+	skipPostActions     bool
+	establishConnection bool
+	includeParents      bool
+	includeChildren     bool
+	onlyMarked          bool
+	failurePolicy       string
+	reportPath          string
+	junitReportPath     string
+	stackName           string
+	stackFile           string
+	provider            string
+	provisioner         string
+	profile             string
+	account             string
+	cluster             string
+	region              string
+	includeSelector     []string
+	excludeSelector     []string
+	onlineTimeout       uint32
+	readyTimeout        uint32
+}
+
+func newApplyCmd(out io.Writer) *cobra.Command {
+	c := &applyCmd{
+		out: out,
+	}
+
+	cmd := &cobra.Command{
+		Use:   "apply [flags] [stack-file] [stack-name] [cache-dir]",
+		Short: fmt.Sprintf("Install present kapps and delete absent kapps in a single run"),
+		Long: `Reconciles a target cluster with the 'state' of each kapp in manifests.
+
+Selected kapps whose state is 'absent' are deleted and all other selected kapps 
+are installed in a single run. Kapps are ordered so that present kapps are 
+installed after their parents, absent kapps are deleted before their parents, 
+and absent kapps are deleted after any present parents have been installed. 
+It's an error for a present kapp to depend on an absent kapp that's selected.
+The combined plan is printed before any kapps are processed.
+
+As with 'kapps install' and 'kapps delete', kapps are expected to plan their
+changes unless '--yes' or '--one-shot' is passed. Kapps that haven't changed
+since they were last installed aren't installed again unless '--force' is
+passed (see 'kapps install').
+`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) < 3 {
+				return errors.New("some required arguments are missing")
+			} else if len(args) > 3 {
+				return errors.New("too many arguments supplied")
+			}
+			c.stackFile = args[0]
+			c.stackName = args[1]
+			c.cacheDir = args[2]
+
+			err1 := c.run()
+			// shutdown any SSH port forwarding then return the error
+			if stackObj != nil {
+				err2 := stackObj.GetProvisioner().Close()
+				if err2 != nil {
+					return errors.WithStack(err2)
+				}
+			}
+
+			if err1 != nil {
+				return errors.WithStack(err1)
+			}
+
+			return nil
+		},
+	}
+
+	f := cmd.Flags()
+	f.BoolVarP(&c.dryRun, "dry-run", "n", false, "show what would happen but don't install or delete any kapps")
+	f.StringVar(&c.reportPath, "report", "", "write a JSON report of the outcome of processing each kapp to this file")
+	f.StringVar(&c.junitReportPath, "junit-report", "", "write a JUnit XML report of the outcome of processing each "+
+		"kapp to this file")
+	f.BoolVarP(&c.approved, "yes", "y", false, "actually install and delete kapps. If false, kapps will be "+
+		"expected to plan their changes but not make any destrucive changes (e.g. should run 'terraform plan', "+
+		"etc. but not apply it).")
+	f.BoolVar(&c.oneShot, "one-shot", false, "invoke each kapp with 'APPROVED=false' then "+
+		"'APPROVED=true' to install and delete kapps in a single pass")
+	f.BoolVar(&c.includeParents, "parents", false, "process all parents of all selected kapps as well")
+	f.BoolVar(&c.includeChildren, "children", false, "process all children of all selected kapps as well, "+
+		"including those in other manifests")
+	f.BoolVar(&c.includeChildren, "descendants", false, "alias for --children")
+	f.BoolVar(&c.onlyMarked, "only", false, "only process selected kapps. Their parents won't be run "+
+		"to load their outputs. Their last known outputs will be used instead if they were cached by a previous run")
+	f.StringVar(&c.failurePolicy, "failure-policy", constants.FailurePolicyFailFast,
+		fmt.Sprintf("what to do if a kapp fails. '%s' stops processing kapps immediately. '%s' skips kapps "+
+			"that depend on the failed kapp but carries on processing the others", constants.FailurePolicyFailFast,
+			constants.FailurePolicyContinue))
+	f.BoolVar(&c.force, "force", false, "install kapps even if they haven't changed since they were last "+
+		"installed in this stack")
+	f.BoolVar(&c.skipPreActions, "no-pre-actions", false, "skip running pre actions in kapps")
+	f.BoolVar(&c.skipPostActions, "no-post-actions", false, "skip running post actions in kapps")
+	f.BoolVar(&c.establishConnection, "connect", false, "establish a connection to the API server if it's not publicly accessible")
+	f.BoolVar(&c.forceUnlock, "force-unlock", false, "release the lock on the stack held by another run before acquiring it")
+	f.StringVar(&c.provider, "provider", "", "name of provider, e.g. aws, local, etc.")
+	f.StringVar(&c.provisioner, "provisioner", "", "name of provisioner, e.g. kops, minikube, etc.")
+	f.StringVar(&c.profile, "profile", "", "launch profile, e.g. dev, test, prod, etc.")
+	f.StringVarP(&c.cluster, "cluster", "c", "", "name of cluster to launch, e.g. dev1, dev2, etc.")
+	f.StringVarP(&c.account, "account", "a", "", "string identifier for the account to launch in (for providers that support it)")
+	f.StringVarP(&c.region, "region", "r", "", "name of region (for providers that support it)")
+	f.StringArrayVarP(&c.includeSelector, "include", "i", []string{},
+		fmt.Sprintf("only process specified kapps (can specify multiple, formatted 'manifest-id:kapp-id' or 'manifest-id:%s' for all)",
+			constants.WildcardCharacter))
+	f.StringArrayVarP(&c.excludeSelector, "exclude", "x", []string{},
+		fmt.Sprintf("exclude individual kapps (can specify multiple, formatted 'manifest-id:kapp-id' or 'manifest-id:%s' for all)",
+			constants.WildcardCharacter))
+	f.Uint32Var(&c.onlineTimeout, "online-timeout", 600, "max number of seconds to wait for the cluster to come online")
+	f.Uint32Var(&c.readyTimeout, "ready-timeout", 600, "max number of seconds to wait for the cluster to become ready")
+	return cmd
+}
+
+func (c *applyCmd) run() error {
+
+	// CLI overrides - will be merged with any loaded from a stack config file
+	cliStackConfig := &structs.StackFile{
+		Provider:    c.provider,
+		Provisioner: c.provisioner,
+		Profile:     c.profile,
+		Cluster:     c.cluster,
+		Region:      c.region,
+		Account:     c.account,
+	}
+
+	var err error
+
+	stackObj, err = stack.BuildStack(c.stackName, c.stackFile, cliStackConfig, c.out)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	runLock, err = lock.AcquireForStack(stackObj, c.forceUnlock)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer releaseLock()
+
+	stackObj.GetConfig().SetReadyTimeout(c.readyTimeout)
+	stackObj.GetConfig().SetOnlineTimeout(c.onlineTimeout)
+
+	dryRunPrefix := ""
+	if c.dryRun {
+		dryRunPrefix = "[Dry run] "
+	}
+
+	// don't filter by state since absent kapps need deleting
+	dagObj, err := BuildDagForSelected(stackObj, c.cacheDir, c.includeSelector, c.excludeSelector,
+		c.includeParents, c.includeChildren, "", c.out)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	dagObj.OnlyMarked = c.onlyMarked
+	dagObj.Fingerprints = plan.NewFingerprints(stackObj.GetConfig().GetName(), c.force)
+
+	err = dagObj.PrintApplyPlan(c.out)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if c.establishConnection {
+		err = establishConnection(c.dryRun, dryRunPrefix)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	}
+
+	shouldPlan := false
+	approved := false
+
+	if c.oneShot {
+		shouldPlan = true
+		approved = true
+	} else {
+		if c.approved {
+			approved = true
+		} else {
+			shouldPlan = true
+		}
+	}
+
+	summary, err := dagObj.Execute(runCtx, constants.DagActionApply, stackObj, shouldPlan, approved,
+		c.skipPreActions, c.skipPostActions, false, c.dryRun, c.failurePolicy)
+	reportErr := writeReports(summary, c.reportPath, c.junitReportPath)
+	if summary != nil {
+		err2 := summary.Print(c.out)
+		if err2 != nil {
+			return errors.WithStack(err2)
+		}
+	}
+	if c.onlyMarked {
+		err2 := dagObj.Outputs.PrintWarnings(c.out)
+		if err2 != nil {
+			return errors.WithStack(err2)
+		}
+	}
+	err = executeError(err, reportErr)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	_, err = fmt.Fprintf(c.out, "%sKapp changes successfully applied\n", dryRunPrefix)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return nil
+}
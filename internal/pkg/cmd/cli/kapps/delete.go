@@ -21,14 +21,15 @@ import (
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
 	"github.com/sugarkube/sugarkube/internal/pkg/constants"
+	"github.com/sugarkube/sugarkube/internal/pkg/lock"
 	"github.com/sugarkube/sugarkube/internal/pkg/stack"
 	"github.com/sugarkube/sugarkube/internal/pkg/structs"
 	"io"
-	"time"
 )
 
 type deleteCmd struct {
 	out                 io.Writer
+	forceUnlock         bool
 	cacheDir            string
 	dryRun              bool
 	approved            bool
@@ -39,6 +40,11 @@ type deleteCmd struct {
 	skipPostActions     bool
 	establishConnection bool
 	includeParents      bool
+	includeChildren     bool
+	onlyMarked          bool
+	failurePolicy       string
+	reportPath          string
+	junitReportPath     string
 	stackName           string
 	stackFile           string
 	provider            string
@@ -98,16 +104,29 @@ process before deleting the selected kapps.
 
 	f := cmd.Flags()
 	f.BoolVarP(&c.dryRun, "dry-run", "n", false, "show what would happen but don't create a cluster")
+	f.StringVar(&c.reportPath, "report", "", "write a JSON report of the outcome of processing each kapp to this file")
+	f.StringVar(&c.junitReportPath, "junit-report", "", "write a JUnit XML report of the outcome of processing each "+
+		"kapp to this file")
 	f.BoolVarP(&c.approved, "yes", "y", false, "actually delete kapps. If false, kapps will be expected to plan "+
 		"their changes but not make any destrucive changes (e.g. should run 'terraform plan', etc. but not apply it).")
 	f.BoolVar(&c.oneShot, "one-shot", false, "invoke each kapp with 'APPROVED=false' then "+
 		"'APPROVED=true' to delete kapps in a single pass")
 	f.BoolVar(&c.ignoreErrors, "ignore-errors", false, "ignore errors deleting kapps")
 	f.BoolVar(&c.includeParents, "parents", false, "process all parents of all selected kapps as well")
+	f.BoolVar(&c.includeChildren, "children", false, "process all children of all selected kapps as well, "+
+		"including those in other manifests")
+	f.BoolVar(&c.includeChildren, "descendants", false, "alias for --children")
+	f.BoolVar(&c.onlyMarked, "only", false, "only process selected kapps. Their parents won't be run "+
+		"to load their outputs. Their last known outputs will be used instead if they were cached by a previous run")
+	f.StringVar(&c.failurePolicy, "failure-policy", constants.FailurePolicyFailFast,
+		fmt.Sprintf("what to do if a kapp fails. '%s' stops processing kapps immediately. '%s' skips kapps "+
+			"that depend on the failed kapp but carries on processing the others", constants.FailurePolicyFailFast,
+			constants.FailurePolicyContinue))
 	f.BoolVarP(&c.skipTemplating, "no-template", "t", false, "skip writing templates for kapps before deleting them")
 	f.BoolVar(&c.skipPreActions, "no-pre-actions", false, "skip running pre actions in kapps")
 	f.BoolVar(&c.skipPostActions, "no-post-actions", false, "skip running post actions in kapps - useful to quickly tear down a cluster")
 	f.BoolVar(&c.establishConnection, "connect", false, "establish a connection to the API server if it's not publicly accessible")
+	f.BoolVar(&c.forceUnlock, "force-unlock", false, "release the lock on the stack held by another run before acquiring it")
 	f.StringVar(&c.provider, "provider", "", "name of provider, e.g. aws, local, etc.")
 	f.StringVar(&c.provisioner, "provisioner", "", "name of provisioner, e.g. kops, minikube, etc.")
 	f.StringVar(&c.profile, "profile", "", "launch profile, e.g. dev, test, prod, etc.")
@@ -142,19 +161,23 @@ func (c *deleteCmd) run() error {
 		return errors.WithStack(err)
 	}
 
+	runLock, err = lock.AcquireForStack(stackObj, c.forceUnlock)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer releaseLock()
+
 	dryRunPrefix := ""
 	if c.dryRun {
 		dryRunPrefix = "[Dry run] "
 	}
 
 	dagObj, err := BuildDagForSelected(stackObj, c.cacheDir, c.includeSelector, c.excludeSelector,
-		c.includeParents, "", c.out)
+		c.includeParents, c.includeChildren, "", c.out)
 	if err != nil {
 		return errors.WithStack(err)
 	}
-
-	// this increase the sleep interval since this may take a while
-	dagObj.SleepInterval = 500 * time.Millisecond
+	dagObj.OnlyMarked = c.onlyMarked
 
 	if c.establishConnection {
 		err = establishConnection(c.dryRun, dryRunPrefix)
@@ -177,8 +200,22 @@ func (c *deleteCmd) run() error {
 		}
 	}
 
-	err = dagObj.Execute(constants.DagActionDelete, stackObj, shouldPlan, approved, c.skipPreActions,
-		c.skipPostActions, c.ignoreErrors, c.dryRun)
+	summary, err := dagObj.Execute(runCtx, constants.DagActionDelete, stackObj, shouldPlan, approved, c.skipPreActions,
+		c.skipPostActions, c.ignoreErrors, c.dryRun, c.failurePolicy)
+	reportErr := writeReports(summary, c.reportPath, c.junitReportPath)
+	if summary != nil {
+		err2 := summary.Print(c.out)
+		if err2 != nil {
+			return errors.WithStack(err2)
+		}
+	}
+	if c.onlyMarked {
+		err2 := dagObj.Outputs.PrintWarnings(c.out)
+		if err2 != nil {
+			return errors.WithStack(err2)
+		}
+	}
+	err = executeError(err, reportErr)
 	if err != nil {
 		return errors.WithStack(err)
 	}
@@ -0,0 +1,164 @@
+/*
+ * Copyright 2019 The Sugarkube Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package kapps
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"github.com/sugarkube/sugarkube/internal/pkg/constants"
+	"github.com/sugarkube/sugarkube/internal/pkg/log"
+	"github.com/sugarkube/sugarkube/internal/pkg/stack"
+	"github.com/sugarkube/sugarkube/internal/pkg/structs"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+type graphCmd struct {
+	out             io.Writer
+	cacheDir        string
+	format          string
+	outputFile      string
+	includeParents  bool
+	includeChildren bool
+	stackName       string
+	stackFile       string
+	provider        string
+	provisioner     string
+	profile         string
+	account         string
+	cluster         string
+	region          string
+	includeSelector []string
+	excludeSelector []string
+}
+
+func newGraphCmd(out io.Writer) *cobra.Command {
+	c := &graphCmd{
+		out: out,
+	}
+
+	cmd := &cobra.Command{
+		Use:   "graph [flags] [stack-file] [stack-name] [cache-dir]",
+		Short: fmt.Sprintf("Export the DAG of selected kapps"),
+		Long: `Exports the DAG that would be processed for the selected kapps.
+
+The DAG can be exported as a Graphviz DOT file, a Mermaid flowchart or as JSON.
+Each node includes the manifest the kapp is in, its state, whether it's marked
+for processing and its sources. Edges state whether they were created because
+of a kapp's 'depends_on' list or because its manifest is 'sequential'.
+
+If kapps have been installed before, the export also includes how long each
+kapp is expected to take and highlights the critical path through the DAG.
+
+For example, to render the DAG as an image run:
+
+  sugarkube kapps graph stacks.yaml dev1 workspaces/dev1 | dot -Tpng > dag.png
+`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) < 3 {
+				return errors.New("some required arguments are missing")
+			} else if len(args) > 3 {
+				return errors.New("too many arguments supplied")
+			}
+			c.stackFile = args[0]
+			c.stackName = args[1]
+			c.cacheDir = args[2]
+			return c.run()
+		},
+	}
+
+	f := cmd.Flags()
+	f.StringVar(&c.format, "format", constants.GraphFormatDot, fmt.Sprintf("format to export the DAG "+
+		"as. One of '%s', '%s' or '%s'", constants.GraphFormatDot, constants.GraphFormatMermaid,
+		constants.GraphFormatJson))
+	f.StringVarP(&c.outputFile, "output-file", "o", "", "path to write the DAG to. Defaults to stdout")
+	f.BoolVar(&c.includeParents, "parents", false, "process all parents of all selected kapps as well")
+	f.BoolVar(&c.includeChildren, "children", false, "process all children of all selected kapps as well, "+
+		"including those in other manifests")
+	f.BoolVar(&c.includeChildren, "descendants", false, "alias for --children")
+	f.StringVar(&c.provider, "provider", "", "name of provider, e.g. aws, local, etc.")
+	f.StringVar(&c.provisioner, "provisioner", "", "name of provisioner, e.g. kops, minikube, etc.")
+	f.StringVar(&c.profile, "profile", "", "launch profile, e.g. dev, test, prod, etc.")
+	f.StringVarP(&c.cluster, "cluster", "c", "", "name of cluster to launch, e.g. dev1, dev2, etc.")
+	f.StringVarP(&c.account, "account", "a", "", "string identifier for the account to launch in (for providers that support it)")
+	f.StringVarP(&c.region, "region", "r", "", "name of region (for providers that support it)")
+	f.StringArrayVarP(&c.includeSelector, "include", "i", []string{},
+		fmt.Sprintf("only process specified kapps (can specify multiple, formatted 'manifest-id:kapp-id' or 'manifest-id:%s' for all)",
+			constants.WildcardCharacter))
+	f.StringArrayVarP(&c.excludeSelector, "exclude", "x", []string{},
+		fmt.Sprintf("exclude individual kapps (can specify multiple, formatted 'manifest-id:kapp-id' or 'manifest-id:%s' for all)",
+			constants.WildcardCharacter))
+	return cmd
+}
+
+func (c *graphCmd) run() error {
+
+	// CLI overrides - will be merged with any loaded from a stack config file
+	cliStackConfig := &structs.StackFile{
+		Provider:    c.provider,
+		Provisioner: c.provisioner,
+		Profile:     c.profile,
+		Cluster:     c.cluster,
+		Region:      c.region,
+		Account:     c.account,
+	}
+
+	stackObj, err := stack.BuildStack(c.stackName, c.stackFile, cliStackConfig, c.out)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	// don't print the usual summary of the DAG when writing to stdout because it'd corrupt the export
+	dagOut := c.out
+	if c.outputFile == "" {
+		dagOut = ioutil.Discard
+	}
+
+	dagObj, err := BuildDagForSelected(stackObj, c.cacheDir, c.includeSelector, c.excludeSelector,
+		c.includeParents, c.includeChildren, "", dagOut)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	writer := c.out
+	if c.outputFile != "" {
+		file, err := os.Create(c.outputFile)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		defer file.Close()
+		writer = file
+	}
+
+	graphObj, err := dagObj.Export()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	err = graphObj.Write(writer, c.format)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if c.outputFile != "" {
+		log.Logger.Infof("Wrote DAG to '%s'", c.outputFile)
+	}
+
+	return nil
+}
@@ -22,27 +22,35 @@ import (
 	"github.com/spf13/cobra"
 	"github.com/sugarkube/sugarkube/internal/pkg/constants"
 	"github.com/sugarkube/sugarkube/internal/pkg/interfaces"
+	"github.com/sugarkube/sugarkube/internal/pkg/lock"
 	"github.com/sugarkube/sugarkube/internal/pkg/log"
 	"github.com/sugarkube/sugarkube/internal/pkg/plan"
 	"github.com/sugarkube/sugarkube/internal/pkg/provisioner"
 	"github.com/sugarkube/sugarkube/internal/pkg/stack"
 	"github.com/sugarkube/sugarkube/internal/pkg/structs"
 	"io"
-	"time"
+	"os"
 )
 
 type installCmd struct {
-	out      io.Writer
-	cacheDir string
-	dryRun   bool
-	approved bool
-	oneShot  bool
-	//force               bool
+	out                 io.Writer
+	forceUnlock         bool
+	cacheDir            string
+	dryRun              bool
+	approved            bool
+	oneShot             bool
+	force               bool
 	skipTemplating      bool
 	skipPreActions      bool
 	skipPostActions     bool
 	establishConnection bool
 	includeParents      bool
+	includeChildren     bool
+	onlyMarked          bool
+	resume              bool
+	failurePolicy       string
+	reportPath          string
+	junitReportPath     string
 	stackName           string
 	stackFile           string
 	provider            string
@@ -88,6 +96,17 @@ SSH port forwarding via a bastion). This happens automatically when a cluster
 is created or updated by Sugarkube, but if you're installing individual kapps 
 you may need to pass the '--connect' flag to make Sugarkube go through that
 process before installing the selected kapps.
+
+Each kapp that's successfully installed is recorded in a journal in the cache
+directory. If a run fails part-way through, rerun it passing '--resume' to skip
+kapps that have already been installed with identical inputs. Their outputs
+will be loaded from the journal so their descendants can still use them.
+
+When kapps are installed with '--yes' or '--one-shot', a fingerprint of their
+sources, rendered templates, vars and installer args is stored in their cache
+directory. Kapps whose fingerprints haven't changed since they were last
+installed in the stack aren't installed again, but their outputs are still
+loaded. Pass '--force' to install them anyway.
 `,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			if len(args) < 3 {
@@ -118,17 +137,32 @@ process before installing the selected kapps.
 
 	f := cmd.Flags()
 	f.BoolVarP(&c.dryRun, "dry-run", "n", false, "show what would happen but don't create a cluster")
+	f.StringVar(&c.reportPath, "report", "", "write a JSON report of the outcome of processing each kapp to this file")
+	f.StringVar(&c.junitReportPath, "junit-report", "", "write a JUnit XML report of the outcome of processing each "+
+		"kapp to this file")
 	f.BoolVarP(&c.approved, "yes", "y", false, "actually install kapps. If false, kapps will be expected to plan "+
 		"their changes but not make any destrucive changes (e.g. should run 'terraform plan', etc. but not apply it).")
 	f.BoolVar(&c.oneShot, "one-shot", false, "invoke each kapp with 'APPROVED=false' then "+
 		"'APPROVED=true' to install kapps in a single pass")
 	f.BoolVar(&c.includeParents, "parents", false, "process all parents of all selected kapps as well")
-	//f.BoolVar(&c.force, "force", false, "don't require a cluster diff, just blindly install/delete all the kapps "+
-	//	"defined in a manifest(s)/stack config, even if they're already present/absent in the target cluster")
+	f.BoolVar(&c.includeChildren, "children", false, "process all children of all selected kapps as well, "+
+		"including those in other manifests")
+	f.BoolVar(&c.includeChildren, "descendants", false, "alias for --children")
+	f.BoolVar(&c.onlyMarked, "only", false, "only process selected kapps. Their parents won't be run "+
+		"to load their outputs. Their last known outputs will be used instead if they were cached by a previous run")
+	f.StringVar(&c.failurePolicy, "failure-policy", constants.FailurePolicyFailFast,
+		fmt.Sprintf("what to do if a kapp fails. '%s' stops processing kapps immediately. '%s' skips kapps "+
+			"that depend on the failed kapp but carries on processing the others", constants.FailurePolicyFailFast,
+			constants.FailurePolicyContinue))
+	f.BoolVar(&c.resume, "resume", false, "skip kapps that were successfully installed by a previous run "+
+		"with identical inputs, loading their outputs from the journal instead")
+	f.BoolVar(&c.force, "force", false, "install kapps even if they haven't changed since they were last "+
+		"installed in this stack")
 	f.BoolVarP(&c.skipTemplating, "no-template", "t", false, "skip writing templates for kapps before installing them")
 	f.BoolVar(&c.skipPreActions, "no-pre-actions", false, "skip running pre actions in kapps")
 	f.BoolVar(&c.skipPostActions, "no-post-actions", false, "skip running post actions in kapps")
 	f.BoolVar(&c.establishConnection, "connect", false, "establish a connection to the API server if it's not publicly accessible")
+	f.BoolVar(&c.forceUnlock, "force-unlock", false, "release the lock on the stack held by another run before acquiring it")
 	f.StringVar(&c.provider, "provider", "", "name of provider, e.g. aws, local, etc.")
 	f.StringVar(&c.provisioner, "provisioner", "", "name of provisioner, e.g. kops, minikube, etc.")
 	f.StringVar(&c.profile, "profile", "", "launch profile, e.g. dev, test, prod, etc.")
@@ -165,6 +199,12 @@ func (c *installCmd) run() error {
 		return errors.WithStack(err)
 	}
 
+	runLock, err = lock.AcquireForStack(stackObj, c.forceUnlock)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer releaseLock()
+
 	stackObj.GetConfig().SetReadyTimeout(c.readyTimeout)
 	stackObj.GetConfig().SetOnlineTimeout(c.onlineTimeout)
 
@@ -174,13 +214,11 @@ func (c *installCmd) run() error {
 	}
 
 	dagObj, err := BuildDagForSelected(stackObj, c.cacheDir, c.includeSelector, c.excludeSelector,
-		c.includeParents, constants.PresentKey, c.out)
+		c.includeParents, c.includeChildren, constants.PresentKey, c.out)
 	if err != nil {
 		return errors.WithStack(err)
 	}
-
-	// this increase the sleep interval since this may take a while
-	dagObj.SleepInterval = 500 * time.Millisecond
+	dagObj.OnlyMarked = c.onlyMarked
 
 	if c.establishConnection {
 		err = establishConnection(c.dryRun, dryRunPrefix)
@@ -203,8 +241,29 @@ func (c *installCmd) run() error {
 		}
 	}
 
-	err = dagObj.Execute(constants.DagActionInstall, stackObj, shouldPlan, approved,
-		c.skipPreActions, c.skipPostActions, false, c.dryRun)
+	journal, err := plan.NewJournal(c.cacheDir, c.resume)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	dagObj.Journal = journal
+	dagObj.Fingerprints = plan.NewFingerprints(stackObj.GetConfig().GetName(), c.force)
+
+	summary, err := dagObj.Execute(runCtx, constants.DagActionInstall, stackObj, shouldPlan, approved,
+		c.skipPreActions, c.skipPostActions, false, c.dryRun, c.failurePolicy)
+	reportErr := writeReports(summary, c.reportPath, c.junitReportPath)
+	if summary != nil {
+		err2 := summary.Print(c.out)
+		if err2 != nil {
+			return errors.WithStack(err2)
+		}
+	}
+	if c.onlyMarked {
+		err2 := dagObj.Outputs.PrintWarnings(c.out)
+		if err2 != nil {
+			return errors.WithStack(err2)
+		}
+	}
+	err = executeError(err, reportErr)
 	if err != nil {
 		return errors.WithStack(err)
 	}
@@ -220,7 +279,8 @@ func (c *installCmd) run() error {
 // Creates a DAG for installables matched by selectors. If an optional state (e.g. present, absent, etc.) is
 // provided, only installables with the same state will be included in the returned DAG
 func BuildDagForSelected(stackObj interfaces.IStack, cacheDir string, includeSelector []string,
-	excludeSelector []string, includeParents bool, stateFilter string, out io.Writer) (*plan.Dag, error) {
+	excludeSelector []string, includeParents bool, includeChildren bool, stateFilter string,
+	out io.Writer) (*plan.Dag, error) {
 	// load configs for all installables in the stack
 	err := stackObj.LoadInstallables(cacheDir)
 	if err != nil {
@@ -249,8 +309,36 @@ func BuildDagForSelected(stackObj interfaces.IStack, cacheDir string, includeSel
 		}
 	}
 
-	dagObj, err := plan.Create(stackObj.GetConfig().Manifests(), filteredInstallableIds,
-		includeParents)
+	// find excluded kapps so they won't be processed if they're parents or children of selected kapps
+	excludedInstallableIds := make([]string, 0)
+	for _, manifest := range stackObj.GetConfig().Manifests() {
+		for _, installableObj := range manifest.Installables() {
+			for _, selector := range excludeSelector {
+				match, err := stack.MatchesSelector(installableObj, selector)
+				if err != nil {
+					return nil, errors.WithStack(err)
+				}
+
+				if match {
+					excludedInstallableIds = append(excludedInstallableIds, installableObj.FullyQualifiedId())
+					break
+				}
+			}
+		}
+	}
+
+	dagObj, err := plan.Create(stackObj, stackObj.GetConfig().Manifests(), filteredInstallableIds,
+		excludedInstallableIds, includeParents, includeChildren)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	dagObj.Outputs, err = plan.NewOutputCache(cacheDir)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	dagObj.Durations, err = plan.NewDurations(cacheDir, stackObj.GetConfig().GetName())
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
@@ -281,3 +369,52 @@ func establishConnection(dryRun bool, dryRunPrefix string) error {
 
 	return nil
 }
+
+// Writes reports of the outcome of executing the DAG to the given paths. Reports aren't written for
+// paths that are empty.
+// Returns the error from executing the DAG, or if there wasn't one the error from writing reports.
+// Errors writing reports are logged so they don't hide why processing kapps failed.
+func executeError(err error, reportErr error) error {
+	if err == nil {
+		return reportErr
+	}
+
+	if reportErr != nil {
+		log.Logger.Errorf("Error writing reports: %v", reportErr)
+	}
+
+	return err
+}
+
+func writeReports(summary *plan.Summary, jsonPath string, junitPath string) error {
+	if summary == nil {
+		return nil
+	}
+
+	for format, path := range map[string]string{
+		constants.ReportFormatJson:  jsonPath,
+		constants.ReportFormatJunit: junitPath,
+	} {
+		if path == "" {
+			continue
+		}
+
+		file, err := os.Create(path)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		err = summary.Report().Write(file, format)
+		closeErr := file.Close()
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		if closeErr != nil {
+			return errors.WithStack(closeErr)
+		}
+
+		log.Logger.Infof("Wrote %s report to '%s'", format, path)
+	}
+
+	return nil
+}
@@ -17,18 +17,29 @@
 package kapps
 
 import (
+	"context"
 	"fmt"
 	"github.com/spf13/cobra"
+	"github.com/sugarkube/sugarkube/internal/pkg/config"
 	"github.com/sugarkube/sugarkube/internal/pkg/interfaces"
+	"github.com/sugarkube/sugarkube/internal/pkg/lock"
 	"github.com/sugarkube/sugarkube/internal/pkg/log"
+	"github.com/sugarkube/sugarkube/internal/pkg/utils"
 	"io"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 )
 
 var stackObj interfaces.IStack
 
+// held while commands modify the stack so other runs can't modify it concurrently
+var runLock *lock.Lock
+
+// cancelled when sugarkube is asked to terminate so running kapps can be stopped gracefully
+var runCtx = context.Background()
+
 func NewKappsCmds(out io.Writer) *cobra.Command {
 
 	cmd := &cobra.Command{
@@ -37,19 +48,28 @@ func NewKappsCmds(out io.Writer) *cobra.Command {
 		Long:  `Install and uninstall kapps`,
 		PersistentPreRun: func(cmd *cobra.Command, args []string) {
 			log.Logger.Debug("Setting up signal handler")
-			// catch termination via CTRL-C
-			signals := make(chan os.Signal)
-			signal.Notify(signals, os.Interrupt, syscall.SIGTERM, syscall.SIGKILL)
+			gracePeriod := time.Duration(config.CurrentConfig.InterruptGracePeriod) * time.Second
+			var interrupt func(os.Signal)
+			runCtx, interrupt = utils.WithInterrupt(context.Background(), gracePeriod)
+
+			// catch termination via CTRL-C. SIGKILL can't be caught
+			signals := make(chan os.Signal, 2)
+			signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
 			go func() {
+				caught := <-signals
+				log.Logger.Infof("Caught %v. Will try to gracefully terminate. Running kapps "+
+					"will be given %s to exit. Send it again to exit immediately...", caught, gracePeriod)
+				interrupt(caught)
+
 				<-signals
-				log.Logger.Info("Caught termination signal. Will try to gracefully terminate...")
+				log.Logger.Info("Caught a second termination signal. Exiting immediately...")
+				releaseLock()
 				if stackObj != nil {
 					err2 := stackObj.GetProvisioner().Close()
 					if err2 != nil {
 						log.Logger.Fatal(err2)
 					}
 				}
-				log.Logger.Info("Graceful shutdown complete")
 				os.Exit(1)
 			}()
 		},
@@ -59,13 +79,25 @@ func NewKappsCmds(out io.Writer) *cobra.Command {
 		newTemplateCmd(out),
 		newInstallCmd(out),
 		newDeleteCmd(out),
+		newApplyCmd(out),
 		newCleanCmd(out),
 		newOutputCmd(out),
 		newVarsCmd(out),
 		newValidateCmd(out),
+		newGraphCmd(out),
+		newLogsCmd(out),
 	)
 
 	cmd.Aliases = []string{"kapp"}
 
 	return cmd
 }
+
+// Releases the lock on the stack if it's held. Errors are logged since there's nothing else we can
+// do about them
+func releaseLock() {
+	err := runLock.Release()
+	if err != nil {
+		log.Logger.Warnf("%v", err)
+	}
+}
@@ -0,0 +1,147 @@
+/*
+ * Copyright 2019 The Sugarkube Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package kapps
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"github.com/sugarkube/sugarkube/internal/pkg/constants"
+	"github.com/sugarkube/sugarkube/internal/pkg/plan"
+	"github.com/sugarkube/sugarkube/internal/pkg/utils"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+type logsCmd struct {
+	out      io.Writer
+	cacheDir string
+	kappId   string
+	runId    string
+	target   string
+	list     bool
+}
+
+func newLogsCmd(out io.Writer) *cobra.Command {
+	c := &logsCmd{
+		out: out,
+	}
+
+	cmd := &cobra.Command{
+		Use:   "logs [flags] [cache-dir] [kapp-id]",
+		Short: fmt.Sprintf("Display the logs of commands run for a kapp"),
+		Long: `Displays the command lines, env vars, stdout and stderr of commands run for a kapp by
+an installer. Logs are written to '.sugarkube/logs/<run-id>/<target>.log' in each kapp's cache
+directory. The kapp ID must be fully qualified, i.e. formatted manifest-id:kapp-id. Logs from the
+latest run are displayed unless a run ID is given.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) < 2 {
+				return errors.New("some required arguments are missing")
+			} else if len(args) > 2 {
+				return errors.New("too many arguments supplied")
+			}
+			c.cacheDir = args[0]
+			c.kappId = args[1]
+			return c.run()
+		},
+	}
+
+	f := cmd.Flags()
+	f.StringVar(&c.runId, "run", "", "ID of the run to display logs for. Defaults to the latest run")
+	f.StringVarP(&c.target, "target", "t", "", "only display the log for this installer target, "+
+		"e.g. install, delete, output, etc.")
+	f.BoolVar(&c.list, "list", false, "list the IDs of runs there are logs for instead of "+
+		"displaying logs")
+	return cmd
+}
+
+func (c *logsCmd) run() error {
+	idParts := strings.Split(c.kappId, constants.NamespaceSeparator)
+	if len(idParts) != 2 || idParts[0] == "" || idParts[1] == "" {
+		return errors.New(fmt.Sprintf("Invalid kapp ID '%s'. Kapp IDs must be formatted "+
+			"manifest-id%skapp-id", c.kappId, constants.NamespaceSeparator))
+	}
+
+	absCacheDir, err := filepath.Abs(c.cacheDir)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	kappCacheDir := filepath.Join(absCacheDir, idParts[0], idParts[1])
+
+	runIds, err := plan.KappLogRuns(kappCacheDir)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if c.list {
+		for _, runId := range runIds {
+			_, err = fmt.Fprintln(c.out, runId)
+			if err != nil {
+				return errors.WithStack(err)
+			}
+		}
+		return nil
+	}
+
+	if len(runIds) == 0 {
+		return errors.New(fmt.Sprintf("No logs found for kapp '%s' in '%s'", c.kappId,
+			plan.KappLogsDir(kappCacheDir)))
+	}
+
+	runId := c.runId
+	if runId == "" {
+		runId = runIds[len(runIds)-1]
+	} else if !utils.InStringArray(runIds, runId) {
+		return errors.New(fmt.Sprintf("No logs found for run '%s' of kapp '%s'. Runs with logs "+
+			"are: %s", runId, c.kappId, strings.Join(runIds, ", ")))
+	}
+
+	runDir := filepath.Join(plan.KappLogsDir(kappCacheDir), runId)
+	logPaths, err := filepath.Glob(filepath.Join(runDir, "*.log"))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if c.target != "" {
+		logPath := filepath.Join(runDir, c.target+".log")
+		if !utils.InStringArray(logPaths, logPath) {
+			return errors.New(fmt.Sprintf("No log found for target '%s' in run '%s' of kapp '%s'",
+				c.target, runId, c.kappId))
+		}
+		logPaths = []string{logPath}
+	}
+
+	sort.Strings(logPaths)
+
+	for _, logPath := range logPaths {
+		contents, err := ioutil.ReadFile(logPath)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		_, err = fmt.Fprintf(c.out, "==> Run %s, target '%s' <==\n%s", runId,
+			strings.TrimSuffix(filepath.Base(logPath), ".log"), contents)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	}
+
+	return nil
+}
@@ -21,6 +21,7 @@ import (
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
 	"github.com/sugarkube/sugarkube/internal/pkg/constants"
+	"github.com/sugarkube/sugarkube/internal/pkg/lock"
 	"github.com/sugarkube/sugarkube/internal/pkg/stack"
 	"github.com/sugarkube/sugarkube/internal/pkg/structs"
 	"io"
@@ -28,9 +29,13 @@ import (
 
 type outputCmd struct {
 	out             io.Writer
+	forceUnlock     bool
 	cacheDir        string
 	dryRun          bool
 	includeParents  bool
+	includeChildren bool
+	reportPath      string
+	junitReportPath string
 	stackName       string
 	stackFile       string
 	provider        string
@@ -69,7 +74,14 @@ func newOutputCmd(out io.Writer) *cobra.Command {
 
 	f := cmd.Flags()
 	f.BoolVarP(&c.dryRun, "dry-run", "n", false, "show what would happen but don't create a cluster")
+	f.StringVar(&c.reportPath, "report", "", "write a JSON report of the outcome of processing each kapp to this file")
+	f.StringVar(&c.junitReportPath, "junit-report", "", "write a JUnit XML report of the outcome of processing each "+
+		"kapp to this file")
 	f.BoolVar(&c.includeParents, "parents", false, "process all parents of all selected kapps as well")
+	f.BoolVar(&c.includeChildren, "children", false, "process all children of all selected kapps as well, "+
+		"including those in other manifests")
+	f.BoolVar(&c.includeChildren, "descendants", false, "alias for --children")
+	f.BoolVar(&c.forceUnlock, "force-unlock", false, "release the lock on the stack held by another run before acquiring it")
 	f.StringVar(&c.provider, "provider", "", "name of provider, e.g. aws, local, etc.")
 	f.StringVar(&c.provisioner, "provisioner", "", "name of provisioner, e.g. kops, minikube, etc.")
 	f.StringVar(&c.profile, "profile", "", "launch profile, e.g. dev, test, prod, etc.")
@@ -104,19 +116,27 @@ func (c *outputCmd) run() error {
 		return errors.WithStack(err)
 	}
 
+	runLock, err = lock.AcquireForStack(stackObj, c.forceUnlock)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer releaseLock()
+
 	dryRunPrefix := ""
 	if c.dryRun {
 		dryRunPrefix = "[Dry run] "
 	}
 
 	dagObj, err := BuildDagForSelected(stackObj, c.cacheDir, c.includeSelector, c.excludeSelector,
-		c.includeParents, constants.PresentKey, c.out)
+		c.includeParents, c.includeChildren, constants.PresentKey, c.out)
 	if err != nil {
 		return errors.WithStack(err)
 	}
 
-	err = dagObj.Execute(constants.DagActionOutput, stackObj, false, true, true,
-		true, false, c.dryRun)
+	summary, err := dagObj.Execute(runCtx, constants.DagActionOutput, stackObj, false, true, true,
+		true, false, c.dryRun, constants.FailurePolicyFailFast)
+	reportErr := writeReports(summary, c.reportPath, c.junitReportPath)
+	err = executeError(err, reportErr)
 	if err != nil {
 		return errors.WithStack(err)
 	}
@@ -21,6 +21,7 @@ import (
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
 	"github.com/sugarkube/sugarkube/internal/pkg/constants"
+	"github.com/sugarkube/sugarkube/internal/pkg/lock"
 	"github.com/sugarkube/sugarkube/internal/pkg/stack"
 	"github.com/sugarkube/sugarkube/internal/pkg/structs"
 	"io"
@@ -28,8 +29,13 @@ import (
 
 type templateConfig struct {
 	out             io.Writer
+	forceUnlock     bool
 	dryRun          bool
 	includeParents  bool
+	includeChildren bool
+	onlyMarked      bool
+	reportPath      string
+	junitReportPath string
 	ignoreErrors    bool
 	cacheDir        string
 	stackName       string
@@ -71,8 +77,17 @@ configured for the region the target cluster is in, generating Helm
 
 	f := cmd.Flags()
 	f.BoolVarP(&c.dryRun, "dry-run", "n", false, "show what would happen but don't create a cluster")
+	f.StringVar(&c.reportPath, "report", "", "write a JSON report of the outcome of processing each kapp to this file")
+	f.StringVar(&c.junitReportPath, "junit-report", "", "write a JUnit XML report of the outcome of processing each "+
+		"kapp to this file")
 	f.BoolVar(&c.includeParents, "parents", false, "process all parents of all selected kapps as well")
+	f.BoolVar(&c.includeChildren, "children", false, "process all children of all selected kapps as well, "+
+		"including those in other manifests")
+	f.BoolVar(&c.includeChildren, "descendants", false, "alias for --children")
+	f.BoolVar(&c.onlyMarked, "only", false, "only process selected kapps. Their parents won't be run "+
+		"to load their outputs. Their last known outputs will be used instead if they were cached by a previous run")
 	f.BoolVar(&c.ignoreErrors, "ignore-errors", false, "ignore errors templating kapps")
+	f.BoolVar(&c.forceUnlock, "force-unlock", false, "release the lock on the stack held by another run before acquiring it")
 	f.StringVar(&c.provider, "provider", "", "name of provider, e.g. aws, local, etc.")
 	f.StringVar(&c.provisioner, "provisioner", "", "name of provisioner, e.g. kops, minikube, etc.")
 	f.StringVar(&c.profile, "profile", "", "launch profile, e.g. dev, test, prod, etc.")
@@ -105,15 +120,30 @@ func (c *templateConfig) run() error {
 		return errors.WithStack(err)
 	}
 
+	runLock, err = lock.AcquireForStack(stackObj, c.forceUnlock)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer releaseLock()
+
 	// create a DAG to template all the kapps
 	dagObj, err := BuildDagForSelected(stackObj, c.cacheDir, c.includeSelector, c.excludeSelector,
-		c.includeParents, "", c.out)
+		c.includeParents, c.includeChildren, "", c.out)
 	if err != nil {
 		return errors.WithStack(err)
 	}
+	dagObj.OnlyMarked = c.onlyMarked
 
-	err = dagObj.Execute(constants.DagActionTemplate, stackObj, false, true, true,
-		true, c.ignoreErrors, c.dryRun)
+	summary, err := dagObj.Execute(runCtx, constants.DagActionTemplate, stackObj, false, true, true,
+		true, c.ignoreErrors, c.dryRun, constants.FailurePolicyFailFast)
+	reportErr := writeReports(summary, c.reportPath, c.junitReportPath)
+	if c.onlyMarked {
+		err2 := dagObj.Outputs.PrintWarnings(c.out)
+		if err2 != nil {
+			return errors.WithStack(err2)
+		}
+	}
+	err = executeError(err, reportErr)
 	if err != nil {
 		return errors.WithStack(err)
 	}
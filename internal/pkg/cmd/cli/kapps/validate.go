@@ -24,6 +24,7 @@ type validateConfig struct {
 	account         string
 	cluster         string
 	region          string
+	includeChildren bool
 	includeSelector []string
 	excludeSelector []string
 }
@@ -51,6 +52,9 @@ func newValidateCmd(out io.Writer) *cobra.Command {
 	}
 
 	f := cmd.Flags()
+	f.BoolVar(&c.includeChildren, "children", false, "process all children of all selected kapps as well, "+
+		"including those in other manifests")
+	f.BoolVar(&c.includeChildren, "descendants", false, "alias for --children")
 	f.StringVar(&c.provider, "provider", "", "name of provider, e.g. aws, local, etc.")
 	f.StringVar(&c.provisioner, "provisioner", "", "name of provisioner, e.g. kops, minikube, etc.")
 	f.StringVar(&c.profile, "profile", "", "launch profile, e.g. dev, test, prod, etc.")
@@ -84,7 +88,7 @@ func (c *validateConfig) run() error {
 	}
 
 	dagObj, err := BuildDagForSelected(stackObj, c.cacheDir, c.includeSelector, c.excludeSelector,
-		false, "", c.out)
+		false, c.includeChildren, "", c.out)
 	if err != nil {
 		return errors.WithStack(err)
 	}
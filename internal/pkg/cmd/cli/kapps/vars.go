@@ -22,6 +22,8 @@ type varsConfig struct {
 	cluster         string
 	region          string
 	includeParents  bool
+	includeChildren bool
+	onlyMarked      bool
 	skipOutputs     bool
 	includeSelector []string
 	excludeSelector []string
@@ -53,6 +55,11 @@ templated sugarkube.yaml file.`,
 
 	f := cmd.Flags()
 	f.BoolVar(&c.includeParents, "parents", false, "process all parents of all selected kapps as well")
+	f.BoolVar(&c.includeChildren, "children", false, "process all children of all selected kapps as well, "+
+		"including those in other manifests")
+	f.BoolVar(&c.includeChildren, "descendants", false, "alias for --children")
+	f.BoolVar(&c.onlyMarked, "only", false, "only process selected kapps. Their parents won't be run "+
+		"to load their outputs. Their last known outputs will be used instead if they were cached by a previous run")
 	f.BoolVar(&c.skipOutputs, "skip-outputs", false, "don't load outputs from parents")
 	f.StringVar(&c.provider, "provider", "", "name of provider, e.g. aws, local, etc.")
 	f.StringVar(&c.provisioner, "provisioner", "", "name of provisioner, e.g. kops, minikube, etc.")
@@ -89,12 +96,19 @@ func (c *varsConfig) run() error {
 	}
 
 	dagObj, err := BuildDagForSelected(stackObj, c.cacheDir, c.includeSelector, c.excludeSelector,
-		c.includeParents, "", c.out)
+		c.includeParents, c.includeChildren, "", c.out)
 	if err != nil {
 		return errors.WithStack(err)
 	}
+	dagObj.OnlyMarked = c.onlyMarked
 
-	err = dagObj.ExecuteGetVars(constants.DagActionVars, stackObj, !c.skipOutputs, c.suppress)
+	err = dagObj.ExecuteGetVars(runCtx, constants.DagActionVars, stackObj, !c.skipOutputs, c.suppress)
+	if c.onlyMarked {
+		err2 := dagObj.Outputs.PrintWarnings(c.out)
+		if err2 != nil {
+			return errors.WithStack(err2)
+		}
+	}
 	if err != nil {
 		return errors.WithStack(err)
 	}
@@ -50,7 +50,15 @@ func initViper(appName string) *viper.Viper {
 	v.SetDefault("json-logs", false)
 	v.SetDefault("log-level", "info")
 	v.SetDefault("num-workers", "5")
+	v.SetDefault("heartbeat-interval", "30")
+	v.SetDefault("interrupt-grace-period", "30")
+	v.SetDefault("stream-output", true)
+	v.SetDefault("colour-output", false)
+	v.SetDefault("kapp-logs.max-runs", "10")
+	v.SetDefault("kapp-logs.max-age", "0")
 	v.SetDefault("overwrite-merged-lists", false)
+	v.SetDefault("lock.backend", "file")
+	v.SetDefault("lock.ttl", "0")
 
 	v.SetConfigName(ConfigFileName)
 
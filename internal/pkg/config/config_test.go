@@ -36,7 +36,19 @@ func TestLoadConfig(t *testing.T) {
 		JsonLogs:             false,
 		LogLevel:             "warn",
 		NumWorkers:           5,
+		HeartbeatInterval:    30,
+		InterruptGracePeriod: 30,
+		StreamOutput:         true,
 		OverwriteMergedLists: false,
+		ConcurrencyGroups: map[string]int{
+			"terraform": 1,
+		},
+		KappLogs: KappLogsConfig{
+			MaxRuns: 10,
+		},
+		Lock: LockConfig{
+			Backend: "file",
+		},
 		Programs: map[string]structs.KappConfig{
 			"helm": {
 				EnvVars: map[string]interface{}{
@@ -22,8 +22,42 @@ type Config struct {
 	JsonLogs   bool   `mapstructure:"json-logs"`
 	LogLevel   string `mapstructure:"log-level"`
 	NumWorkers int    `mapstructure:"num-workers"` // an uncontroversial name that avoids British/American spelling differences (vs 'parallelisation', etc)
+	// number of seconds between printing the progress of processing the DAG. 0 disables it
+	HeartbeatInterval int `mapstructure:"heartbeat-interval"`
+	// number of seconds to give running kapps to exit after sugarkube is interrupted before killing them
+	InterruptGracePeriod int `mapstructure:"interrupt-grace-period"`
+	// if true, output from commands run for kapps is printed line by line as it's written, prefixed
+	// with the kapp's fully-qualified ID
+	StreamOutput bool `mapstructure:"stream-output"`
+	// if true, the prefixes of streamed output are coloured, with each kapp always getting the same colour
+	ColourOutput bool `mapstructure:"colour-output"`
+	// how long to keep the logs of commands run for kapps for
+	KappLogs KappLogsConfig `mapstructure:"kapp-logs"`
 	// if true, merging lists under the same map key will replace the existing list entirely. If false,
 	// values from lists being merged in will be appended to the existing list
 	OverwriteMergedLists bool                          `mapstructure:"overwrite-merged-lists"`
 	Programs             map[string]structs.KappConfig `mapstructure:"programs"`
+	// max number of kapps in each concurrency group that can be processed at once. Keys are lowercased
+	ConcurrencyGroups map[string]int `mapstructure:"concurrency-groups"`
+	// where to store locks that prevent concurrent runs against the same stack
+	Lock LockConfig `mapstructure:"lock"`
+	// units that kapps can use by declaring a unit with the same ID. Keys are unit IDs
+	Units map[string]structs.Unit `mapstructure:"units"`
+	// directory to search for installer plugins in before searching the PATH
+	PluginDir string `mapstructure:"plugin-dir"`
+}
+
+type KappLogsConfig struct {
+	MaxRuns int `mapstructure:"max-runs"` // number of runs to keep logs for per kapp. 0 keeps all of them
+	MaxAge  int `mapstructure:"max-age"`  // number of days to keep logs for. 0 keeps them regardless of age
+}
+
+type LockConfig struct {
+	Backend string `mapstructure:"backend"` // one of 'file', 'http' or 'none'
+	// number of seconds after which a lock can be taken over by another run. 0 means locks never expire
+	Ttl      int    `mapstructure:"ttl"`
+	Dir      string `mapstructure:"dir"`     // directory for the file backend. Defaults to a temp dir
+	Address  string `mapstructure:"address"` // base URL for the http backend
+	Username string `mapstructure:"username"`
+	Password string `mapstructure:"password"`
 }
@@ -0,0 +1,38 @@
+/*
+ * Copyright 2019 The Sugarkube Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package constants
+
+// stop dispatching kapps as soon as one fails
+const FailurePolicyFailFast = "fail-fast"
+
+// skip kapps that depend on a failed kapp but carry on processing the rest of the DAG
+const FailurePolicyContinue = "continue"
+
+// edge types recording why a kapp depends on another
+const EdgeTypeDependsOn = "depends_on"
+const EdgeTypeSequential = "sequential"
+const EdgeTypeOptionalDependsOn = "optional_depends_on"
+const EdgeTypeRunsAfter = "runs_after" // orders kapps without passing the parent's outputs to the child
+
+// formats the DAG can be exported as
+const GraphFormatDot = "dot"
+const GraphFormatMermaid = "mermaid"
+const GraphFormatJson = "json"
+
+// formats reports of executing the DAG can be written in
+const ReportFormatJson = "json"
+const ReportFormatJunit = "junit"
@@ -6,6 +6,10 @@ const DagActionClean = "clean"
 const DagActionTemplate = "template"
 const DagActionOutput = "output"
 const DagActionVars = "vars"
+
+// installs present kapps and deletes absent ones in a single run
+const DagActionApply = "apply"
+
 const ActionClusterUpdate = "cluster_update"
 const ActionClusterDelete = "cluster_delete"
 const ActionAddProviderVarsFiles = "add_provider_vars_files"
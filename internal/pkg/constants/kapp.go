@@ -20,3 +20,23 @@ const KappConfigFileName = "sugarkube.yaml"
 const KappVarsKappKey = "kapp"
 const KappVarsVarsKey = "vars"
 const KappVarsTemplatesKey = "templates"
+
+// installers built into sugarkube. Kapps that name any other installer use an installer plugin
+const MakeInstaller = "make"
+const UnitsInstaller = "units"
+const TerraformInstaller = "terraform"
+const HelmInstaller = "helm"
+const ScriptInstaller = "script"
+
+var BuiltInInstallers = []string{MakeInstaller, UnitsInstaller, TerraformInstaller, HelmInstaller,
+	ScriptInstaller}
+
+// kapps using the terraform installer have an output with this ID containing the result of
+// `terraform output -json`. Its path is relative to the kapp's sugarkube.yaml file.
+const TerraformOutputId = "terraform"
+const TerraformOutputPath = "_generated_terraform_output.json"
+
+// kapps using installer plugins have an output with this ID containing the outputs returned by
+// the plugin. Its path is relative to the kapp's sugarkube.yaml file.
+const PluginOutputId = "plugin"
+const PluginOutputPath = "_generated_plugin_output.json"
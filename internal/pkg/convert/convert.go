@@ -98,6 +98,41 @@ func MapStringStringToMapStringInterface(input map[string]string) map[string]int
 	return output
 }
 
+// Recursively converts maps with interface keys (e.g. parsed from YAML) in a value to maps with
+// string keys so the value can be marshalled to JSON
+func ToJsonCompatible(input interface{}) (interface{}, error) {
+	switch value := input.(type) {
+	case map[interface{}]interface{}:
+		output, err := MapInterfaceInterfaceToMapStringInterface(value)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		return ToJsonCompatible(output)
+	case map[string]interface{}:
+		output := make(map[string]interface{}, len(value))
+		for k, v := range value {
+			converted, err := ToJsonCompatible(v)
+			if err != nil {
+				return nil, errors.WithStack(err)
+			}
+			output[k] = converted
+		}
+		return output, nil
+	case []interface{}:
+		output := make([]interface{}, len(value))
+		for i, v := range value {
+			converted, err := ToJsonCompatible(v)
+			if err != nil {
+				return nil, errors.WithStack(err)
+			}
+			output[i] = converted
+		}
+		return output, nil
+	default:
+		return input, nil
+	}
+}
+
 // Converts a map[string]interface{} to a map[string]string
 //func InterfaceToMapStringString(input interface{}) map[string]string {
 //	output := map[string]string{}
@@ -211,3 +211,30 @@ func TestMapInterfaceInterfaceToMapStringInterface(t *testing.T) {
 		}
 	}
 }
+
+func TestToJsonCompatible(t *testing.T) {
+	input := map[string]interface{}{
+		"str": "hello",
+		"sub": map[interface{}]interface{}{
+			"int": 3,
+			1.2:   []interface{}{map[interface{}]interface{}{"nested": true}, "world"},
+		},
+	}
+
+	expected := map[string]interface{}{
+		"str": "hello",
+		"sub": map[string]interface{}{
+			"int": 3,
+			"1.2": []interface{}{map[string]interface{}{"nested": true}, "world"},
+		},
+	}
+
+	result, err := ToJsonCompatible(input)
+	assert.Nil(t, err)
+	assert.Equal(t, expected, result)
+
+	_, err = ToJsonCompatible(map[interface{}]interface{}{
+		[2]string{"array", "key"}: "array keys can't be converted",
+	})
+	assert.NotNil(t, err)
+}
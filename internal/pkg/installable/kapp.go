@@ -46,6 +46,7 @@ type Kapp struct {
 	kappCacheDir     string                           // the top-level directory for this kapp in the cache, i.e. the directory containing the kapp's .sugarkube directory
 	localRegistry    interfaces.IRegistry             // a registry local to the kapp that contains the results of merging
 	// each of its parents' registries, tailored depending on whether parent was in the same manifest
+	renderedSensitiveTemplates []string // paths that sensitive templates have been rendered to
 }
 
 // Returns the non-fully qualified ID
@@ -199,12 +200,43 @@ func (k *Kapp) mergeDescriptorLayers() error {
 		}
 	}
 
+	// kapps installed by terraform output the result of `terraform output -json` and kapps installed
+	// by plugins output whatever the plugin returns, unless they declare their own output with the same ID
+	installerName := strings.ToLower(mergedDescriptor.Installer)
+	if installerName == constants.TerraformInstaller {
+		addImplicitOutput(&mergedDescriptor, constants.TerraformOutputId, constants.TerraformOutputPath)
+	} else if installerName != "" && !utils.InStringArray(constants.BuiltInInstallers, installerName) {
+		addImplicitOutput(&mergedDescriptor, constants.PluginOutputId, constants.PluginOutputPath)
+	}
+
 	k.mergedDescriptor = mergedDescriptor
 	log.Logger.Debugf("Set new merged descriptor for kapp '%s' to '%+v'", k.FullyQualifiedId(), mergedDescriptor)
 
 	return nil
 }
 
+// Adds a JSON output to a descriptor. If the descriptor already declares an output with the same ID
+// its settings take precedence, so kapps can e.g. mark the output as sensitive without needing to
+// repeat its path and format.
+func addImplicitOutput(descriptor *structs.KappDescriptorWithMaps, id string, path string) {
+	if descriptor.Outputs == nil {
+		descriptor.Outputs = make(map[string]structs.Output, 0)
+	}
+
+	output := descriptor.Outputs[id]
+	output.Id = id
+
+	if output.Path == "" {
+		output.Path = path
+	}
+
+	if output.Format == "" {
+		output.Format = "json"
+	}
+
+	descriptor.Outputs[id] = output
+}
+
 // Returns the merged descriptor, which is the result of merging all descriptors in the
 // list of descriptors
 func (k Kapp) GetDescriptor() structs.KappDescriptorWithMaps {
@@ -365,6 +397,26 @@ func (k Kapp) HasOutputs() bool {
 	return len(k.mergedDescriptor.Outputs) > 0
 }
 
+// Returns the paths of sensitive outputs and of any sensitive templates that have been rendered. The
+// files may not exist.
+func (k Kapp) SensitiveFiles() ([]string, error) {
+	paths := make([]string, 0)
+
+	for _, output := range k.mergedDescriptor.Outputs {
+		if !output.Sensitive {
+			continue
+		}
+
+		path, err := filepath.Abs(filepath.Join(k.configFileDir, output.Path))
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		paths = append(paths, path)
+	}
+
+	return append(paths, k.renderedSensitiveTemplates...), nil
+}
+
 // Returns the kapps local registry, which is the result of merging all its parents' local registries,
 // cleaned up to account for parents possibly being in different manifests. It doesn't include the
 // global registry though.
@@ -702,6 +754,10 @@ func (k *Kapp) RenderTemplates(templateVars map[string]interface{}, stackConfig
 			if err != nil {
 				return renderedPaths, errors.WithStack(err)
 			}
+
+			if templateDefinition.Sensitive && !utils.InStringArray(k.renderedSensitiveTemplates, destPath) {
+				k.renderedSensitiveTemplates = append(k.renderedSensitiveTemplates, destPath)
+			}
 		}
 
 		renderedPaths = append(renderedPaths, destPath)
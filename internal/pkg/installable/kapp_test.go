@@ -17,7 +17,10 @@
 package installable
 
 import (
+	"github.com/stretchr/testify/assert"
 	"github.com/sugarkube/sugarkube/internal/pkg/log"
+	"github.com/sugarkube/sugarkube/internal/pkg/structs"
+	"testing"
 )
 
 func init() {
@@ -139,3 +142,23 @@ func init() {
 //
 //	assert.Equal(t, expectedMergedVars, templatedVars)
 //}
+
+func TestAddImplicitOutput(t *testing.T) {
+	descriptor := structs.KappDescriptorWithMaps{}
+	addImplicitOutput(&descriptor, "terraform", "_generated_terraform_output.json")
+	assert.Equal(t, map[string]structs.Output{
+		"terraform": {Id: "terraform", Path: "_generated_terraform_output.json", Format: "json"},
+	}, descriptor.Outputs)
+
+	// declared settings take precedence
+	descriptor = structs.KappDescriptorWithMaps{
+		Outputs: map[string]structs.Output{
+			"terraform": {Id: "terraform", Sensitive: true},
+		},
+	}
+	addImplicitOutput(&descriptor, "terraform", "_generated_terraform_output.json")
+	assert.Equal(t, map[string]structs.Output{
+		"terraform": {Id: "terraform", Path: "_generated_terraform_output.json", Format: "json",
+			Sensitive: true},
+	}, descriptor.Outputs)
+}
@@ -0,0 +1,118 @@
+/*
+ * Copyright 2019 The Sugarkube Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package installable
+
+import (
+	"github.com/imdario/mergo"
+	"github.com/pkg/errors"
+	"github.com/sugarkube/sugarkube/internal/pkg/constants"
+	"github.com/sugarkube/sugarkube/internal/pkg/interfaces"
+	"github.com/sugarkube/sugarkube/internal/pkg/log"
+	"strings"
+)
+
+// Adds outputs from an installable to a registry under the keys templates can refer to them by
+func AddOutputsToRegistry(installableObj interfaces.IInstallable, outputs map[string]interface{},
+	registry interfaces.IRegistry) error {
+
+	// We convert kapp IDs to have underscores because Go's templating library throws its toys out
+	// the pram when it find a map key with a hyphen in. K8s is the opposite, so this seems like
+	// the least worst way of accommodating both
+	underscoredInstallableId := strings.Replace(installableObj.Id(), "-", "_", -1)
+	underscoredInstallableFQId := strings.Replace(installableObj.FullyQualifiedId(), "-", "_", -1)
+	underscoredInstallableFQId = strings.Replace(underscoredInstallableFQId, constants.NamespaceSeparator,
+		constants.TemplateNamespaceSeparator, -1)
+
+	prefixes := []string{
+		// "outputs.this"
+		strings.Join([]string{constants.RegistryKeyOutputs, constants.RegistryKeyThis}, constants.RegistryFieldSeparator),
+		// short prefix - can be used by other kapps in the manifest
+		strings.Join([]string{constants.RegistryKeyOutputs, underscoredInstallableId},
+			constants.RegistryFieldSeparator),
+		// fully-qualified prefix - can be used by kapps in other manifests
+		strings.Join([]string{constants.RegistryKeyOutputs,
+			underscoredInstallableFQId}, constants.RegistryFieldSeparator),
+	}
+
+	// store the output under various keys
+	for outputId, output := range outputs {
+		for _, prefix := range prefixes {
+			underscoredOutputId := strings.Replace(outputId, "-", "_", -1)
+			key := strings.Join([]string{prefix, underscoredOutputId}, constants.RegistryFieldSeparator)
+			err := registry.Set(key, output)
+			if err != nil {
+				return errors.WithStack(err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Renders templates for a kapp, templating its descriptor before and after so it can refer to
+// outputs and the paths of rendered templates
+func RenderKappTemplates(stackObj interfaces.IStack, installableObj interfaces.IInstallable,
+	installerVars map[string]interface{}, dryRun bool) error {
+
+	// merge all the vars required to render the kapp's sugarkube.yaml file
+	templatedVars, err := stackObj.GetTemplatedVars(installableObj, installerVars)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	// template the descriptor again in case variables refer to outputs
+	err = installableObj.TemplateDescriptor(templatedVars)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	// get the updated template vars
+	templatedVars, err = stackObj.GetTemplatedVars(installableObj, installerVars)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	renderedTemplatePaths, err := installableObj.RenderTemplates(templatedVars, stackObj.GetConfig(),
+		dryRun)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	// merge renderedTemplates into the templatedVars under the "kapp.templates" key. This will
+	// allow us to support writing files to temporary (dynamic) locations later if we like
+	renderedTemplatesMap := map[string]interface{}{
+		constants.KappVarsKappKey: map[string]interface{}{
+			constants.KappVarsTemplatesKey: renderedTemplatePaths,
+		},
+	}
+
+	log.Logger.Debugf("Merging rendered template paths into stack config: %#v",
+		renderedTemplatePaths)
+
+	err = mergo.Merge(&templatedVars, renderedTemplatesMap, mergo.WithOverride)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	// remerge and template the kapp's descriptor so it can access the paths of any rendered templates
+	err = installableObj.TemplateDescriptor(templatedVars)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return nil
+}
@@ -0,0 +1,304 @@
+/*
+ * Copyright 2019 The Sugarkube Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package installer
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/sugarkube/sugarkube/internal/pkg/interfaces"
+	"github.com/sugarkube/sugarkube/internal/pkg/log"
+	"github.com/sugarkube/sugarkube/internal/pkg/utils"
+	"os"
+	"path/filepath"
+)
+
+// Installs kapps by running helm directly, so they don't need a Makefile
+type HelmInstaller struct {
+	provider interfaces.IProvider
+}
+
+const defaultHelmBinary = "helm"
+
+// kapp vars the release is configured with
+const helmVarRelease = "release"
+const helmVarNamespace = "namespace"
+const helmVarKubeContext = "kube_context"
+const helmVarKubeconfig = "kubeconfig"
+
+// values files are looked for in the chart directory with this extension
+const helmValuesExtension = ".yaml"
+
+// values file in the directory containing a kapp's sugarkube.yaml file that's always passed to helm
+const helmBaseValuesFile = "values" + helmValuesExtension
+
+// Return the name of this installer
+func (i HelmInstaller) Name() string {
+	return HELM
+}
+
+// A release to run helm commands against
+type helmRelease struct {
+	name        string
+	chart       string
+	valuesFiles []string
+	flags       []string // flags selecting the cluster and namespace, passed to every command
+}
+
+// Runs helm for the given target. Installing without approval runs a dry run (or a diff) of
+// upgrading the release.
+func (i HelmInstaller) run(ctx context.Context, target string, installableObj interfaces.IInstallable,
+	stack interfaces.IStack, approved bool, dryRun bool) error {
+
+	switch target {
+	case TargetOutput, TargetClean:
+		log.Logger.Infof("The %s installer doesn't implement the %s target. Nothing to do for kapp '%s'",
+			i.Name(), target, installableObj.FullyQualifiedId())
+		return nil
+	}
+
+	release, err := newHelmRelease(installableObj, stack)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	envVars, err := commonEnvVars(i.provider, installableObj, stack, approved)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	helm := func(command string, args ...string) error {
+		return i.helm(ctx, installableObj, target, envVars, dryRun, command, args...)
+	}
+
+	switch target {
+	case TargetInstall:
+		upgradeArgs := []string{release.name, release.chart, "--install"}
+		upgradeArgs = append(upgradeArgs, release.flags...)
+		for _, valuesFile := range release.valuesFiles {
+			upgradeArgs = append(upgradeArgs, fmt.Sprintf("--values=%s", valuesFile))
+		}
+
+		if !approved {
+			if installableObj.GetDescriptor().Helm.Diff {
+				// the helm-diff plugin doesn't take the --install flag
+				diffArgs := append([]string{"upgrade", release.name, release.chart, "--allow-unreleased"},
+					upgradeArgs[3:]...)
+				err = helm("diff", diffArgs...)
+			} else {
+				err = helm("upgrade", append(upgradeArgs, "--dry-run")...)
+			}
+			if err != nil {
+				return errors.WithStack(err)
+			}
+			break
+		}
+
+		err = helm("upgrade", upgradeArgs...)
+		if err != nil {
+			if !installableObj.GetDescriptor().Helm.Rollback {
+				return errors.WithStack(err)
+			}
+
+			return i.rollback(installableObj, release, helm, err)
+		}
+	case TargetDelete:
+		deleteArgs := append([]string{release.name}, release.flags...)
+		if !approved {
+			deleteArgs = append(deleteArgs, "--dry-run")
+		}
+
+		err = helm("uninstall", deleteArgs...)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	}
+
+	log.Logger.Infof("Kapp '%s' successfully processed (approved=%v, dry run=%v)",
+		installableObj.FullyQualifiedId(), approved, dryRun)
+
+	return nil
+}
+
+// Rolls a release back to its previous revision after upgrading it failed. The upgrade error is
+// always returned, noting whether the rollback succeeded.
+func (i HelmInstaller) rollback(installableObj interfaces.IInstallable, release helmRelease,
+	helm func(command string, args ...string) error, upgradeErr error) error {
+
+	log.Logger.Warnf("Upgrading release '%s' of kapp '%s' failed. Rolling it back to its previous "+
+		"revision...", release.name, installableObj.FullyQualifiedId())
+
+	// revision 0 is the previous revision
+	err := helm("rollback", append([]string{release.name, "0"}, release.flags...)...)
+	if err != nil {
+		log.Logger.Errorf("Error rolling back release '%s' of kapp '%s': %v", release.name,
+			installableObj.FullyQualifiedId(), err)
+		return errors.Wrapf(upgradeErr, "Upgrading release '%s' failed and it couldn't be rolled back",
+			release.name)
+	}
+
+	return errors.Wrapf(upgradeErr, "Upgrading release '%s' failed so it was rolled back to its "+
+		"previous revision", release.name)
+}
+
+// Runs a helm command. Args configured for the command under `args.helm.<command>` are appended
+// as `--<name>=<value>` options.
+func (i HelmInstaller) helm(ctx context.Context, installableObj interfaces.IInstallable,
+	target string, envVars map[string]string, dryRun bool, command string, args ...string) error {
+
+	binary := installableObj.GetDescriptor().Helm.Binary
+	if binary == "" {
+		binary = defaultHelmBinary
+	}
+
+	args = append(args, configuredArgs(installableObj, i.Name(), command, "--")...)
+
+	log.Logger.Infof("Running 'helm %s' for kapp '%s'...", command, installableObj.FullyQualifiedId())
+
+	var stdoutBuf, stderrBuf bytes.Buffer
+	err := utils.ExecCommandContext(ctx, binary, append([]string{command}, args...), envVars,
+		&stdoutBuf, &stderrBuf, kappDir(installableObj, ""), timeoutSeconds(installableObj, target),
+		dryRun)
+
+	logCommandOutput(ctx, stdoutBuf.String(), stderrBuf.String())
+
+	if err != nil {
+		return errors.Wrapf(err, "Error running 'helm %s' for kapp '%s'", command,
+			installableObj.FullyQualifiedId())
+	}
+
+	return nil
+}
+
+// Returns the release to install a kapp as. Its name, namespace and kube context are taken from
+// the kapp's vars. The release is named after the kapp unless the `release` var is set.
+func newHelmRelease(installableObj interfaces.IInstallable, stack interfaces.IStack) (helmRelease, error) {
+	kappVars, err := kappVars(installableObj, stack)
+	if err != nil {
+		return helmRelease{}, errors.WithStack(err)
+	}
+
+	stringVar := func(name string) string {
+		value, ok := kappVars[name]
+		if !ok || value == nil {
+			return ""
+		}
+		return fmt.Sprintf("%v", value)
+	}
+
+	release := helmRelease{
+		name:  stringVar(helmVarRelease),
+		flags: make([]string, 0),
+	}
+
+	if release.name == "" {
+		release.name = installableObj.Id()
+	}
+
+	for _, flag := range []struct{ name, value string }{
+		{"namespace", stringVar(helmVarNamespace)},
+		{"kube-context", stringVar(helmVarKubeContext)},
+		{"kubeconfig", stringVar(helmVarKubeconfig)},
+	} {
+		if flag.value != "" {
+			release.flags = append(release.flags, fmt.Sprintf("--%s=%s", flag.name, flag.value))
+		}
+	}
+
+	// values files are searched for in the chart's directory if it's local, otherwise in the
+	// directory containing the kapp's sugarkube.yaml file
+	release.chart = kappDir(installableObj, installableObj.GetDescriptor().Helm.Chart)
+	valuesDir := release.chart
+
+	if info, err := os.Stat(release.chart); err != nil || !info.IsDir() {
+		if installableObj.GetDescriptor().Helm.Chart == "" {
+			return helmRelease{}, errors.New(fmt.Sprintf("Chart directory '%s' for kapp '%s' "+
+				"doesn't exist", release.chart, installableObj.FullyQualifiedId()))
+		}
+
+		release.chart = installableObj.GetDescriptor().Helm.Chart
+		valuesDir = kappDir(installableObj, "")
+	}
+
+	release.valuesFiles, err = findValuesFiles(valuesDir, stack.GetConfig())
+	if err != nil {
+		return helmRelease{}, errors.WithStack(err)
+	}
+
+	// the kapp's own values file is always passed first so kapps using chart references still get
+	// their base values
+	baseValuesPath := filepath.Join(kappDir(installableObj, ""), helmBaseValuesFile)
+	if info, err := os.Stat(baseValuesPath); err == nil && !info.IsDir() {
+		release.valuesFiles = append([]string{baseValuesPath}, release.valuesFiles...)
+	}
+
+	return release, nil
+}
+
+// Returns values files in a directory in increasing order of precedence. These are files named
+// `values-<name>.yaml` after the provider, provisioner, account, profile, cluster and region, then
+// any generated files (`_generated_*.yaml`).
+func findValuesFiles(dir string, stackConfig interfaces.IStackConfig) ([]string, error) {
+	fileNames := make([]string, 0)
+	for _, name := range stackFileNames(stackConfig) {
+		fileNames = append(fileNames, fmt.Sprintf("values-%s%s", name, helmValuesExtension))
+	}
+
+	paths, err := findStackFiles(dir, fileNames, helmValuesExtension)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return paths, nil
+}
+
+// Install a kapp
+func (i HelmInstaller) Install(ctx context.Context, installableObj interfaces.IInstallable, stack interfaces.IStack,
+	approved bool, dryRun bool) error {
+	log.Logger.Infof("Installing kapp '%s' (approved=%v, dry run=%v)...",
+		installableObj.FullyQualifiedId(), approved, dryRun)
+	return i.run(ctx, TargetInstall, installableObj, stack, approved, dryRun)
+}
+
+// Delete a kapp
+func (i HelmInstaller) Delete(ctx context.Context, installableObj interfaces.IInstallable, stack interfaces.IStack,
+	approved bool, dryRun bool) error {
+	log.Logger.Infof("Deleting kapp '%s' (approved=%v, dry run=%v)...",
+		installableObj.FullyQualifiedId(), approved, dryRun)
+	return i.run(ctx, TargetDelete, installableObj, stack, approved, dryRun)
+}
+
+// Get a kapp's outputs
+func (i HelmInstaller) Output(ctx context.Context, installableObj interfaces.IInstallable, stack interfaces.IStack,
+	dryRun bool) error {
+	log.Logger.Infof("Getting output for kapp '%s'...", installableObj.FullyQualifiedId())
+	return i.run(ctx, TargetOutput, installableObj, stack, true, dryRun)
+}
+
+// Clean a kapp
+func (i HelmInstaller) Clean(ctx context.Context, installableObj interfaces.IInstallable, stack interfaces.IStack,
+	dryRun bool) error {
+	log.Logger.Infof("Cleaning kapp '%s'...", installableObj.FullyQualifiedId())
+	return i.run(ctx, TargetClean, installableObj, stack, true, dryRun)
+}
+
+func (i HelmInstaller) GetVars(action string, approved bool) map[string]interface{} {
+	return map[string]interface{}{
+		"action":   action,
+		"approved": fmt.Sprintf("%v", approved)}
+}
@@ -0,0 +1,139 @@
+/*
+ * Copyright 2019 The Sugarkube Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package installer
+
+import (
+	"context"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/sugarkube/sugarkube/internal/pkg/interfaces"
+	"github.com/sugarkube/sugarkube/internal/pkg/provider"
+	"github.com/sugarkube/sugarkube/internal/pkg/structs"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// A fake helm binary that records its args. Upgrades fail if the kapp's `fail_upgrade` var is true.
+const fakeHelm = `#!/bin/sh
+echo "$@" >> "%[1]s/invocations.log"
+if [ "$1" = upgrade ] && [ "$FAIL_UPGRADE" = true ]; then
+  exit 1
+fi
+`
+
+// Returns a kapp that uses a fake helm binary, and the directory the binary records its invocations in
+func helmKapp(t *testing.T, helmConfig structs.Helm, vars map[string]interface{}) (interfaces.IInstallable, string) {
+	binDir := testDir(t)
+	helmConfig.Binary = writeFakeExecutable(t, binDir, "helm", fakeHelm)
+
+	installableObj := newTestKapp(t, structs.KappDescriptorWithMaps{
+		KappConfig: structs.KappConfig{
+			Installer: HELM,
+			Helm:      helmConfig,
+			Vars:      vars,
+		},
+	}, "")
+
+	for _, name := range []string{"values.yaml", "values-dev.yaml", "values-local.yaml",
+		"values-prod.yaml", "_generated_secrets.yaml"} {
+		assert.Nil(t, ioutil.WriteFile(filepath.Join(installableObj.GetCacheDir(), name), []byte{}, 0644))
+	}
+
+	return installableObj, binDir
+}
+
+func TestHelmInstaller(t *testing.T) {
+	installableObj, binDir := helmKapp(t, structs.Helm{}, map[string]interface{}{
+		"release":      "web",
+		"namespace":    "apps",
+		"kube_context": "minikube",
+	})
+
+	installerImpl, err := New(installableObj, &provider.LocalProvider{})
+	assert.Nil(t, err)
+	assert.Equal(t, HELM, installerImpl.Name())
+
+	ctx := context.Background()
+	kappDir := installableObj.GetCacheDir()
+	upgradeArgs := fmt.Sprintf("upgrade web %[1]s --install --namespace=apps --kube-context=minikube "+
+		"--values=%[1]s/values.yaml --values=%[1]s/values-local.yaml --values=%[1]s/values-dev.yaml "+
+		"--values=%[1]s/_generated_secrets.yaml", kappDir)
+
+	assert.Nil(t, installerImpl.Install(ctx, installableObj, testStack(), false, false))
+	assert.Equal(t, []string{upgradeArgs + " --dry-run"}, readInvocations(t, binDir))
+
+	assert.Nil(t, installerImpl.Install(ctx, installableObj, testStack(), true, false))
+	assert.Equal(t, []string{upgradeArgs}, readInvocations(t, binDir))
+
+	assert.Nil(t, installerImpl.Delete(ctx, installableObj, testStack(), false, false))
+	assert.Equal(t, []string{"uninstall web --namespace=apps --kube-context=minikube --dry-run"},
+		readInvocations(t, binDir))
+
+	assert.Nil(t, installerImpl.Delete(ctx, installableObj, testStack(), true, false))
+	assert.Equal(t, []string{"uninstall web --namespace=apps --kube-context=minikube"},
+		readInvocations(t, binDir))
+}
+
+func TestHelmInstallerDiff(t *testing.T) {
+	installableObj, binDir := helmKapp(t, structs.Helm{Diff: true}, nil)
+
+	installerImpl := HelmInstaller{provider: &provider.LocalProvider{}}
+
+	assert.Nil(t, installerImpl.Install(context.Background(), installableObj, testStack(), false, false))
+
+	log := readInvocations(t, binDir)
+	assert.Equal(t, 1, len(log))
+	assert.True(t, strings.HasPrefix(log[0], fmt.Sprintf("diff upgrade kapp %s --allow-unreleased --values=",
+		installableObj.GetCacheDir())))
+}
+
+func TestHelmInstallerRollback(t *testing.T) {
+	for _, rollback := range []bool{true, false} {
+		installableObj, binDir := helmKapp(t, structs.Helm{Rollback: rollback},
+			map[string]interface{}{"fail_upgrade": true, "namespace": "apps"})
+
+		installerImpl := HelmInstaller{provider: &provider.LocalProvider{}}
+
+		err := installerImpl.Install(context.Background(), installableObj, testStack(), true, false)
+		assert.Error(t, err)
+
+		log := readInvocations(t, binDir)
+		if rollback {
+			assert.Equal(t, 2, len(log))
+			assert.Equal(t, "rollback kapp 0 --namespace=apps", log[1])
+			assert.Contains(t, err.Error(), "rolled back to its previous revision")
+		} else {
+			assert.Equal(t, 1, len(log))
+		}
+	}
+}
+
+func TestHelmInstallerChartReference(t *testing.T) {
+	installableObj, binDir := helmKapp(t, structs.Helm{Chart: "stable/nginx-ingress"}, nil)
+
+	installerImpl := HelmInstaller{provider: &provider.LocalProvider{}}
+
+	assert.Nil(t, installerImpl.Install(context.Background(), installableObj, testStack(), true, false))
+
+	// values files are found in the kapp's directory, including its base values file
+	kappDir := installableObj.GetCacheDir()
+	assert.Equal(t, []string{fmt.Sprintf("upgrade kapp stable/nginx-ingress --install "+
+		"--values=%[1]s/values.yaml --values=%[1]s/values-local.yaml --values=%[1]s/values-dev.yaml "+
+		"--values=%[1]s/_generated_secrets.yaml", kappDir)}, readInvocations(t, binDir))
+}
@@ -17,21 +17,249 @@
 package installer
 
 import (
+	"context"
 	"fmt"
 	"github.com/pkg/errors"
+	"github.com/sugarkube/sugarkube/internal/pkg/constants"
 	"github.com/sugarkube/sugarkube/internal/pkg/interfaces"
+	"github.com/sugarkube/sugarkube/internal/pkg/log"
+	"github.com/sugarkube/sugarkube/internal/pkg/utils"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
 )
 
 // implemented installers
-const MAKE = "make"
+const MAKE = constants.MakeInstaller
+const UNITS = constants.UnitsInstaller
+const TERRAFORM = constants.TerraformInstaller
+const HELM = constants.HelmInstaller
+const SCRIPT = constants.ScriptInstaller
 
-// Factory that creates installers
-func New(name string, providerImpl interfaces.IProvider) (interfaces.IInstaller, error) {
-	if name == MAKE {
+// Factory that creates the installer configured for an installable. If the installable doesn't
+// name an installer, the units installer is used if it declares any units, otherwise make is used.
+// Installers that aren't built in are implemented by plugins (see PluginInstaller).
+func New(installableObj interfaces.IInstallable, providerImpl interfaces.IProvider) (interfaces.IInstaller, error) {
+	descriptor := installableObj.GetDescriptor()
+
+	name := strings.ToLower(descriptor.Installer)
+	if name == "" {
+		name = MAKE
+		if len(descriptor.Units) > 0 {
+			name = UNITS
+		}
+	}
+
+	switch name {
+	case MAKE:
 		return MakeInstaller{
 			provider: providerImpl,
 		}, nil
+	case UNITS:
+		return UnitsInstaller{
+			provider: providerImpl,
+		}, nil
+	case TERRAFORM:
+		return TerraformInstaller{
+			provider:   providerImpl,
+			savedPlans: map[string]bool{},
+		}, nil
+	case HELM:
+		return HelmInstaller{
+			provider: providerImpl,
+		}, nil
+	case SCRIPT:
+		return ScriptInstaller{}, nil
+	}
+
+	// any other installer is implemented by a plugin
+	path, err := findPlugin(name)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return PluginInstaller{
+		name: name,
+		path: path,
+	}, nil
+}
+
+// Returns the number of seconds a kapp's target may run for, or 0 if it shouldn't time out. Timeouts
+// only apply to the install, delete and output targets.
+func timeoutSeconds(installableObj interfaces.IInstallable, target string) int {
+	switch target {
+	case TargetInstall, TargetDelete, TargetOutput:
+		return installableObj.GetDescriptor().Timeout
+	default:
+		return 0
+	}
+}
+
+// Returns env vars that are passed to every command run by an installer. These are the stack's
+// details, provider-specific vars, the kapp's vars and its explicitly declared env vars.
+func commonEnvVars(providerImpl interfaces.IProvider, installable interfaces.IInstallable,
+	stack interfaces.IStack, approved bool) (map[string]string, error) {
+	envVars := baseEnvVars(installable, stack, approved)
+
+	// Provider-specific env vars, e.g. the AwsProvider adds REGION
+	for k, v := range providerImpl.GetInstallerVars() {
+		upperKey := strings.ToUpper(k)
+		envVars[upperKey] = fmt.Sprintf("%#v", v)
+	}
+
+	// add all kapp vars as env vars
+	kappVars, err := kappVars(installable, stack)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	for k, v := range kappVars {
+		upperKey := strings.ToUpper(k)
+		envVars[upperKey] = strings.Trim(fmt.Sprintf("%#v", v), "\"")
+	}
+
+	// now add explicitly defined env vars
+	addDeclaredEnvVars(envVars, installable)
+
+	return envVars, nil
+}
+
+// Returns env vars that are always supplied, i.e. the stack's details and whether the kapp's approved
+func baseEnvVars(installable interfaces.IInstallable, stack interfaces.IStack,
+	approved bool) map[string]string {
+	stackConfig := stack.GetConfig()
+
+	return map[string]string{
+		"KAPP_ROOT": installable.GetCacheDir(),
+		"APPROVED":  fmt.Sprintf("%v", approved),
+		"CLUSTER":   stackConfig.GetCluster(),
+		"PROFILE":   stackConfig.GetProfile(),
+		"PROVIDER":  stackConfig.GetProvider(),
+	}
+}
+
+// Adds the env vars a kapp explicitly declares to the map
+func addDeclaredEnvVars(envVars map[string]string, installable interfaces.IInstallable) {
+	for k, v := range installable.GetEnvVars() {
+		upperKey := strings.ToUpper(k)
+		envVars[upperKey] = strings.Trim(fmt.Sprintf("%#v", v), "\"")
+	}
+}
+
+// Returns the kapp's vars, i.e. those available to templates under `.kapp.vars`
+func kappVars(installableObj interfaces.IInstallable, stack interfaces.IStack) (map[string]interface{}, error) {
+	installableVars, err := installableObj.Vars(stack)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	kappAllVars, ok := installableVars[constants.KappVarsKappKey].(map[string]interface{})
+	if !ok {
+		return map[string]interface{}{}, nil
+	}
+
+	kappVars, ok := kappAllVars[constants.KappVarsVarsKey].(map[string]interface{})
+	if !ok {
+		return map[string]interface{}{}, nil
+	}
+
+	return kappVars, nil
+}
+
+// Logs the output of a command once it's exited. Output that's already been streamed while the
+// command ran is only logged at debug level so it isn't displayed twice.
+func logCommandOutput(ctx context.Context, stdout string, stderr string) {
+	if utils.IsStreamingOutput(ctx) {
+		log.Logger.Debugf("Stdout: %s", stdout)
+		log.Logger.Debugf("Stderr: %s", stderr)
+		return
+	}
+
+	log.Logger.Infof("Stdout: %s", stdout)
+	log.Logger.Infof("Stderr: %s", stderr)
+}
+
+// Returns a directory relative to the directory containing the kapp's sugarkube.yaml file (or its
+// cache directory if it doesn't have one) unless it's absolute
+func kappDir(installableObj interfaces.IInstallable, dir string) string {
+	if filepath.IsAbs(dir) {
+		return dir
+	}
+
+	baseDir := installableObj.GetConfigFileDir()
+	if baseDir == "" {
+		baseDir = installableObj.GetCacheDir()
+	}
+
+	return filepath.Join(baseDir, dir)
+}
+
+// Returns args configured for a command under `args.<installer>.<command>` as `<prefix><name>=<value>`
+// options, sorted so commands are run the same way each time
+func configuredArgs(installableObj interfaces.IInstallable, installerName string, command string,
+	prefix string) []string {
+	args := make([]string, 0)
+
+	for _, arg := range installableObj.GetCliArgs(installerName, command) {
+		args = append(args, prefix+arg)
+	}
+	sort.Strings(args)
+
+	return args
+}
+
+// Returns the parts of the stack's config that files specific to a stack are named after, in
+// increasing order of precedence. This is the same order as `.sugarkube.defaultVars`.
+func stackFileNames(stackConfig interfaces.IStackConfig) []string {
+	names := make([]string, 0)
+
+	for _, name := range []string{
+		stackConfig.GetProvider(),
+		stackConfig.GetProvisioner(),
+		stackConfig.GetAccount(),
+		stackConfig.GetProfile(),
+		stackConfig.GetCluster(),
+		stackConfig.GetRegion(),
+	} {
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+
+	return names
+}
+
+// Returns the paths of the named files in a directory that exist, followed by any generated files
+// (`_generated_*<extension>`) sorted by name. Each path is only returned once.
+func findStackFiles(dir string, fileNames []string, extension string) ([]string, error) {
+	paths := make([]string, 0)
+	for _, fileName := range fileNames {
+		paths = append(paths, filepath.Join(dir, fileName))
+	}
+
+	generatedPaths, err := filepath.Glob(filepath.Join(dir, "_generated_*"+extension))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	sort.Strings(generatedPaths)
+	paths = append(paths, generatedPaths...)
+
+	found := make([]string, 0)
+	seen := make(map[string]bool, 0)
+
+	for _, path := range paths {
+		if seen[path] {
+			continue
+		}
+		seen[path] = true
+
+		if _, err := os.Stat(path); err != nil {
+			continue
+		}
+
+		found = append(found, path)
 	}
 
-	return nil, errors.New(fmt.Sprintf("Installer '%s' doesn't exist", name))
+	return found, nil
 }
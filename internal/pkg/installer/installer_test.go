@@ -0,0 +1,143 @@
+/*
+ * Copyright 2019 The Sugarkube Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package installer
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/sugarkube/sugarkube/internal/pkg/config"
+	"github.com/sugarkube/sugarkube/internal/pkg/constants"
+	"github.com/sugarkube/sugarkube/internal/pkg/installable"
+	"github.com/sugarkube/sugarkube/internal/pkg/interfaces"
+	"github.com/sugarkube/sugarkube/internal/pkg/log"
+	"github.com/sugarkube/sugarkube/internal/pkg/mock"
+	"github.com/sugarkube/sugarkube/internal/pkg/provider"
+	"github.com/sugarkube/sugarkube/internal/pkg/structs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Name of the file fake executables record their invocations in
+const invocationsFile = "invocations.log"
+
+func init() {
+	log.ConfigureLogger("debug", false)
+}
+
+// Returns the stack kapps are installed into in tests
+func testStack() interfaces.IStack {
+	return &mock.MockStack{
+		Config:   mock.Config{Name: "stack", Provider: "local", Profile: "dev", Cluster: "cluster1"},
+		Provider: &provider.LocalProvider{},
+		TemplatedVars: map[string]interface{}{
+			"stack": map[string]interface{}{"provider": "local"},
+			"kapp": map[string]interface{}{
+				"vars": map[string]interface{}{
+					"replicas": 3,
+					"tags":     map[interface{}]interface{}{"team": "ops"},
+					"zones":    []interface{}{"a", "b"},
+				},
+			},
+		},
+	}
+}
+
+// Sets the current config until the test finishes
+func setTestConfig(t *testing.T, configObj *config.Config) {
+	previousConfig := config.CurrentConfig
+	config.CurrentConfig = configObj
+	t.Cleanup(func() { config.CurrentConfig = previousConfig })
+}
+
+// Returns a temporary directory that's deleted when the test finishes
+func testDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "installer-")
+	assert.Nil(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
+
+// Writes a fake executable to a directory and returns its path. `%[1]s` in the script is replaced
+// by the directory so fakes can record their invocations in it for readInvocations.
+func writeFakeExecutable(t *testing.T, dir string, name string, script string) string {
+	assert.Nil(t, os.MkdirAll(dir, 0755))
+	path := filepath.Join(dir, name)
+	assert.Nil(t, ioutil.WriteFile(path, []byte(fmt.Sprintf(script, dir)), 0700))
+	return path
+}
+
+// Returns the invocations recorded in a directory since they were last read
+func readInvocations(t *testing.T, dir string) []string {
+	path := filepath.Join(dir, invocationsFile)
+	data, err := ioutil.ReadFile(path)
+	assert.Nil(t, err)
+	assert.Nil(t, os.Remove(path))
+	return strings.Split(strings.TrimSpace(string(data)), "\n")
+}
+
+// Returns a kapp built from a descriptor in a temporary cache directory. If a sugarkube.yaml file
+// is given it's written to the kapp's directory and loaded.
+func newTestKapp(t *testing.T, descriptor structs.KappDescriptorWithMaps, sugarkubeYaml string) interfaces.IInstallable {
+	cacheDir := testDir(t)
+	kappDir := filepath.Join(cacheDir, "manifest", "kapp")
+	assert.Nil(t, os.MkdirAll(kappDir, 0755))
+
+	descriptor.Id = "kapp"
+	installableObj, err := installable.New("manifest", []structs.KappDescriptorWithMaps{descriptor})
+	assert.Nil(t, err)
+
+	if sugarkubeYaml == "" {
+		assert.Nil(t, installableObj.SetTopLevelCacheDir(cacheDir))
+		return installableObj
+	}
+
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(kappDir, constants.KappConfigFileName),
+		[]byte(sugarkubeYaml), 0644))
+
+	if config.CurrentConfig == nil {
+		setTestConfig(t, &config.Config{})
+	}
+	assert.Nil(t, installableObj.LoadConfigFile(cacheDir))
+
+	return installableObj
+}
+
+func TestNew(t *testing.T) {
+	tests := map[string]structs.KappConfig{
+		MAKE:  {},
+		UNITS: {Units: []structs.Unit{{Id: "unit"}}},
+		"":    {Installer: "helicopter"},
+	}
+
+	for expected, kappConfig := range tests {
+		installableObj, err := installable.New("manifest", []structs.KappDescriptorWithMaps{
+			{Id: "kapp", KappConfig: kappConfig}})
+		assert.Nil(t, err)
+
+		installerImpl, err := New(installableObj, &provider.LocalProvider{})
+		if expected == "" {
+			assert.Error(t, err)
+			continue
+		}
+
+		assert.Nil(t, err)
+		assert.Equal(t, expected, installerImpl.Name())
+	}
+}
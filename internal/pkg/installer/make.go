@@ -18,12 +18,13 @@ package installer
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"github.com/pkg/errors"
-	"github.com/sugarkube/sugarkube/internal/pkg/constants"
 	"github.com/sugarkube/sugarkube/internal/pkg/interfaces"
 	"github.com/sugarkube/sugarkube/internal/pkg/log"
 	"github.com/sugarkube/sugarkube/internal/pkg/utils"
+	"os"
 	"path/filepath"
 	"strings"
 )
@@ -38,100 +39,45 @@ const TargetDelete = "delete"
 const TargetOutput = "output"
 const TargetClean = "clean"
 
+const makefileName = "Makefile"
+
 // Return the name of this installer
 func (i MakeInstaller) Name() string {
-	return "make"
+	return MAKE
 }
 
-// Run the given make target
-func (i MakeInstaller) run(makeTarget string, installable interfaces.IInstallable, stack interfaces.IStack,
+// Run the make target for the given target
+func (i MakeInstaller) run(ctx context.Context, target string, installable interfaces.IInstallable, stack interfaces.IStack,
 	approved bool, dryRun bool) error {
 
-	// search for the Makefile
-	makefilePaths, err := utils.FindFilesByPattern(installable.GetCacheDir(), "Makefile",
-		true, false)
+	makefilePath, err := findMakefile(installable)
 	if err != nil {
-		return errors.Wrapf(err, "Error finding Makefile in '%s'",
-			installable.GetCacheDir())
-	}
-
-	if len(makefilePaths) == 0 {
-		return errors.New(fmt.Sprintf("No makefile found for kapp '%s' "+
-			"in '%s'", installable.Id(), installable.GetCacheDir()))
-	}
-	if len(makefilePaths) > 1 {
-		// todo - select the right makefile from the installerConfig if it exists,
-		// then remove this panic
-		panic(fmt.Sprintf("Multiple Makefiles found. Disambiguation "+
-			"not implemented yet: %s", strings.Join(makefilePaths, ", ")))
-	}
-
-	stackConfig := stack.GetConfig()
-
-	// populate env vars that are always supplied
-	envVars := map[string]string{
-		"KAPP_ROOT": installable.GetCacheDir(),
-		"APPROVED":  fmt.Sprintf("%v", approved),
-		"CLUSTER":   stackConfig.GetCluster(),
-		"PROFILE":   stackConfig.GetProfile(),
-		"PROVIDER":  stackConfig.GetProvider(),
-	}
-
-	// Provider-specific env vars, e.g. the AwsProvider adds REGION
-	for k, v := range i.provider.GetInstallerVars() {
-		upperKey := strings.ToUpper(k)
-		envVars[upperKey] = fmt.Sprintf("%#v", v)
+		return errors.WithStack(err)
 	}
 
-	// add all kapp vars as env vars
-	installableVars, err := installable.Vars(stack)
+	envVars, err := commonEnvVars(i.provider, installable, stack, approved)
 	if err != nil {
 		return errors.WithStack(err)
 	}
 
-	kappAllVars, ok := installableVars[constants.KappVarsKappKey]
-	if ok {
-		kappAllVarsMap := kappAllVars.(map[string]interface{})
-		kappVars, stillOk := kappAllVarsMap[constants.KappVarsVarsKey]
-		if stillOk {
-			kappVarsMap := kappVars.(map[string]interface{})
-			for k, v := range kappVarsMap {
-				upperKey := strings.ToUpper(k)
-				envVars[upperKey] = strings.Trim(fmt.Sprintf("%#v", v), "\"")
-			}
-		}
-	}
-
-	// now add explicitly defined env vars
-	for k, v := range installable.GetEnvVars() {
-		upperKey := strings.ToUpper(k)
-		envVars[upperKey] = strings.Trim(fmt.Sprintf("%#v", v), "\"")
-	}
-
-	cliArgs := []string{makeTarget}
+	cliArgs := []string{"-f", makefilePath, makeTargetName(installable, target)}
 
-	targetArgs := installable.GetCliArgs(i.Name(), makeTarget)
+	targetArgs := installable.GetCliArgs(i.Name(), target)
 	log.Logger.Debugf("Kapp '%s' has args for %s %s (approved=%v): %#v",
-		installable.FullyQualifiedId(), i.Name(), makeTarget, approved, targetArgs)
+		installable.FullyQualifiedId(), i.Name(), target, approved, targetArgs)
 
 	for _, targetArg := range targetArgs {
 		cliArgs = append(cliArgs, targetArg)
 	}
 
-	makefilePath, err := filepath.Abs(makefilePaths[0])
-	if err != nil {
-		return errors.WithStack(err)
-	}
-
-	log.Logger.Infof("Running 'make %s' on kapp '%s' with APPROVED=%v...", makeTarget,
+	log.Logger.Infof("Running 'make %s' on kapp '%s' with APPROVED=%v...", cliArgs[2],
 		installable.FullyQualifiedId(), approved)
 
 	var stdoutBuf, stderrBuf bytes.Buffer
-	err = utils.ExecCommand("make", cliArgs, envVars, &stdoutBuf,
-		&stderrBuf, filepath.Dir(makefilePath), 0, dryRun)
+	err = utils.ExecCommandContext(ctx, "make", cliArgs, envVars, &stdoutBuf,
+		&stderrBuf, filepath.Dir(makefilePath), timeoutSeconds(installable, target), dryRun)
 
-	log.Logger.Infof("Stdout: %s", stdoutBuf.String())
-	log.Logger.Infof("Stderr: %s", stderrBuf.String())
+	logCommandOutput(ctx, stdoutBuf.String(), stderrBuf.String())
 
 	// some commands write to stderr, so we can't just fail if that buffer is non-zero
 	if err != nil {
@@ -144,34 +90,99 @@ func (i MakeInstaller) run(makeTarget string, installable interfaces.IInstallabl
 	return nil
 }
 
+// Returns the path to the kapp's Makefile. In order of precedence this is the Makefile configured
+// in the kapp's descriptor, a Makefile in the directory containing the kapp's sugarkube.yaml file,
+// a Makefile in the root of the kapp or the only Makefile anywhere in the kapp. An error is
+// returned if none of these exist or if there are several Makefiles in subdirectories of the kapp.
+func findMakefile(installable interfaces.IInstallable) (string, error) {
+	configuredPath := installable.GetDescriptor().Make.Makefile
+	if configuredPath != "" {
+		path := kappDir(installable, configuredPath)
+		if info, err := os.Stat(path); err != nil || info.IsDir() {
+			return "", errors.New(fmt.Sprintf("Makefile '%s' configured for kapp '%s' doesn't exist",
+				path, installable.FullyQualifiedId()))
+		}
+
+		return path, nil
+	}
+
+	candidateDirs := []string{installable.GetConfigFileDir(), installable.GetCacheDir()}
+	for _, dir := range candidateDirs {
+		if dir == "" {
+			continue
+		}
+
+		path := filepath.Join(dir, makefileName)
+		if info, err := os.Stat(path); err == nil && !info.IsDir() {
+			return path, nil
+		}
+	}
+
+	makefilePaths, err := utils.FindFilesByPattern(installable.GetCacheDir(),
+		fmt.Sprintf("(^|/)%s$", makefileName), true, false)
+	if err != nil {
+		return "", errors.Wrapf(err, "Error finding Makefile in '%s'",
+			installable.GetCacheDir())
+	}
+
+	if len(makefilePaths) == 0 {
+		return "", errors.New(fmt.Sprintf("No makefile found for kapp '%s' "+
+			"in '%s'", installable.FullyQualifiedId(), installable.GetCacheDir()))
+	}
+	if len(makefilePaths) > 1 {
+		return "", errors.New(fmt.Sprintf("Multiple Makefiles found for kapp '%s'. Set "+
+			"`make.makefile` in its descriptor to choose one: %s", installable.FullyQualifiedId(),
+			strings.Join(makefilePaths, ", ")))
+	}
+
+	path, err := filepath.Abs(makefilePaths[0])
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	return path, nil
+}
+
+// Returns the name of the make target to run for a target. Kapps can rename targets, e.g. if they
+// wrap an upstream project whose Makefile already has an 'install' target.
+func makeTargetName(installable interfaces.IInstallable, target string) string {
+	// viper lowercases keys
+	name, ok := installable.GetDescriptor().Make.Targets[strings.ToLower(target)]
+	if ok && name != "" {
+		return name
+	}
+
+	return target
+}
+
 // Install a kapp
-func (i MakeInstaller) Install(installableObj interfaces.IInstallable, stack interfaces.IStack,
+func (i MakeInstaller) Install(ctx context.Context, installableObj interfaces.IInstallable, stack interfaces.IStack,
 	approved bool, dryRun bool) error {
 	log.Logger.Infof("Installing kapp '%s' (approved=%v, dry run=%v)...",
 		installableObj.FullyQualifiedId(), approved, dryRun)
-	return i.run(TargetInstall, installableObj, stack, approved, dryRun)
+	return i.run(ctx, TargetInstall, installableObj, stack, approved, dryRun)
 }
 
 // Delete a kapp
-func (i MakeInstaller) Delete(installableObj interfaces.IInstallable, stack interfaces.IStack,
+func (i MakeInstaller) Delete(ctx context.Context, installableObj interfaces.IInstallable, stack interfaces.IStack,
 	approved bool, dryRun bool) error {
 	log.Logger.Infof("Deleting kapp '%s' (approved=%v, dry run=%v)...",
 		installableObj.FullyQualifiedId(), approved, dryRun)
-	return i.run(TargetDelete, installableObj, stack, approved, dryRun)
+	return i.run(ctx, TargetDelete, installableObj, stack, approved, dryRun)
 }
 
 // Get a kapp's outputs
-func (i MakeInstaller) Output(installableObj interfaces.IInstallable, stack interfaces.IStack,
+func (i MakeInstaller) Output(ctx context.Context, installableObj interfaces.IInstallable, stack interfaces.IStack,
 	dryRun bool) error {
 	log.Logger.Infof("Getting output for kapp '%s'...", installableObj.FullyQualifiedId())
-	return i.run(TargetOutput, installableObj, stack, true, dryRun)
+	return i.run(ctx, TargetOutput, installableObj, stack, true, dryRun)
 }
 
 // Clean a kapp
-func (i MakeInstaller) Clean(installableObj interfaces.IInstallable, stack interfaces.IStack,
+func (i MakeInstaller) Clean(ctx context.Context, installableObj interfaces.IInstallable, stack interfaces.IStack,
 	dryRun bool) error {
 	log.Logger.Infof("Cleaning kapp '%s'...", installableObj.FullyQualifiedId())
-	return i.run(TargetClean, installableObj, stack, true, dryRun)
+	return i.run(ctx, TargetClean, installableObj, stack, true, dryRun)
 }
 
 func (i MakeInstaller) GetVars(action string, approved bool) map[string]interface{} {
@@ -0,0 +1,120 @@
+/*
+ * Copyright 2019 The Sugarkube Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package installer
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/sugarkube/sugarkube/internal/pkg/interfaces"
+	"github.com/sugarkube/sugarkube/internal/pkg/provider"
+	"github.com/sugarkube/sugarkube/internal/pkg/structs"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// Returns a kapp containing Makefiles at the given paths
+func makeKapp(t *testing.T, makeConfig structs.Make, makefiles ...string) interfaces.IInstallable {
+	installableObj := newTestKapp(t, structs.KappDescriptorWithMaps{
+		KappConfig: structs.KappConfig{Make: makeConfig},
+	}, "")
+
+	for _, makefile := range makefiles {
+		path := filepath.Join(installableObj.GetCacheDir(), makefile)
+		assert.Nil(t, os.MkdirAll(filepath.Dir(path), 0755))
+		assert.Nil(t, ioutil.WriteFile(path, []byte("install:\n\techo installed > installed.txt\n"+
+			"deploy:\n\techo deployed > deployed.txt\n"), 0644))
+	}
+
+	return installableObj
+}
+
+func TestFindMakefile(t *testing.T) {
+	tests := []struct {
+		name       string
+		makeConfig structs.Make
+		makefiles  []string
+		expected   string
+		errors     bool
+	}{
+		{
+			name:      "root",
+			makefiles: []string{"Makefile", "upstream/Makefile", "upstream/docs/Makefile"},
+			expected:  "Makefile",
+		},
+		{
+			name:      "only_one",
+			makefiles: []string{"upstream/Makefile", "upstream/Makefile.in"},
+			expected:  "upstream/Makefile",
+		},
+		{
+			name:      "ambiguous",
+			makefiles: []string{"upstream/Makefile", "upstream/docs/Makefile"},
+			errors:    true,
+		},
+		{
+			name:       "configured",
+			makeConfig: structs.Make{Makefile: "Makefile.sugarkube"},
+			makefiles:  []string{"Makefile", "Makefile.sugarkube"},
+			expected:   "Makefile.sugarkube",
+		},
+		{
+			name:       "configured_missing",
+			makeConfig: structs.Make{Makefile: "Makefile.sugarkube"},
+			makefiles:  []string{"Makefile"},
+			errors:     true,
+		},
+		{
+			name:   "missing",
+			errors: true,
+		},
+	}
+
+	for _, test := range tests {
+		installableObj := makeKapp(t, test.makeConfig, test.makefiles...)
+
+		path, err := findMakefile(installableObj)
+		if test.errors {
+			assert.Error(t, err, test.name)
+		} else {
+			assert.Nil(t, err, test.name)
+			assert.Equal(t, filepath.Join(installableObj.GetCacheDir(), test.expected), path, test.name)
+		}
+	}
+}
+
+func TestMakeInstallerTargets(t *testing.T) {
+	if _, err := exec.LookPath("make"); err != nil {
+		t.Skip("make isn't installed")
+	}
+
+	installableObj := makeKapp(t, structs.Make{
+		Makefile: "upstream/Makefile",
+		Targets:  map[string]string{"install": "deploy"},
+	}, "upstream/Makefile", "upstream/docs/Makefile")
+
+	installerImpl := MakeInstaller{provider: &provider.LocalProvider{}}
+
+	err := installerImpl.Install(context.Background(), installableObj, testStack(), true, false)
+	assert.Nil(t, err)
+
+	assert.FileExists(t, filepath.Join(installableObj.GetCacheDir(), "upstream", "deployed.txt"))
+	_, err = os.Stat(filepath.Join(installableObj.GetCacheDir(), "upstream", "installed.txt"))
+	assert.True(t, os.IsNotExist(err))
+}
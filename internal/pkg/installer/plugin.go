@@ -0,0 +1,331 @@
+/*
+ * Copyright 2019 The Sugarkube Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package installer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/sugarkube/sugarkube/internal/pkg/config"
+	"github.com/sugarkube/sugarkube/internal/pkg/constants"
+	"github.com/sugarkube/sugarkube/internal/pkg/convert"
+	"github.com/sugarkube/sugarkube/internal/pkg/interfaces"
+	"github.com/sugarkube/sugarkube/internal/pkg/log"
+	"github.com/sugarkube/sugarkube/internal/pkg/utils"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Installs kapps by running an external executable called `sugarkube-installer-<name>`. The plugin
+// is sent a JSON request on stdin describing the target to run and writes a JSON result to stdout.
+// Plugins are also run during dry runs and must check `dry_run` in the request.
+type PluginInstaller struct {
+	name string
+	path string // path to the plugin's executable
+}
+
+// prefix of the names of plugin executables
+const pluginPrefix = "sugarkube-installer-"
+
+// version of the request and result formats. Plugins should reject requests with versions they
+// don't understand
+const PluginProtocolVersion = 1
+
+const pluginStatusSuccess = "success"
+const pluginStatusFailure = "failure"
+
+// The request plugins are sent on stdin
+type pluginRequest struct {
+	Version    int         `json:"version"`
+	Action     string      `json:"action"` // the target to run, e.g. install, delete, etc.
+	Approved   bool        `json:"approved"`
+	DryRun     bool        `json:"dry_run"`
+	KappId     string      `json:"kapp_id"` // fully-qualified
+	Descriptor interface{} `json:"descriptor"`
+	Vars       interface{} `json:"vars"` // the kapp's merged and templated vars
+	CacheDir   string      `json:"cache_dir"`
+}
+
+// The result plugins write to stdout
+type pluginResult struct {
+	Version  int                    `json:"version"`
+	Status   string                 `json:"status"`
+	Outputs  map[string]interface{} `json:"outputs"`
+	Messages []pluginMessage        `json:"messages"`
+}
+
+type pluginMessage struct {
+	Level   string `json:"level"` // one of debug, info, warn or error. Defaults to info
+	Message string `json:"message"`
+}
+
+// Returns the path to the plugin for the named installer. The configured plugin directory is
+// searched before the PATH.
+func findPlugin(name string) (string, error) {
+	executable := pluginPrefix + name
+
+	if config.CurrentConfig != nil && config.CurrentConfig.PluginDir != "" {
+		path := filepath.Join(config.CurrentConfig.PluginDir, executable)
+		if info, err := os.Stat(path); err == nil && !info.IsDir() {
+			return path, nil
+		}
+	}
+
+	path, err := exec.LookPath(executable)
+	if err != nil {
+		return "", errors.Wrapf(err, "Installer '%s' doesn't exist and no plugin called '%s' was "+
+			"found in the plugin directory or on the PATH", name, executable)
+	}
+
+	return path, nil
+}
+
+// Return the name of this installer
+func (i PluginInstaller) Name() string {
+	return i.name
+}
+
+// Sends the plugin a request to run the target and handles its result
+func (i PluginInstaller) run(ctx context.Context, target string, installableObj interfaces.IInstallable,
+	stack interfaces.IStack, approved bool, dryRun bool) error {
+
+	request, err := i.request(target, installableObj, stack, approved, dryRun)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	envVars := baseEnvVars(installableObj, stack, approved)
+	addDeclaredEnvVars(envVars, installableObj)
+
+	log.Logger.Infof("Running installer plugin '%s' for kapp '%s'...", i.path,
+		installableObj.FullyQualifiedId())
+
+	// plugins are run even during dry runs so they can say what they would have done
+	var stdoutBuf, stderrBuf bytes.Buffer
+	err = utils.ExecCommandWithInput(ctx, i.path, []string{target}, envVars, bytes.NewReader(request),
+		&stdoutBuf, &stderrBuf, kappDir(installableObj, ""), timeoutSeconds(installableObj, target), false)
+
+	// stdout is the plugin's result so is only worth logging when debugging
+	log.Logger.Debugf("Stdout: %s", stdoutBuf.String())
+	if utils.IsStreamingOutput(ctx) {
+		log.Logger.Debugf("Stderr: %s", stderrBuf.String())
+	} else {
+		log.Logger.Infof("Stderr: %s", stderrBuf.String())
+	}
+
+	if err != nil {
+		return errors.Wrapf(err, "Error running installer plugin '%s' for kapp '%s'", i.name,
+			installableObj.FullyQualifiedId())
+	}
+
+	result, err := i.parseResult(installableObj, stdoutBuf.Bytes())
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if result.Status != pluginStatusSuccess {
+		errorMessages := make([]string, 0)
+		for _, message := range result.Messages {
+			if strings.ToLower(message.Level) == "error" {
+				errorMessages = append(errorMessages, message.Message)
+			}
+		}
+
+		return errors.New(fmt.Sprintf("Installer plugin '%s' failed to %s kapp '%s': %s", i.name,
+			target, installableObj.FullyQualifiedId(), strings.Join(errorMessages, "; ")))
+	}
+
+	// kapps always need an output file after running the output target
+	if result.Outputs == nil && target == TargetOutput {
+		result.Outputs = map[string]interface{}{}
+	}
+
+	if result.Outputs != nil {
+		err = writePluginOutputs(installableObj, result.Outputs, dryRun)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	}
+
+	log.Logger.Infof("Kapp '%s' successfully processed (approved=%v, dry run=%v)",
+		installableObj.FullyQualifiedId(), approved, dryRun)
+
+	return nil
+}
+
+// Returns the JSON request to send to the plugin
+func (i PluginInstaller) request(target string, installableObj interfaces.IInstallable,
+	stack interfaces.IStack, approved bool, dryRun bool) ([]byte, error) {
+
+	// round trip the descriptor through YAML so keys are the same as in sugarkube.yaml files
+	yamlDescriptor, err := yaml.Marshal(installableObj.GetDescriptor())
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	var descriptor interface{}
+	err = yaml.Unmarshal(yamlDescriptor, &descriptor)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	jsonDescriptor, err := convert.ToJsonCompatible(descriptor)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	templatedVars, err := stack.GetTemplatedVars(installableObj, i.GetVars(target, approved))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	jsonVars, err := convert.ToJsonCompatible(templatedVars)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	request, err := json.Marshal(pluginRequest{
+		Version:    PluginProtocolVersion,
+		Action:     target,
+		Approved:   approved,
+		DryRun:     dryRun,
+		KappId:     installableObj.FullyQualifiedId(),
+		Descriptor: jsonDescriptor,
+		Vars:       jsonVars,
+		CacheDir:   installableObj.GetCacheDir(),
+	})
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return request, nil
+}
+
+// Parses the result written by the plugin and logs any messages in it
+func (i PluginInstaller) parseResult(installableObj interfaces.IInstallable, stdout []byte) (*pluginResult, error) {
+	result := &pluginResult{}
+
+	err := json.Unmarshal(bytes.TrimSpace(stdout), result)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error parsing the result returned by installer plugin '%s' "+
+			"for kapp '%s'", i.name, installableObj.FullyQualifiedId())
+	}
+
+	if result.Version != PluginProtocolVersion {
+		return nil, errors.New(fmt.Sprintf("Installer plugin '%s' returned a result with "+
+			"unsupported version %d. Expected version %d", i.name, result.Version, PluginProtocolVersion))
+	}
+
+	if result.Status != pluginStatusSuccess && result.Status != pluginStatusFailure {
+		return nil, errors.New(fmt.Sprintf("Installer plugin '%s' returned invalid status '%s'. Valid "+
+			"statuses are: %s, %s", i.name, result.Status, pluginStatusSuccess, pluginStatusFailure))
+	}
+
+	for _, message := range result.Messages {
+		switch strings.ToLower(message.Level) {
+		case "debug":
+			log.Logger.Debugf("[%s] %s", installableObj.FullyQualifiedId(), message.Message)
+		case "warn", "warning":
+			log.Logger.Warnf("[%s] %s", installableObj.FullyQualifiedId(), message.Message)
+		case "error":
+			log.Logger.Errorf("[%s] %s", installableObj.FullyQualifiedId(), message.Message)
+		default:
+			log.Logger.Infof("[%s] %s", installableObj.FullyQualifiedId(), message.Message)
+		}
+	}
+
+	return result, nil
+}
+
+// Writes outputs returned by a plugin to the path of the kapp's plugin output
+func writePluginOutputs(installableObj interfaces.IInstallable, outputs map[string]interface{},
+	dryRun bool) error {
+	outputObj, ok := installableObj.GetDescriptor().Outputs[constants.PluginOutputId]
+	if !ok {
+		return errors.New(fmt.Sprintf("Kapp '%s' doesn't have an output called '%s'",
+			installableObj.FullyQualifiedId(), constants.PluginOutputId))
+	}
+
+	path, err := filepath.Abs(filepath.Join(installableObj.GetConfigFileDir(), outputObj.Path))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if dryRun {
+		log.Logger.Infof("[Dry run] Would write plugin outputs for kapp '%s' to '%s'",
+			installableObj.FullyQualifiedId(), path)
+		return nil
+	}
+
+	data, err := json.Marshal(outputs)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	log.Logger.Debugf("Writing plugin outputs for kapp '%s' to '%s'",
+		installableObj.FullyQualifiedId(), path)
+
+	// outputs may contain secrets
+	err = ioutil.WriteFile(path, data, 0600)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return nil
+}
+
+// Install a kapp
+func (i PluginInstaller) Install(ctx context.Context, installableObj interfaces.IInstallable, stack interfaces.IStack,
+	approved bool, dryRun bool) error {
+	log.Logger.Infof("Installing kapp '%s' (approved=%v, dry run=%v)...",
+		installableObj.FullyQualifiedId(), approved, dryRun)
+	return i.run(ctx, TargetInstall, installableObj, stack, approved, dryRun)
+}
+
+// Delete a kapp
+func (i PluginInstaller) Delete(ctx context.Context, installableObj interfaces.IInstallable, stack interfaces.IStack,
+	approved bool, dryRun bool) error {
+	log.Logger.Infof("Deleting kapp '%s' (approved=%v, dry run=%v)...",
+		installableObj.FullyQualifiedId(), approved, dryRun)
+	return i.run(ctx, TargetDelete, installableObj, stack, approved, dryRun)
+}
+
+// Get a kapp's outputs
+func (i PluginInstaller) Output(ctx context.Context, installableObj interfaces.IInstallable, stack interfaces.IStack,
+	dryRun bool) error {
+	log.Logger.Infof("Getting output for kapp '%s'...", installableObj.FullyQualifiedId())
+	return i.run(ctx, TargetOutput, installableObj, stack, true, dryRun)
+}
+
+// Clean a kapp
+func (i PluginInstaller) Clean(ctx context.Context, installableObj interfaces.IInstallable, stack interfaces.IStack,
+	dryRun bool) error {
+	log.Logger.Infof("Cleaning kapp '%s'...", installableObj.FullyQualifiedId())
+	return i.run(ctx, TargetClean, installableObj, stack, true, dryRun)
+}
+
+func (i PluginInstaller) GetVars(action string, approved bool) map[string]interface{} {
+	return map[string]interface{}{
+		"action":   action,
+		"approved": fmt.Sprintf("%v", approved)}
+}
@@ -0,0 +1,119 @@
+/*
+ * Copyright 2019 The Sugarkube Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package installer
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/sugarkube/sugarkube/internal/pkg/config"
+	"github.com/sugarkube/sugarkube/internal/pkg/constants"
+	"github.com/sugarkube/sugarkube/internal/pkg/installable"
+	"github.com/sugarkube/sugarkube/internal/pkg/interfaces"
+	"github.com/sugarkube/sugarkube/internal/pkg/provider"
+	"github.com/sugarkube/sugarkube/internal/pkg/structs"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+// Saves the request it's sent, records the action and fails to delete kapps
+const fakePlugin = `#!/bin/sh
+cat > "request-$1.json"
+echo "$1" >> "%[1]s/invocations.log"
+if [ "$1" = delete ]; then
+  echo '{"version": 1, "status": "failure", "messages": [{"level": "error", "message": "still in use"}]}'
+  exit 0
+fi
+echo '{"version": 1, "status": "success", "outputs": {"url": "https://example.com"},
+  "messages": [{"level": "warn", "message": "hello"}]}'
+`
+
+// Returns a kapp that uses a fake plugin, and the plugin directory it's configured to be in. The
+// config is restored when the test finishes.
+func pluginKapp(t *testing.T) (interfaces.IInstallable, string) {
+	pluginDir := testDir(t)
+	writeFakeExecutable(t, pluginDir, "sugarkube-installer-example", fakePlugin)
+	setTestConfig(t, &config.Config{PluginDir: pluginDir})
+
+	installableObj := newTestKapp(t, structs.KappDescriptorWithMaps{}, `
+installer: example
+vars:
+  region: eu-west-1
+`)
+
+	return installableObj, pluginDir
+}
+
+func TestPluginInstaller(t *testing.T) {
+	installableObj, pluginDir := pluginKapp(t)
+
+	installerImpl, err := New(installableObj, &provider.LocalProvider{})
+	assert.Nil(t, err)
+	assert.Equal(t, "example", installerImpl.Name())
+
+	ctx := context.Background()
+	stackObj := testStack()
+
+	assert.Nil(t, installerImpl.Install(ctx, installableObj, stackObj, true, false))
+
+	data, err := ioutil.ReadFile(filepath.Join(installableObj.GetCacheDir(), "request-install.json"))
+	assert.Nil(t, err)
+
+	var request map[string]interface{}
+	assert.Nil(t, json.Unmarshal(data, &request))
+	assert.Equal(t, float64(PluginProtocolVersion), request["version"])
+	assert.Equal(t, TargetInstall, request["action"])
+	assert.Equal(t, true, request["approved"])
+	assert.Equal(t, false, request["dry_run"])
+	assert.Equal(t, "manifest:kapp", request["kapp_id"])
+	assert.Equal(t, installableObj.GetCacheDir(), request["cache_dir"])
+	assert.Equal(t, "example", request["descriptor"].(map[string]interface{})["installer"])
+	assert.Equal(t, map[string]interface{}{"replicas": float64(3), "tags": map[string]interface{}{"team": "ops"},
+		"zones": []interface{}{"a", "b"}},
+		request["vars"].(map[string]interface{})["kapp"].(map[string]interface{})["vars"])
+
+	// outputs returned by plugins are loaded as the kapp's plugin output
+	assert.True(t, installableObj.HasOutputs())
+	assert.Nil(t, installerImpl.Output(ctx, installableObj, stackObj, false))
+
+	outputs, err := installableObj.GetOutputs(false, false)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{
+		constants.PluginOutputId: map[string]interface{}{"url": "https://example.com"},
+	}, outputs)
+
+	err = installerImpl.Delete(ctx, installableObj, stackObj, true, false)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "still in use")
+
+	assert.Equal(t, []string{TargetInstall, TargetOutput, TargetDelete}, readInvocations(t, pluginDir))
+}
+
+func TestPluginInstallerMissing(t *testing.T) {
+	setTestConfig(t, &config.Config{})
+
+	installableObj, err := installable.New("manifest", []structs.KappDescriptorWithMaps{{
+		Id:         "kapp",
+		KappConfig: structs.KappConfig{Installer: "missing"},
+	}})
+	assert.Nil(t, err)
+
+	_, err = New(installableObj, &provider.LocalProvider{})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "sugarkube-installer-missing")
+}
@@ -0,0 +1,243 @@
+/*
+ * Copyright 2019 The Sugarkube Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package installer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/sugarkube/sugarkube/internal/pkg/convert"
+	"github.com/sugarkube/sugarkube/internal/pkg/interfaces"
+	"github.com/sugarkube/sugarkube/internal/pkg/log"
+	"github.com/sugarkube/sugarkube/internal/pkg/utils"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Installs kapps by running a script or executable for each target. Instead of flattening vars
+// into env vars, the kapp's merged and templated vars are written to a file whose path is passed
+// to the script.
+type ScriptInstaller struct{}
+
+// env var containing the path to the vars file
+const scriptVarsFileEnvVar = "SUGARKUBE_VARS_FILE"
+
+const scriptVarsFormatJson = "json"
+const scriptVarsFormatYaml = "yaml"
+
+// Return the name of this installer
+func (i ScriptInstaller) Name() string {
+	return SCRIPT
+}
+
+// Runs the kapp's init command (if any) followed by its command for the target
+func (i ScriptInstaller) run(ctx context.Context, target string, installableObj interfaces.IInstallable,
+	stack interfaces.IStack, approved bool, dryRun bool) error {
+
+	scriptConfig := installableObj.GetDescriptor().Script
+
+	var command string
+	switch target {
+	case TargetInstall:
+		command = scriptConfig.Install
+	case TargetDelete:
+		command = scriptConfig.Delete
+	case TargetOutput:
+		command = scriptConfig.Output
+	case TargetClean:
+		command = scriptConfig.Clean
+	}
+
+	if strings.TrimSpace(command) == "" {
+		if target == TargetInstall || target == TargetDelete {
+			return errors.New(fmt.Sprintf("Kapp '%s' doesn't declare a script to %s it",
+				installableObj.FullyQualifiedId(), target))
+		}
+
+		log.Logger.Infof("Kapp '%s' doesn't declare a script for the %s target. Nothing to do",
+			installableObj.FullyQualifiedId(), target)
+		return nil
+	}
+
+	varsFile, err := writeVarsFile(installableObj, stack, i.GetVars(target, approved),
+		scriptConfig.VarsFormat, dryRun)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if !dryRun {
+		defer os.Remove(varsFile)
+	}
+
+	envVars := baseEnvVars(installableObj, stack, approved)
+	addDeclaredEnvVars(envVars, installableObj)
+	envVars[scriptVarsFileEnvVar] = varsFile
+
+	if target != TargetClean && strings.TrimSpace(scriptConfig.Init) != "" {
+		err = i.runCommand(ctx, installableObj, target, "init", scriptConfig.Init, varsFile, envVars,
+			dryRun)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	}
+
+	err = i.runCommand(ctx, installableObj, target, target, command, varsFile, envVars, dryRun)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	log.Logger.Infof("Kapp '%s' successfully processed (approved=%v, dry run=%v)",
+		installableObj.FullyQualifiedId(), approved, dryRun)
+
+	return nil
+}
+
+// Runs a command in the directory containing the kapp's sugarkube.yaml file, appending the path to
+// the vars file to its arguments
+func (i ScriptInstaller) runCommand(ctx context.Context, installableObj interfaces.IInstallable,
+	target string, name string, command string, varsFile string, envVars map[string]string,
+	dryRun bool) error {
+
+	fields := strings.Fields(command)
+	dir := kappDir(installableObj, "")
+
+	// scripts in the kapp take precedence over executables on the PATH
+	executable := kappDir(installableObj, fields[0])
+	if info, err := os.Stat(executable); err != nil || info.IsDir() {
+		executable = fields[0]
+	}
+
+	args := append(fields[1:], varsFile)
+
+	log.Logger.Infof("Running the %s script of kapp '%s'...", name, installableObj.FullyQualifiedId())
+
+	var stdoutBuf, stderrBuf bytes.Buffer
+	err := utils.ExecCommandContext(ctx, executable, args, envVars, &stdoutBuf, &stderrBuf, dir,
+		timeoutSeconds(installableObj, target), dryRun)
+
+	logCommandOutput(ctx, stdoutBuf.String(), stderrBuf.String())
+
+	if err != nil {
+		return errors.Wrapf(err, "Error running the %s script of kapp '%s'", name,
+			installableObj.FullyQualifiedId())
+	}
+
+	return nil
+}
+
+// Writes the kapp's merged and templated vars to a temporary file in the given format, returning
+// its path. The file should be deleted once the script has run.
+func writeVarsFile(installableObj interfaces.IInstallable, stack interfaces.IStack,
+	installerVars map[string]interface{}, format string, dryRun bool) (string, error) {
+
+	format = strings.ToLower(format)
+	if format == "" {
+		format = scriptVarsFormatJson
+	}
+
+	templatedVars, err := stack.GetTemplatedVars(installableObj, installerVars)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	var data []byte
+	switch format {
+	case scriptVarsFormatJson:
+		jsonVars, err := convert.ToJsonCompatible(templatedVars)
+		if err != nil {
+			return "", errors.WithStack(err)
+		}
+
+		data, err = json.MarshalIndent(jsonVars, "", "  ")
+		if err != nil {
+			return "", errors.WithStack(err)
+		}
+	case scriptVarsFormatYaml:
+		data, err = yaml.Marshal(templatedVars)
+		if err != nil {
+			return "", errors.WithStack(err)
+		}
+	default:
+		return "", errors.New(fmt.Sprintf("Unsupported vars format '%s' for kapp '%s'. Valid "+
+			"formats are: %s, %s", format, installableObj.FullyQualifiedId(), scriptVarsFormatJson,
+			scriptVarsFormatYaml))
+	}
+
+	if dryRun {
+		path := filepath.Join(os.TempDir(), fmt.Sprintf("sugarkube-vars.%s", format))
+		log.Logger.Infof("[Dry run] Would write vars for kapp '%s' to '%s'",
+			installableObj.FullyQualifiedId(), path)
+		return path, nil
+	}
+
+	// temp files are only readable by the current user
+	file, err := ioutil.TempFile("", fmt.Sprintf("sugarkube-vars-*.%s", format))
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	defer file.Close()
+
+	_, err = file.Write(data)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	log.Logger.Debugf("Wrote vars for kapp '%s' to '%s'", installableObj.FullyQualifiedId(),
+		file.Name())
+
+	return file.Name(), nil
+}
+
+// Install a kapp
+func (i ScriptInstaller) Install(ctx context.Context, installableObj interfaces.IInstallable, stack interfaces.IStack,
+	approved bool, dryRun bool) error {
+	log.Logger.Infof("Installing kapp '%s' (approved=%v, dry run=%v)...",
+		installableObj.FullyQualifiedId(), approved, dryRun)
+	return i.run(ctx, TargetInstall, installableObj, stack, approved, dryRun)
+}
+
+// Delete a kapp
+func (i ScriptInstaller) Delete(ctx context.Context, installableObj interfaces.IInstallable, stack interfaces.IStack,
+	approved bool, dryRun bool) error {
+	log.Logger.Infof("Deleting kapp '%s' (approved=%v, dry run=%v)...",
+		installableObj.FullyQualifiedId(), approved, dryRun)
+	return i.run(ctx, TargetDelete, installableObj, stack, approved, dryRun)
+}
+
+// Get a kapp's outputs
+func (i ScriptInstaller) Output(ctx context.Context, installableObj interfaces.IInstallable, stack interfaces.IStack,
+	dryRun bool) error {
+	log.Logger.Infof("Getting output for kapp '%s'...", installableObj.FullyQualifiedId())
+	return i.run(ctx, TargetOutput, installableObj, stack, true, dryRun)
+}
+
+// Clean a kapp
+func (i ScriptInstaller) Clean(ctx context.Context, installableObj interfaces.IInstallable, stack interfaces.IStack,
+	dryRun bool) error {
+	log.Logger.Infof("Cleaning kapp '%s'...", installableObj.FullyQualifiedId())
+	return i.run(ctx, TargetClean, installableObj, stack, true, dryRun)
+}
+
+func (i ScriptInstaller) GetVars(action string, approved bool) map[string]interface{} {
+	return map[string]interface{}{
+		"action":   action,
+		"approved": fmt.Sprintf("%v", approved)}
+}
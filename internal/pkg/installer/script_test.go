@@ -0,0 +1,123 @@
+/*
+ * Copyright 2019 The Sugarkube Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package installer
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/sugarkube/sugarkube/internal/pkg/interfaces"
+	"github.com/sugarkube/sugarkube/internal/pkg/provider"
+	"github.com/sugarkube/sugarkube/internal/pkg/structs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Copies the vars file to the kapp directory and records how it was called
+const fakeScript = `#!/bin/sh
+echo "$(basename "$0") $# $APPROVED" >> "%[1]s/invocations.log"
+cp "$1" vars.out
+printf "$1" > vars.path
+[ "$1" = "$SUGARKUBE_VARS_FILE" ]
+`
+
+// Returns a kapp with fake scripts in its bin directory
+func scriptKapp(t *testing.T, scriptConfig structs.Script) interfaces.IInstallable {
+	installableObj := newTestKapp(t, structs.KappDescriptorWithMaps{
+		KappConfig: structs.KappConfig{Installer: SCRIPT, Script: scriptConfig},
+	}, "")
+
+	for _, name := range []string{"init.sh", "install.sh", "delete.sh"} {
+		writeFakeExecutable(t, filepath.Join(installableObj.GetCacheDir(), "bin"), name, fakeScript)
+	}
+
+	return installableObj
+}
+
+func TestScriptInstaller(t *testing.T) {
+	installableObj := scriptKapp(t, structs.Script{
+		Init:    "bin/init.sh",
+		Install: "bin/install.sh",
+		Delete:  "sh bin/delete.sh",
+	})
+
+	installerImpl, err := New(installableObj, &provider.LocalProvider{})
+	assert.Nil(t, err)
+	assert.Equal(t, SCRIPT, installerImpl.Name())
+
+	kappDir := installableObj.GetCacheDir()
+
+	assert.Nil(t, installerImpl.Install(context.Background(), installableObj, testStack(), true, false))
+
+	data, err := ioutil.ReadFile(filepath.Join(kappDir, "vars.out"))
+	assert.Nil(t, err)
+
+	var vars map[string]interface{}
+	assert.Nil(t, json.Unmarshal(data, &vars))
+	assert.Equal(t, map[string]interface{}{
+		"stack": map[string]interface{}{"provider": "local"},
+		"kapp": map[string]interface{}{
+			"vars": map[string]interface{}{
+				"replicas": float64(3),
+				"tags":     map[string]interface{}{"team": "ops"},
+				"zones":    []interface{}{"a", "b"},
+			},
+		},
+	}, vars)
+
+	// scripts can be run by other executables on the PATH
+	assert.Nil(t, installerImpl.Delete(context.Background(), installableObj, testStack(), false, false))
+
+	assert.Equal(t, []string{"init.sh 1 true", "install.sh 1 true", "init.sh 1 false", "delete.sh 1 false"},
+		readInvocations(t, filepath.Join(kappDir, "bin")))
+
+	// vars files are deleted once scripts have run
+	varsPath, err := ioutil.ReadFile(filepath.Join(kappDir, "vars.path"))
+	assert.Nil(t, err)
+	assert.True(t, strings.HasSuffix(string(varsPath), ".json"))
+	_, err = os.Stat(string(varsPath))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestScriptInstallerYaml(t *testing.T) {
+	installableObj := scriptKapp(t, structs.Script{
+		Install:    "bin/install.sh",
+		VarsFormat: "YAML",
+	})
+
+	installerImpl := ScriptInstaller{}
+	assert.Nil(t, installerImpl.Install(context.Background(), installableObj, testStack(), false, false))
+
+	data, err := ioutil.ReadFile(filepath.Join(installableObj.GetCacheDir(), "vars.out"))
+	assert.Nil(t, err)
+	assert.Contains(t, string(data), "tags:\n      team: ops\n")
+}
+
+func TestScriptInstallerMissingScript(t *testing.T) {
+	installableObj := scriptKapp(t, structs.Script{Install: "bin/install.sh"})
+
+	installerImpl := ScriptInstaller{}
+
+	err := installerImpl.Delete(context.Background(), installableObj, testStack(), true, false)
+	assert.Error(t, err)
+
+	// kapps don't need to be able to clean themselves
+	assert.Nil(t, installerImpl.Clean(context.Background(), installableObj, testStack(), false))
+}
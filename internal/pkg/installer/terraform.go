@@ -0,0 +1,376 @@
+/*
+ * Copyright 2019 The Sugarkube Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package installer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/sugarkube/sugarkube/internal/pkg/cacher"
+	"github.com/sugarkube/sugarkube/internal/pkg/constants"
+	"github.com/sugarkube/sugarkube/internal/pkg/interfaces"
+	"github.com/sugarkube/sugarkube/internal/pkg/log"
+	"github.com/sugarkube/sugarkube/internal/pkg/utils"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// Installs kapps by running terraform directly, so they don't need a Makefile
+type TerraformInstaller struct {
+	provider   interfaces.IProvider
+	savedPlans map[string]bool // paths of plans saved by this installer, i.e. during this run
+}
+
+const defaultTerraformBinary = "terraform"
+
+// terraform configs are in this directory suffixed with the provider name unless configured otherwise
+const terraformDirPrefix = "terraform_"
+
+// directory under a kapp's hidden cache directory that plans are saved to
+const terraformPlanDir = "terraform"
+const terraformPlanFile = "plan.tfplan"
+
+// var files are looked for in the terraform directory with this extension
+const tfvarsExtension = ".tfvars"
+
+// Return the name of this installer
+func (i TerraformInstaller) Name() string {
+	return TERRAFORM
+}
+
+// Runs terraform for the given target. Installing without approval saves a plan that's applied
+// when installing with approval.
+func (i TerraformInstaller) run(ctx context.Context, target string, installableObj interfaces.IInstallable,
+	stack interfaces.IStack, approved bool, dryRun bool) error {
+
+	dir, err := terraformDir(installableObj, stack)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	planPath := filepath.Join(installableObj.GetCacheDir(), cacher.CacheDir, terraformPlanDir,
+		terraformPlanFile)
+
+	if target == TargetClean {
+		return removePaths(installableObj, []string{filepath.Join(dir, ".terraform"), planPath}, dryRun)
+	}
+
+	envVars, err := i.envVars(installableObj, stack, approved)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	varFiles, err := findVarFiles(dir, stack.GetConfig())
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	terraform := func(command string, args ...string) (string, error) {
+		return i.terraform(ctx, installableObj, target, dir, envVars, dryRun, command, args...)
+	}
+
+	initArgs := []string{"-input=false"}
+	initArgs = append(initArgs, backendConfigArgs(installableObj)...)
+
+	_, err = terraform("init", initArgs...)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	switch target {
+	case TargetInstall:
+		// approved installs apply the plan saved when the kapp was planned earlier in this run, or
+		// plan first. Plans saved by previous runs may be stale so are never applied.
+		_, err = os.Stat(planPath)
+		if !approved || os.IsNotExist(err) || !i.savedPlans[planPath] {
+			if !dryRun {
+				err = os.MkdirAll(filepath.Dir(planPath), 0700)
+				if err != nil {
+					return errors.WithStack(err)
+				}
+			}
+
+			_, err = terraform("plan", append([]string{"-input=false",
+				fmt.Sprintf("-out=%s", planPath)}, varFiles...)...)
+			if err != nil {
+				return errors.WithStack(err)
+			}
+
+			if i.savedPlans != nil {
+				i.savedPlans[planPath] = true
+			}
+		}
+
+		if !approved {
+			break
+		}
+
+		_, applyErr := terraform("apply", "-input=false", "-auto-approve", planPath)
+
+		// plans can't be applied twice, and plans that failed to apply would fail again when retried
+		delete(i.savedPlans, planPath)
+		err = removePaths(installableObj, []string{planPath}, dryRun)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		if applyErr != nil {
+			return errors.WithStack(applyErr)
+		}
+	case TargetDelete:
+		if approved {
+			_, err = terraform("destroy", append([]string{"-input=false", "-auto-approve"},
+				varFiles...)...)
+		} else {
+			_, err = terraform("plan", append([]string{"-destroy", "-input=false"}, varFiles...)...)
+		}
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	case TargetOutput:
+		stdout, err := terraform("output", "-json")
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		err = writeTerraformOutput(installableObj, stdout, dryRun)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	}
+
+	log.Logger.Infof("Kapp '%s' successfully processed (approved=%v, dry run=%v)",
+		installableObj.FullyQualifiedId(), approved, dryRun)
+
+	return nil
+}
+
+// Runs a terraform command in the given directory, returning its stdout. Args configured for the
+// command under `args.terraform.<command>` are appended as `-<name>=<value>` options.
+func (i TerraformInstaller) terraform(ctx context.Context, installableObj interfaces.IInstallable,
+	target string, dir string, envVars map[string]string, dryRun bool, command string,
+	args ...string) (string, error) {
+
+	binary := installableObj.GetDescriptor().Terraform.Binary
+	if binary == "" {
+		binary = defaultTerraformBinary
+	}
+
+	args = append(args, configuredArgs(installableObj, i.Name(), command, "-")...)
+
+	log.Logger.Infof("Running 'terraform %s' for kapp '%s'...", command,
+		installableObj.FullyQualifiedId())
+
+	var stdoutBuf, stderrBuf bytes.Buffer
+	err := utils.ExecCommandContext(ctx, binary, append([]string{command}, args...), envVars,
+		&stdoutBuf, &stderrBuf, dir, timeoutSeconds(installableObj, target), dryRun)
+
+	logCommandOutput(ctx, stdoutBuf.String(), stderrBuf.String())
+
+	if err != nil {
+		return "", errors.Wrapf(err, "Error running 'terraform %s' for kapp '%s'", command,
+			installableObj.FullyQualifiedId())
+	}
+
+	return stdoutBuf.String(), nil
+}
+
+// Returns the env vars to run terraform with. As well as the env vars passed by other installers,
+// each kapp var is passed as a TF_VAR_ env var so it can be used as a terraform variable. Values
+// that aren't strings are JSON-encoded.
+func (i TerraformInstaller) envVars(installableObj interfaces.IInstallable, stack interfaces.IStack,
+	approved bool) (map[string]string, error) {
+
+	envVars, err := commonEnvVars(i.provider, installableObj, stack, approved)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	envVars["TF_IN_AUTOMATION"] = "true"
+
+	kappVars, err := kappVars(installableObj, stack)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	for k, v := range kappVars {
+		value, ok := v.(string)
+		if !ok {
+			jsonValue, err := json.Marshal(v)
+			if err != nil {
+				return nil, errors.Wrapf(err, "Error encoding kapp var '%s' for terraform", k)
+			}
+			value = string(jsonValue)
+		}
+
+		envVars["TF_VAR_"+k] = value
+	}
+
+	return envVars, nil
+}
+
+// Returns the directory containing the kapp's terraform configs
+func terraformDir(installableObj interfaces.IInstallable, stack interfaces.IStack) (string, error) {
+	dir := installableObj.GetDescriptor().Terraform.Dir
+	if dir == "" {
+		dir = terraformDirPrefix + stack.GetConfig().GetProvider()
+	}
+
+	dir = kappDir(installableObj, dir)
+
+	info, err := os.Stat(dir)
+	if err != nil || !info.IsDir() {
+		return "", errors.New(fmt.Sprintf("Terraform directory '%s' for kapp '%s' doesn't exist",
+			dir, installableObj.FullyQualifiedId()))
+	}
+
+	return dir, nil
+}
+
+// Returns -backend-config options for each configured backend setting, sorted by key
+func backendConfigArgs(installableObj interfaces.IInstallable) []string {
+	backendConfig := installableObj.GetDescriptor().Terraform.BackendConfig
+
+	keys := make([]string, 0, len(backendConfig))
+	for k := range backendConfig {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	args := make([]string, 0, len(keys))
+	for _, k := range keys {
+		args = append(args, fmt.Sprintf("-backend-config=%s=%s", k, backendConfig[k]))
+	}
+
+	return args
+}
+
+// Returns -var-file options for var files in the terraform directory in increasing order of
+// precedence. These are `defaults.tfvars`, then files named after the provider, provisioner,
+// account, profile, cluster and region, then any generated files (`_generated_*.tfvars`).
+func findVarFiles(dir string, stackConfig interfaces.IStackConfig) ([]string, error) {
+	fileNames := []string{"defaults" + tfvarsExtension}
+	for _, name := range stackFileNames(stackConfig) {
+		fileNames = append(fileNames, name+tfvarsExtension)
+	}
+
+	paths, err := findStackFiles(dir, fileNames, tfvarsExtension)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	args := make([]string, 0, len(paths))
+	for _, path := range paths {
+		args = append(args, fmt.Sprintf("-var-file=%s", path))
+	}
+
+	return args, nil
+}
+
+// Writes the output of `terraform output -json` to the path of the kapp's terraform output
+func writeTerraformOutput(installableObj interfaces.IInstallable, output string, dryRun bool) error {
+	outputObj, ok := installableObj.GetDescriptor().Outputs[constants.TerraformOutputId]
+	if !ok {
+		return errors.New(fmt.Sprintf("Kapp '%s' doesn't have an output called '%s'",
+			installableObj.FullyQualifiedId(), constants.TerraformOutputId))
+	}
+
+	path, err := filepath.Abs(filepath.Join(installableObj.GetConfigFileDir(), outputObj.Path))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if dryRun {
+		log.Logger.Infof("[Dry run] Would write terraform output for kapp '%s' to '%s'",
+			installableObj.FullyQualifiedId(), path)
+		return nil
+	}
+
+	log.Logger.Debugf("Writing terraform output for kapp '%s' to '%s'",
+		installableObj.FullyQualifiedId(), path)
+
+	// outputs may contain secrets
+	err = ioutil.WriteFile(path, []byte(output), 0600)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return nil
+}
+
+// Deletes the given paths if they exist
+func removePaths(installableObj interfaces.IInstallable, paths []string, dryRun bool) error {
+	for _, path := range paths {
+		if _, err := os.Stat(path); err != nil {
+			continue
+		}
+
+		if dryRun {
+			log.Logger.Infof("[Dry run] Would delete '%s' for kapp '%s'", path,
+				installableObj.FullyQualifiedId())
+			continue
+		}
+
+		log.Logger.Debugf("Deleting '%s' for kapp '%s'", path, installableObj.FullyQualifiedId())
+		err := os.RemoveAll(path)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	}
+
+	return nil
+}
+
+// Install a kapp
+func (i TerraformInstaller) Install(ctx context.Context, installableObj interfaces.IInstallable, stack interfaces.IStack,
+	approved bool, dryRun bool) error {
+	log.Logger.Infof("Installing kapp '%s' (approved=%v, dry run=%v)...",
+		installableObj.FullyQualifiedId(), approved, dryRun)
+	return i.run(ctx, TargetInstall, installableObj, stack, approved, dryRun)
+}
+
+// Delete a kapp
+func (i TerraformInstaller) Delete(ctx context.Context, installableObj interfaces.IInstallable, stack interfaces.IStack,
+	approved bool, dryRun bool) error {
+	log.Logger.Infof("Deleting kapp '%s' (approved=%v, dry run=%v)...",
+		installableObj.FullyQualifiedId(), approved, dryRun)
+	return i.run(ctx, TargetDelete, installableObj, stack, approved, dryRun)
+}
+
+// Get a kapp's outputs
+func (i TerraformInstaller) Output(ctx context.Context, installableObj interfaces.IInstallable, stack interfaces.IStack,
+	dryRun bool) error {
+	log.Logger.Infof("Getting output for kapp '%s'...", installableObj.FullyQualifiedId())
+	return i.run(ctx, TargetOutput, installableObj, stack, true, dryRun)
+}
+
+// Clean a kapp
+func (i TerraformInstaller) Clean(ctx context.Context, installableObj interfaces.IInstallable, stack interfaces.IStack,
+	dryRun bool) error {
+	log.Logger.Infof("Cleaning kapp '%s'...", installableObj.FullyQualifiedId())
+	return i.run(ctx, TargetClean, installableObj, stack, true, dryRun)
+}
+
+func (i TerraformInstaller) GetVars(action string, approved bool) map[string]interface{} {
+	return map[string]interface{}{
+		"action":   action,
+		"approved": fmt.Sprintf("%v", approved)}
+}
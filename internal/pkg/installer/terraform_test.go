@@ -0,0 +1,218 @@
+/*
+ * Copyright 2019 The Sugarkube Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package installer
+
+import (
+	"context"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/sugarkube/sugarkube/internal/pkg/cacher"
+	"github.com/sugarkube/sugarkube/internal/pkg/constants"
+	"github.com/sugarkube/sugarkube/internal/pkg/interfaces"
+	"github.com/sugarkube/sugarkube/internal/pkg/mock"
+	"github.com/sugarkube/sugarkube/internal/pkg/provider"
+	"github.com/sugarkube/sugarkube/internal/pkg/structs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// A fake terraform binary that records its args and env, saves plans and writes outputs. Applying
+// fails if a file called fail-apply exists
+const fakeTerraform = `#!/bin/sh
+echo "$@" >> "%[1]s/invocations.log"
+echo "$TF_IN_AUTOMATION $TF_VAR_region $TF_VAR_tags" > "%[1]s/env.log"
+for arg; do
+  case "$arg" in
+    -out=*) touch "${arg#-out=}" ;;
+  esac
+done
+if [ "$1" = apply ] && [ -f "%[1]s/fail-apply" ]; then
+  exit 1
+fi
+if [ "$1" = output ]; then
+  echo '{"bucket": {"sensitive": false, "type": "string", "value": "state-bucket"}}'
+fi
+`
+
+// Returns a kapp that uses a fake terraform binary, and the directory the binary records its
+// invocations in
+func terraformKapp(t *testing.T) (interfaces.IInstallable, string) {
+	binDir := testDir(t)
+	binaryPath := writeFakeExecutable(t, binDir, "terraform", fakeTerraform)
+
+	installableObj := newTestKapp(t, structs.KappDescriptorWithMaps{}, fmt.Sprintf(`
+installer: terraform
+terraform:
+  binary: %s
+  backend_config:
+    key: kapp.tfstate
+    bucket: state-bucket
+vars:
+  region: eu-west-1
+  tags:
+    team: ops
+`, binaryPath))
+
+	terraformDir := filepath.Join(installableObj.GetCacheDir(), "terraform_local")
+	assert.Nil(t, os.MkdirAll(filepath.Join(terraformDir, ".terraform"), 0755))
+
+	for _, name := range []string{"defaults.tfvars", "dev.tfvars", "_generated_vpc.tfvars", "prod.tfvars"} {
+		assert.Nil(t, ioutil.WriteFile(filepath.Join(terraformDir, name), []byte{}, 0644))
+	}
+
+	return installableObj, binDir
+}
+
+func TestTerraformInstaller(t *testing.T) {
+	installableObj, binDir := terraformKapp(t)
+
+	installerImpl, err := New(installableObj, &provider.LocalProvider{})
+	assert.Nil(t, err)
+	assert.Equal(t, TERRAFORM, installerImpl.Name())
+
+	stackObj := testStack()
+	ctx := context.Background()
+
+	terraformDir := filepath.Join(installableObj.GetCacheDir(), "terraform_local")
+	planPath := filepath.Join(installableObj.GetCacheDir(), cacher.CacheDir, "terraform", "plan.tfplan")
+	initArgs := "init -input=false -backend-config=bucket=state-bucket -backend-config=key=kapp.tfstate"
+	varFileArgs := fmt.Sprintf("-var-file=%[1]s/defaults.tfvars -var-file=%[1]s/dev.tfvars "+
+		"-var-file=%[1]s/_generated_vpc.tfvars", terraformDir)
+
+	// planning saves a plan
+	assert.Nil(t, installerImpl.Install(ctx, installableObj, stackObj, false, false))
+	assert.Equal(t, []string{
+		initArgs,
+		fmt.Sprintf("plan -input=false -out=%s %s", planPath, varFileArgs),
+	}, readInvocations(t, binDir))
+	assert.FileExists(t, planPath)
+
+	env, err := ioutil.ReadFile(filepath.Join(binDir, "env.log"))
+	assert.Nil(t, err)
+	assert.Equal(t, "true eu-west-1 {\"team\":\"ops\"}\n", string(env))
+
+	// approving applies the saved plan
+	assert.Nil(t, installerImpl.Install(ctx, installableObj, stackObj, true, false))
+	assert.Equal(t, []string{
+		initArgs,
+		fmt.Sprintf("apply -input=false -auto-approve %s", planPath),
+	}, readInvocations(t, binDir))
+	_, err = os.Stat(planPath)
+	assert.True(t, os.IsNotExist(err))
+
+	// outputs are written without the kapp declaring them
+	assert.True(t, installableObj.HasOutputs())
+	assert.Nil(t, installerImpl.Output(ctx, installableObj, stackObj, false))
+	assert.Equal(t, []string{initArgs, "output -json"}, readInvocations(t, binDir))
+
+	outputs, err := installableObj.GetOutputs(false, false)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{
+		constants.TerraformOutputId: map[string]interface{}{
+			"bucket": map[string]interface{}{
+				"sensitive": false,
+				"type":      "string",
+				"value":     "state-bucket",
+			},
+		},
+	}, outputs)
+
+	// the output isn't sensitive unless the kapp declares it is so is kept
+	assert.FileExists(t, filepath.Join(installableObj.GetCacheDir(), constants.TerraformOutputPath))
+
+	assert.Nil(t, installerImpl.Delete(ctx, installableObj, stackObj, false, false))
+	assert.Equal(t, []string{initArgs, "plan -destroy -input=false " + varFileArgs},
+		readInvocations(t, binDir))
+
+	assert.Nil(t, installerImpl.Delete(ctx, installableObj, stackObj, true, false))
+	assert.Equal(t, []string{initArgs, "destroy -input=false -auto-approve " + varFileArgs},
+		readInvocations(t, binDir))
+
+	assert.Nil(t, installerImpl.Clean(ctx, installableObj, stackObj, false))
+	_, err = os.Stat(filepath.Join(terraformDir, ".terraform"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestTerraformInstallerApprovedWithoutPlan(t *testing.T) {
+	installableObj, binDir := terraformKapp(t)
+
+	installerImpl := TerraformInstaller{provider: &provider.LocalProvider{}}
+
+	assert.Nil(t, installerImpl.Install(context.Background(), installableObj, testStack(), true, false))
+
+	log := readInvocations(t, binDir)
+	assert.Equal(t, 3, len(log))
+	assert.True(t, strings.HasPrefix(log[1], "plan "))
+	assert.True(t, strings.HasPrefix(log[2], "apply "))
+}
+
+func TestTerraformInstallerStalePlan(t *testing.T) {
+	installableObj, binDir := terraformKapp(t)
+
+	ctx := context.Background()
+	stackObj := testStack()
+	planPath := filepath.Join(installableObj.GetCacheDir(), cacher.CacheDir, "terraform", "plan.tfplan")
+
+	// a plan saved by a previous run isn't applied
+	previousRun, err := New(installableObj, &provider.LocalProvider{})
+	assert.Nil(t, err)
+	assert.Nil(t, previousRun.Install(ctx, installableObj, stackObj, false, false))
+	readInvocations(t, binDir)
+	assert.FileExists(t, planPath)
+
+	installerImpl, err := New(installableObj, &provider.LocalProvider{})
+	assert.Nil(t, err)
+	assert.Nil(t, installerImpl.Install(ctx, installableObj, stackObj, true, false))
+
+	log := readInvocations(t, binDir)
+	assert.Equal(t, 3, len(log))
+	assert.True(t, strings.HasPrefix(log[1], "plan "))
+	assert.True(t, strings.HasPrefix(log[2], "apply "))
+
+	// plans that fail to apply are deleted so retries plan again
+	assert.Nil(t, installerImpl.Install(ctx, installableObj, stackObj, false, false))
+	readInvocations(t, binDir)
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(binDir, "fail-apply"), []byte{}, 0644))
+
+	assert.Error(t, installerImpl.Install(ctx, installableObj, stackObj, true, false))
+	log = readInvocations(t, binDir)
+	assert.Equal(t, 2, len(log))
+	assert.True(t, strings.HasPrefix(log[1], "apply "))
+	_, err = os.Stat(planPath)
+	assert.True(t, os.IsNotExist(err))
+
+	assert.Error(t, installerImpl.Install(ctx, installableObj, stackObj, true, false))
+	log = readInvocations(t, binDir)
+	assert.Equal(t, 3, len(log))
+	assert.True(t, strings.HasPrefix(log[1], "plan "))
+}
+
+func TestTerraformInstallerMissingDir(t *testing.T) {
+	installableObj, _ := terraformKapp(t)
+
+	installerImpl := TerraformInstaller{provider: &provider.LocalProvider{}}
+
+	stackObj := testStack().(*mock.MockStack)
+	stackObj.Config = mock.Config{Provider: "aws", Profile: "dev", Cluster: "cluster1"}
+
+	err := installerImpl.Install(context.Background(), installableObj, stackObj, false, false)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "terraform_aws")
+}
@@ -0,0 +1,359 @@
+/*
+ * Copyright 2019 The Sugarkube Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package installer
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/imdario/mergo"
+	"github.com/pkg/errors"
+	"github.com/sugarkube/sugarkube/internal/pkg/cacher"
+	"github.com/sugarkube/sugarkube/internal/pkg/config"
+	"github.com/sugarkube/sugarkube/internal/pkg/installable"
+	"github.com/sugarkube/sugarkube/internal/pkg/interfaces"
+	"github.com/sugarkube/sugarkube/internal/pkg/log"
+	"github.com/sugarkube/sugarkube/internal/pkg/structs"
+	"github.com/sugarkube/sugarkube/internal/pkg/templater"
+	"github.com/sugarkube/sugarkube/internal/pkg/utils"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Installs kapps by running the units declared in their sugarkube.yaml files instead of a Makefile
+type UnitsInstaller struct {
+	provider interfaces.IProvider
+}
+
+// directory under a kapp's hidden cache directory that scripts for each unit are written to
+const unitsScriptsDir = "units"
+
+// Return the name of this installer
+func (i UnitsInstaller) Name() string {
+	return UNITS
+}
+
+// Runs each of the kapp's units for the target. Units are run in the order they're declared,
+// or in reverse order when deleting. Between units the kapp's outputs are reloaded and its
+// templates and descriptor are rerendered so later units can use the results of earlier ones.
+func (i UnitsInstaller) run(ctx context.Context, target string, installableObj interfaces.IInstallable,
+	stack interfaces.IStack, approved bool, dryRun bool) error {
+
+	units, err := resolveUnits(installableObj, target)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if len(units) == 0 {
+		return errors.New(fmt.Sprintf("Kapp '%s' doesn't declare any units",
+			installableObj.FullyQualifiedId()))
+	}
+
+	for index := 0; index < len(units); index++ {
+		if index > 0 && (target == TargetInstall || target == TargetDelete) {
+			err = i.refresh(installableObj, stack, target, approved, dryRun)
+			if err != nil {
+				return errors.WithStack(err)
+			}
+
+			// commands may refer to outputs so need rereading after templating the descriptor again
+			units, err = resolveUnits(installableObj, target)
+			if err != nil {
+				return errors.WithStack(err)
+			}
+		}
+
+		unit := units[index]
+
+		ran, err := i.runUnit(ctx, unit, target, installableObj, stack, approved, dryRun)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		// write outputs straight away so the next unit can use them
+		if ran && target == TargetInstall && approved && len(unit.Output) > 0 {
+			_, err = i.runUnit(ctx, unit, TargetOutput, installableObj, stack, approved, dryRun)
+			if err != nil {
+				return errors.WithStack(err)
+			}
+		}
+	}
+
+	log.Logger.Infof("Kapp '%s' successfully processed (approved=%v, dry run=%v)",
+		installableObj.FullyQualifiedId(), approved, dryRun)
+
+	return nil
+}
+
+// Returns the kapp's units in the order they should be run for the target. Fields of units with
+// the same ID as a unit in the global config that aren't set by the kapp are taken from the
+// global unit.
+func resolveUnits(installableObj interfaces.IInstallable, target string) ([]structs.Unit, error) {
+	declaredUnits := installableObj.GetDescriptor().Units
+	units := make([]structs.Unit, 0, len(declaredUnits))
+
+	for index, unit := range declaredUnits {
+		if unit.Id == "" {
+			return nil, errors.New(fmt.Sprintf("Unit %d of kapp '%s' doesn't have an ID", index,
+				installableObj.FullyQualifiedId()))
+		}
+
+		if config.CurrentConfig != nil {
+			// viper lowercases keys
+			globalUnit, ok := config.CurrentConfig.Units[strings.ToLower(unit.Id)]
+			if ok {
+				err := mergo.Merge(&unit, globalUnit)
+				if err != nil {
+					return nil, errors.WithStack(err)
+				}
+			}
+		}
+
+		units = append(units, unit)
+	}
+
+	if target == TargetDelete {
+		for left, right := 0, len(units)-1; left < right; left, right = left+1, right-1 {
+			units[left], units[right] = units[right], units[left]
+		}
+	}
+
+	return units, nil
+}
+
+// Returns the name of the list of commands a unit should run for a target and the commands
+// themselves, including its init commands if any commands should be run
+func unitCommands(unit structs.Unit, target string, approved bool) (string, []string) {
+	var phase string
+	var commands []string
+
+	switch target {
+	case TargetInstall:
+		phase, commands = "plan", unit.Plan
+		if approved {
+			phase, commands = "apply", unit.Apply
+		}
+	case TargetDelete:
+		phase, commands = "plan_delete", unit.PlanDelete
+		if approved {
+			phase, commands = "delete", unit.Delete
+		}
+	case TargetOutput:
+		phase, commands = "output", unit.Output
+	case TargetClean:
+		return "clean", unit.Clean
+	}
+
+	if len(commands) == 0 {
+		return phase, nil
+	}
+
+	return phase, append(append([]string{}, unit.Init...), commands...)
+}
+
+// Runs a unit's commands for the target if its conditions are true. Returns whether any
+// commands were run.
+func (i UnitsInstaller) runUnit(ctx context.Context, unit structs.Unit, target string,
+	installableObj interfaces.IInstallable, stack interfaces.IStack, approved bool,
+	dryRun bool) (bool, error) {
+
+	phase, commands := unitCommands(unit, target, approved)
+	if len(commands) == 0 {
+		log.Logger.Debugf("Unit '%s' of kapp '%s' has no %s commands", unit.Id,
+			installableObj.FullyQualifiedId(), phase)
+		return false, nil
+	}
+
+	if len(unit.Conditions) > 0 {
+		templatedVars, err := stack.GetTemplatedVars(installableObj, i.GetVars(target, approved))
+		if err != nil {
+			return false, errors.WithStack(err)
+		}
+
+		for _, condition := range unit.Conditions {
+			result, err := templater.EvaluateCondition(condition, templatedVars)
+			if err != nil {
+				return false, errors.Wrapf(err, "Error evaluating the conditions of unit '%s' "+
+					"of kapp '%s'", unit.Id, installableObj.FullyQualifiedId())
+			}
+
+			if !result {
+				log.Logger.Infof("Not running unit '%s' of kapp '%s' because its condition '%s' "+
+					"is false", unit.Id, installableObj.FullyQualifiedId(), condition)
+				return false, nil
+			}
+		}
+	}
+
+	envVars, err := commonEnvVars(i.provider, installableObj, stack, approved)
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+
+	for k, v := range unit.EnvVars {
+		envVars[strings.ToUpper(k)] = v
+	}
+
+	dir := unitDir(installableObj, unit)
+
+	if !dryRun {
+		scriptPath, err := writeUnitScript(installableObj, unit, phase, dir, envVars, commands)
+		if err != nil {
+			return false, errors.WithStack(err)
+		}
+		log.Logger.Debugf("Wrote script for unit '%s' of kapp '%s' to '%s'", unit.Id,
+			installableObj.FullyQualifiedId(), scriptPath)
+	}
+
+	log.Logger.Infof("Running the %s commands of unit '%s' of kapp '%s' with APPROVED=%v...",
+		phase, unit.Id, installableObj.FullyQualifiedId(), approved)
+
+	var stdoutBuf, stderrBuf bytes.Buffer
+	err = utils.ExecCommandContext(ctx, "sh", []string{"-e", "-c", strings.Join(commands, "\n")},
+		envVars, &stdoutBuf, &stderrBuf, dir, timeoutSeconds(installableObj, target), dryRun)
+
+	logCommandOutput(ctx, stdoutBuf.String(), stderrBuf.String())
+
+	if err != nil {
+		return false, errors.Wrapf(err, "Error running the %s commands of unit '%s'", phase, unit.Id)
+	}
+
+	return true, nil
+}
+
+// Returns the directory to run a unit's commands in. Relative paths are relative to the directory
+// containing the kapp's sugarkube.yaml file.
+func unitDir(installableObj interfaces.IInstallable, unit structs.Unit) string {
+	return kappDir(installableObj, unit.Dir)
+}
+
+// Loads any outputs the kapp has written so far and rerenders its templates and descriptor so
+// the next unit can use them
+func (i UnitsInstaller) refresh(installableObj interfaces.IInstallable, stack interfaces.IStack,
+	target string, approved bool, dryRun bool) error {
+
+	if installableObj.HasOutputs() && installableObj.GetLocalRegistry() != nil {
+		outputs, err := installableObj.GetOutputs(true, dryRun)
+		if err != nil {
+			return errors.Wrapf(err, "Error loading the output of kapp '%s'", installableObj.Id())
+		}
+
+		err = installable.AddOutputsToRegistry(installableObj, outputs, installableObj.GetLocalRegistry())
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	}
+
+	return installable.RenderKappTemplates(stack, installableObj, i.GetVars(target, approved), dryRun)
+}
+
+// Writes a shell script that runs a unit's commands the same way the installer ran them so users
+// can rerun them by hand. Scripts are written to the kapp's hidden cache directory.
+func writeUnitScript(installableObj interfaces.IInstallable, unit structs.Unit, phase string,
+	dir string, envVars map[string]string, commands []string) (string, error) {
+
+	scriptDir := filepath.Join(installableObj.GetCacheDir(), cacher.CacheDir, unitsScriptsDir)
+	err := os.MkdirAll(scriptDir, 0755)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	scriptPath := filepath.Join(scriptDir, fmt.Sprintf("%s-%s.sh", unit.Id, phase))
+
+	// scripts contain the values of env vars so should only be readable by the current user
+	err = ioutil.WriteFile(scriptPath, []byte(unitScript(installableObj, unit, phase, dir, envVars,
+		commands)), 0700)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	return scriptPath, nil
+}
+
+// Returns the contents of a shell script that runs a unit's commands
+func unitScript(installableObj interfaces.IInstallable, unit structs.Unit, phase string,
+	dir string, envVars map[string]string, commands []string) string {
+	var builder strings.Builder
+
+	builder.WriteString("#!/bin/sh\n")
+	builder.WriteString(fmt.Sprintf("# Runs the %s commands of unit '%s' of kapp '%s' as "+
+		"sugarkube last ran them\n", phase, unit.Id, installableObj.FullyQualifiedId()))
+	builder.WriteString("set -e\n\n")
+
+	keys := make([]string, 0, len(envVars))
+	for k := range envVars {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		builder.WriteString(fmt.Sprintf("export %s=%s\n", k, shellQuote(envVars[k])))
+	}
+
+	builder.WriteString(fmt.Sprintf("\ncd %s\n\n", shellQuote(dir)))
+
+	for _, command := range commands {
+		builder.WriteString(command)
+		builder.WriteString("\n")
+	}
+
+	return builder.String()
+}
+
+// Quotes a string so it's passed to the shell verbatim
+func shellQuote(value string) string {
+	return "'" + strings.Replace(value, "'", `'\''`, -1) + "'"
+}
+
+// Install a kapp
+func (i UnitsInstaller) Install(ctx context.Context, installableObj interfaces.IInstallable, stack interfaces.IStack,
+	approved bool, dryRun bool) error {
+	log.Logger.Infof("Installing kapp '%s' (approved=%v, dry run=%v)...",
+		installableObj.FullyQualifiedId(), approved, dryRun)
+	return i.run(ctx, TargetInstall, installableObj, stack, approved, dryRun)
+}
+
+// Delete a kapp
+func (i UnitsInstaller) Delete(ctx context.Context, installableObj interfaces.IInstallable, stack interfaces.IStack,
+	approved bool, dryRun bool) error {
+	log.Logger.Infof("Deleting kapp '%s' (approved=%v, dry run=%v)...",
+		installableObj.FullyQualifiedId(), approved, dryRun)
+	return i.run(ctx, TargetDelete, installableObj, stack, approved, dryRun)
+}
+
+// Get a kapp's outputs
+func (i UnitsInstaller) Output(ctx context.Context, installableObj interfaces.IInstallable, stack interfaces.IStack,
+	dryRun bool) error {
+	log.Logger.Infof("Getting output for kapp '%s'...", installableObj.FullyQualifiedId())
+	return i.run(ctx, TargetOutput, installableObj, stack, true, dryRun)
+}
+
+// Clean a kapp
+func (i UnitsInstaller) Clean(ctx context.Context, installableObj interfaces.IInstallable, stack interfaces.IStack,
+	dryRun bool) error {
+	log.Logger.Infof("Cleaning kapp '%s'...", installableObj.FullyQualifiedId())
+	return i.run(ctx, TargetClean, installableObj, stack, true, dryRun)
+}
+
+func (i UnitsInstaller) GetVars(action string, approved bool) map[string]interface{} {
+	return map[string]interface{}{
+		"action":   action,
+		"approved": fmt.Sprintf("%v", approved)}
+}
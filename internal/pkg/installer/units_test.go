@@ -0,0 +1,163 @@
+/*
+ * Copyright 2019 The Sugarkube Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package installer
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/sugarkube/sugarkube/internal/pkg/cacher"
+	"github.com/sugarkube/sugarkube/internal/pkg/config"
+	"github.com/sugarkube/sugarkube/internal/pkg/interfaces"
+	"github.com/sugarkube/sugarkube/internal/pkg/provider"
+	"github.com/sugarkube/sugarkube/internal/pkg/registry"
+	"github.com/sugarkube/sugarkube/internal/pkg/structs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Returns a kapp with the given units and an empty local registry
+func unitsKapp(t *testing.T, units []structs.Unit) interfaces.IInstallable {
+	installableObj := newTestKapp(t, structs.KappDescriptorWithMaps{
+		KappConfig: structs.KappConfig{Units: units},
+	}, `
+outputs:
+- id: db
+  path: db.json
+  format: json
+`)
+
+	localRegistry := registry.New()
+	for k := range localRegistry.AsMap() {
+		localRegistry.Delete(k)
+	}
+	installableObj.SetLocalRegistry(localRegistry)
+
+	return installableObj
+}
+
+func getUnits() []structs.Unit {
+	return []structs.Unit{
+		{
+			Id:      "database",
+			EnvVars: map[string]string{"greeting": "it's me"},
+			Init:    []string{"echo init-database >> invocations.log"},
+			Plan:    []string{"echo plan-database >> invocations.log"},
+			Apply:   []string{`echo "apply-database $GREETING $APPROVED" >> invocations.log`},
+			Output:  []string{`echo '{"host": "db.local"}' > db.json`},
+		},
+		{
+			Id:         "app",
+			Conditions: []string{`eq .stack.provider "local"`},
+			Apply:      []string{"echo apply-app >> invocations.log"},
+			Delete:     []string{"echo delete-app >> invocations.log"},
+		},
+		{
+			Id:         "aws-only",
+			Conditions: []string{`eq .stack.provider "aws"`},
+			Apply:      []string{"echo apply-aws-only >> invocations.log"},
+		},
+	}
+}
+
+func TestUnitsInstall(t *testing.T) {
+	installableObj := unitsKapp(t, getUnits())
+
+	installerImpl := UnitsInstaller{provider: &provider.LocalProvider{}}
+
+	err := installerImpl.Install(context.Background(), installableObj, testStack(), true, false)
+	assert.Nil(t, err)
+
+	// init commands are also run before a unit's output commands
+	assert.Equal(t, []string{"init-database", "apply-database it's me true", "init-database", "apply-app"},
+		readInvocations(t, installableObj.GetCacheDir()))
+
+	// outputs written by the first unit are loaded before running the second one
+	host, ok := installableObj.GetLocalRegistry().Get("outputs.this.db.host")
+	assert.True(t, ok)
+	assert.Equal(t, "db.local", host)
+
+	script, err := ioutil.ReadFile(filepath.Join(installableObj.GetCacheDir(), cacher.CacheDir,
+		unitsScriptsDir, "database-apply.sh"))
+	assert.Nil(t, err)
+	assert.Contains(t, string(script), "export APPROVED='true'\n")
+	assert.Contains(t, string(script), `export GREETING='it'\''s me'`+"\n")
+	assert.Contains(t, string(script), "\necho init-database >> invocations.log\n"+
+		`echo "apply-database $GREETING $APPROVED" >> invocations.log`+"\n")
+}
+
+func TestUnitsPlanAndDelete(t *testing.T) {
+	installableObj := unitsKapp(t, getUnits())
+
+	installerImpl := UnitsInstaller{provider: &provider.LocalProvider{}}
+
+	err := installerImpl.Install(context.Background(), installableObj, testStack(), false, false)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"init-database", "plan-database"}, readInvocations(t, installableObj.GetCacheDir()))
+
+	// units are deleted in reverse order
+	err = installerImpl.Delete(context.Background(), installableObj, testStack(), true, false)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"delete-app"}, readInvocations(t, installableObj.GetCacheDir()))
+}
+
+func TestUnitsFailure(t *testing.T) {
+	installableObj := unitsKapp(t, []structs.Unit{
+		{Id: "broken", Apply: []string{"false", "echo unreachable >> invocations.log"}},
+	})
+
+	installerImpl := UnitsInstaller{provider: &provider.LocalProvider{}}
+
+	err := installerImpl.Install(context.Background(), installableObj, testStack(), true, false)
+	assert.Error(t, err)
+
+	_, err = os.Stat(filepath.Join(installableObj.GetCacheDir(), invocationsFile))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestResolveUnits(t *testing.T) {
+	setTestConfig(t, &config.Config{
+		Units: map[string]structs.Unit{
+			"terraform": {
+				EnvVars: map[string]string{"TF_IN_AUTOMATION": "1"},
+				Init:    []string{"terraform init"},
+				Apply:   []string{"terraform apply"},
+				Delete:  []string{"terraform destroy"},
+			},
+		},
+	})
+
+	installableObj := unitsKapp(t, []structs.Unit{
+		{Id: "Terraform", Apply: []string{"terraform apply -auto-approve"}},
+		{Id: "helm", Apply: []string{"helm install"}},
+	})
+
+	units, err := resolveUnits(installableObj, TargetDelete)
+	assert.Nil(t, err)
+
+	assert.Equal(t, []structs.Unit{
+		{Id: "helm", Apply: []string{"helm install"}},
+		{
+			Id:      "Terraform",
+			EnvVars: map[string]string{"TF_IN_AUTOMATION": "1"},
+			Init:    []string{"terraform init"},
+			Apply:   []string{"terraform apply -auto-approve"},
+			Delete:  []string{"terraform destroy"},
+		},
+	}, units)
+}
@@ -47,6 +47,7 @@ type IInstallable interface {
 		dryRun bool) ([]string, error)
 	GetOutputs(ignoreMissing bool, dryRun bool) (map[string]interface{}, error)
 	HasOutputs() bool
+	SensitiveFiles() ([]string, error)
 	GetLocalRegistry() IRegistry
 	SetLocalRegistry(registry IRegistry)
 }
@@ -16,11 +16,14 @@
 
 package interfaces
 
+import "context"
+
+// Installers should stop running kapps if the context is cancelled
 type IInstaller interface {
-	Install(installableObj IInstallable, stack IStack, approved bool, dryRun bool) error
-	Delete(installableObj IInstallable, stack IStack, approved bool, dryRun bool) error
-	Clean(installableObj IInstallable, stack IStack, dryRun bool) error
-	Output(installableObj IInstallable, stack IStack, dryRun bool) error
+	Install(ctx context.Context, installableObj IInstallable, stack IStack, approved bool, dryRun bool) error
+	Delete(ctx context.Context, installableObj IInstallable, stack IStack, approved bool, dryRun bool) error
+	Clean(ctx context.Context, installableObj IInstallable, stack IStack, dryRun bool) error
+	Output(ctx context.Context, installableObj IInstallable, stack IStack, dryRun bool) error
 	Name() string
 	GetVars(action string, approved bool) map[string]interface{}
 }
@@ -0,0 +1,244 @@
+/*
+ * Copyright 2019 The Sugarkube Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package lock
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/sugarkube/sugarkube/internal/pkg/log"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// how long to wait for another process to finish modifying a lock file
+const mutexTimeout = 10 * time.Second
+const mutexPollInterval = 20 * time.Millisecond
+
+// mutexes older than this were left behind by processes that died while holding them
+const staleMutexAge = time.Minute
+
+// Stores locks as files in a directory, so only protects against concurrent runs on the same host
+// (or hosts sharing the directory)
+type FileBackend struct {
+	dir string
+}
+
+func newFileBackend(dir string) (*FileBackend, error) {
+	if dir == "" {
+		dir = filepath.Join(os.TempDir(), "sugarkube-locks")
+	}
+
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return &FileBackend{dir: absDir}, nil
+}
+
+// Returns the path to the lock file for a key
+func (b FileBackend) path(key string) string {
+	return filepath.Join(b.dir, fmt.Sprintf("%s.lock", filepath.Base(key)))
+}
+
+// Creates the lock file. The lock is written to a temporary file which is then hard linked to the
+// lock file path, so creating the lock is atomic and other processes never see a partially
+// written lock.
+func (b FileBackend) Lock(key string, info Info) (*Info, error) {
+	err := os.MkdirAll(b.dir, 0755)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	tmpPath, err := b.writeTempFile(info)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer os.Remove(tmpPath)
+
+	path := b.path(key)
+	err = os.Link(tmpPath, path)
+	if err == nil {
+		log.Logger.Debugf("Created lock file '%s'", path)
+		return nil, nil
+	}
+
+	if !os.IsExist(err) {
+		return nil, errors.WithStack(err)
+	}
+
+	holder, err := b.read(path)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return holder, nil
+}
+
+// Deletes the lock file if it's held by the given holder. The lock isn't deleted if it's been
+// refreshed since the holder was read, e.g. because an expired lock is being broken.
+func (b FileBackend) Unlock(key string, info Info) error {
+	path := b.path(key)
+
+	return b.withMutex(key, func() error {
+		holder, err := b.read(path)
+		if err != nil {
+			if os.IsNotExist(errors.Cause(err)) {
+				log.Logger.Warnf("Lock file '%s' doesn't exist", path)
+				return nil
+			}
+			return errors.WithStack(err)
+		}
+
+		if holder.Id != info.Id || !holder.ExpiresAt.Equal(info.ExpiresAt) {
+			log.Logger.Warnf("Not deleting lock file '%s' because it's now held by %s", path, holder)
+			return nil
+		}
+
+		return errors.WithStack(os.Remove(path))
+	})
+}
+
+// Replaces the lock file if it's held by the given holder. The new lock is written to a temporary
+// file which is renamed over the lock file so other processes never see a partially written lock.
+func (b FileBackend) Refresh(key string, info Info) (bool, error) {
+	path := b.path(key)
+	held := false
+
+	err := b.withMutex(key, func() error {
+		holder, err := b.read(path)
+		if err != nil {
+			if os.IsNotExist(errors.Cause(err)) {
+				return nil
+			}
+			return errors.WithStack(err)
+		}
+
+		if holder.Id != info.Id {
+			return nil
+		}
+
+		tmpPath, err := b.writeTempFile(info)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		defer os.Remove(tmpPath)
+
+		beforeRefreshRename()
+
+		err = os.Rename(tmpPath, path)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		held = true
+		return nil
+	})
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+
+	return held, nil
+}
+
+// overridden in tests to change lock files while they're being refreshed
+var beforeRefreshRename = func() {}
+
+// Runs the function while holding a mutex for the lock file with the given key, so other processes
+// can't change the lock file between the function reading and modifying it. Like lock files,
+// mutexes are created by hard linking a temporary file so they're exclusive on shared filesystems.
+// Mutexes left behind by processes that died while holding them are broken after a while.
+func (b FileBackend) withMutex(key string, fn func() error) error {
+	mutexPath := b.path(key) + ".mutex"
+
+	tmpPath, err := b.writeTempFile(Info{})
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer os.Remove(tmpPath)
+
+	deadline := time.Now().Add(mutexTimeout)
+
+	for {
+		err = os.Link(tmpPath, mutexPath)
+		if err == nil {
+			break
+		}
+
+		if !os.IsExist(err) {
+			return errors.WithStack(err)
+		}
+
+		if stat, err := os.Stat(mutexPath); err == nil && time.Since(stat.ModTime()) > staleMutexAge {
+			log.Logger.Warnf("Breaking stale lock file mutex '%s'", mutexPath)
+			_ = os.Remove(mutexPath)
+			continue
+		}
+
+		if time.Now().After(deadline) {
+			return errors.New(fmt.Sprintf("Timed out waiting for lock file mutex '%s'", mutexPath))
+		}
+
+		time.Sleep(mutexPollInterval)
+	}
+	defer os.Remove(mutexPath)
+
+	return fn()
+}
+
+// Writes the lock info to a new temporary file in the lock directory and returns its path
+func (b FileBackend) writeTempFile(info Info) (string, error) {
+	yamlData, err := yaml.Marshal(info)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	tmpFile, err := ioutil.TempFile(b.dir, ".tmp-")
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	_, err = tmpFile.Write(yamlData)
+	closeErr := tmpFile.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpFile.Name())
+		return "", errors.WithStack(err)
+	}
+
+	return tmpFile.Name(), nil
+}
+
+// Reads the lock in a lock file
+func (b FileBackend) read(path string) (*Info, error) {
+	yamlData, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	info := Info{}
+	err = yaml.Unmarshal(yamlData, &info)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error parsing lock file '%s'", path)
+	}
+
+	return &info, nil
+}
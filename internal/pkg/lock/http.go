@@ -0,0 +1,182 @@
+/*
+ * Copyright 2019 The Sugarkube Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package lock
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/sugarkube/sugarkube/internal/pkg/config"
+	"github.com/sugarkube/sugarkube/internal/pkg/log"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// HTTP methods used to acquire and release locks. These are the same as those used by terraform's
+// http state backend so the same lock services can be used.
+const lockMethod = "LOCK"
+const unlockMethod = "UNLOCK"
+
+const httpTimeout = 30 * time.Second
+
+// env var the password for the lock service can be set in instead of the config file
+const passwordEnvVar = "SUGARKUBE_LOCK_PASSWORD"
+
+// Stores locks in a remote HTTP lock service so concurrent runs on different hosts are prevented.
+// Locks are acquired by sending a LOCK request with the lock info as JSON to '<address>/<key>'.
+// The service must respond with 200 if the lock was acquired, or 409 or 423 with the current
+// holder's lock info as JSON if it's already held. Locks are released by sending an UNLOCK request
+// with the holder's lock info. Locks are refreshed by sending another LOCK request with the same
+// lock ID, which the service must accept.
+type HttpBackend struct {
+	address  string
+	username string
+	password string
+	client   *http.Client
+}
+
+func newHttpBackend(lockConfig config.LockConfig) (*HttpBackend, error) {
+	if lockConfig.Address == "" {
+		return nil, errors.New("An address must be configured to use the http lock backend")
+	}
+
+	password := lockConfig.Password
+	if password == "" {
+		password = os.Getenv(passwordEnvVar)
+	}
+
+	return &HttpBackend{
+		address:  strings.TrimSuffix(lockConfig.Address, "/"),
+		username: lockConfig.Username,
+		password: password,
+		client:   &http.Client{Timeout: httpTimeout},
+	}, nil
+}
+
+func (b HttpBackend) Lock(key string, info Info) (*Info, error) {
+	response, body, err := b.send(lockMethod, key, info)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	switch response.StatusCode {
+	case http.StatusOK:
+		return nil, nil
+	case http.StatusConflict, http.StatusLocked:
+		holder := Info{}
+		err = json.Unmarshal(body, &holder)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Error parsing lock info returned by '%s'", b.url(key))
+		}
+		return &holder, nil
+	default:
+		return nil, errors.New(fmt.Sprintf("Unexpected status acquiring lock from '%s': %s",
+			b.url(key), response.Status))
+	}
+}
+
+func (b HttpBackend) Unlock(key string, info Info) error {
+	response, _, err := b.send(unlockMethod, key, info)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	switch response.StatusCode {
+	case http.StatusOK:
+		return nil
+	case http.StatusNotFound:
+		log.Logger.Warnf("Lock '%s' doesn't exist", b.url(key))
+		return nil
+	case http.StatusConflict, http.StatusLocked:
+		log.Logger.Warnf("Lock '%s' wasn't released because it's now held by someone else", b.url(key))
+		return nil
+	default:
+		return errors.New(fmt.Sprintf("Unexpected status releasing lock from '%s': %s",
+			b.url(key), response.Status))
+	}
+}
+
+// Refreshes the lock by sending another LOCK request with the same lock ID. The service must
+// respond with 200 and store the new lock info if the lock is held by the same ID.
+func (b HttpBackend) Refresh(key string, info Info) (bool, error) {
+	response, body, err := b.send(lockMethod, key, info)
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+
+	switch response.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusConflict, http.StatusLocked:
+		holder := Info{}
+		err = json.Unmarshal(body, &holder)
+		if err != nil {
+			return false, errors.Wrapf(err, "Error parsing lock info returned by '%s'", b.url(key))
+		}
+
+		if holder.Id == info.Id {
+			return false, errors.New(fmt.Sprintf("The lock service at '%s' doesn't support "+
+				"refreshing locks so the lock will expire at %s", b.url(key),
+				holder.ExpiresAt.Format(time.RFC3339)))
+		}
+
+		return false, nil
+	default:
+		return false, errors.New(fmt.Sprintf("Unexpected status refreshing lock '%s': %s",
+			b.url(key), response.Status))
+	}
+}
+
+// Returns the URL of the lock with the given key
+func (b HttpBackend) url(key string) string {
+	return fmt.Sprintf("%s/%s", b.address, url.PathEscape(key))
+}
+
+// Sends the lock info to the service and returns the response and its body
+func (b HttpBackend) send(method string, key string, info Info) (*http.Response, []byte, error) {
+	jsonData, err := json.Marshal(info)
+	if err != nil {
+		return nil, nil, errors.WithStack(err)
+	}
+
+	request, err := http.NewRequest(method, b.url(key), bytes.NewReader(jsonData))
+	if err != nil {
+		return nil, nil, errors.WithStack(err)
+	}
+	request.Header.Set("Content-Type", "application/json")
+
+	if b.username != "" {
+		request.SetBasicAuth(b.username, b.password)
+	}
+
+	response, err := b.client.Do(request)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "Error sending %s request to '%s'", method, b.url(key))
+	}
+	defer response.Body.Close()
+
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return nil, nil, errors.WithStack(err)
+	}
+
+	return response, body, nil
+}
@@ -0,0 +1,120 @@
+/*
+ * Copyright 2019 The Sugarkube Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package lock
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/sugarkube/sugarkube/internal/pkg/config"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// A minimal lock service
+func newLockServer(t *testing.T) *httptest.Server {
+	mutex := sync.Mutex{}
+	locks := map[string]Info{}
+
+	return httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		username, password, _ := request.BasicAuth()
+		if username != "user" || password != "secret" {
+			writer.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		info := Info{}
+		assert.Nil(t, json.NewDecoder(request.Body).Decode(&info))
+
+		holder, held := locks[request.URL.Path]
+
+		switch request.Method {
+		case lockMethod:
+			// the holder can refresh its lock
+			if held && holder.Id != info.Id {
+				writer.WriteHeader(http.StatusLocked)
+				assert.Nil(t, json.NewEncoder(writer).Encode(holder))
+				return
+			}
+			locks[request.URL.Path] = info
+		case unlockMethod:
+			if !held {
+				writer.WriteHeader(http.StatusNotFound)
+				return
+			}
+			if holder.Id != info.Id {
+				writer.WriteHeader(http.StatusConflict)
+				return
+			}
+			delete(locks, request.URL.Path)
+		}
+	}))
+}
+
+func TestAcquireHttp(t *testing.T) {
+	server := newLockServer(t)
+	defer server.Close()
+
+	backend, err := New(config.LockConfig{
+		Backend:  HTTP,
+		Address:  server.URL + "/locks/",
+		Username: "user",
+		Password: "secret",
+	})
+	assert.Nil(t, err)
+
+	first, err := Acquire(backend, "dev1", "sugarkube kapps install", 0, false)
+	assert.Nil(t, err)
+
+	_, err = Acquire(backend, "dev1", "sugarkube kapps delete", 0, false)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "running 'sugarkube kapps install'")
+
+	second, err := Acquire(backend, "dev1", "sugarkube kapps delete", 0, true)
+	assert.Nil(t, err)
+
+	// the service refuses to release a lock held by someone else
+	assert.Nil(t, first.Release())
+	_, err = Acquire(backend, "dev1", "sugarkube kapps delete", 0, false)
+	assert.NotNil(t, err)
+
+	assert.Nil(t, second.Release())
+
+	// locks can be refreshed by their holder but not by anyone else
+	refreshed, err := Acquire(backend, "dev1", "sugarkube kapps install", 0, false)
+	assert.Nil(t, err)
+	info := refreshed.info
+	info.Command = "refreshed"
+	held, err := backend.Refresh("dev1", info)
+	assert.Nil(t, err)
+	assert.True(t, held)
+
+	info.Id = "other"
+	held, err = backend.Refresh("dev1", info)
+	assert.Nil(t, err)
+	assert.False(t, held)
+	assert.Nil(t, refreshed.Release())
+
+	unauthorised, err := New(config.LockConfig{Backend: HTTP, Address: server.URL})
+	assert.Nil(t, err)
+	_, err = Acquire(unauthorised, "dev1", "sugarkube kapps install", 0, false)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "401 Unauthorized")
+}
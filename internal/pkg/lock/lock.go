@@ -0,0 +1,273 @@
+/*
+ * Copyright 2019 The Sugarkube Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package lock
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/sugarkube/sugarkube/internal/pkg/config"
+	"github.com/sugarkube/sugarkube/internal/pkg/interfaces"
+	"github.com/sugarkube/sugarkube/internal/pkg/log"
+	"os"
+	"os/user"
+	"strings"
+	"sync"
+	"time"
+)
+
+// implemented lock backends
+const FILE = "file"
+const HTTP = "http"
+const NONE = "none"
+
+// Details of who holds a lock
+type Info struct {
+	Id        string    `json:"id" yaml:"id"`         // unique ID of this acquisition of the lock
+	Stack     string    `json:"stack" yaml:"stack"`   // name of the locked stack
+	Holder    string    `json:"holder" yaml:"holder"` // user@host
+	Pid       int       `json:"pid" yaml:"pid"`
+	Command   string    `json:"command" yaml:"command"`
+	StartedAt time.Time `json:"started_at" yaml:"started_at"`
+	ExpiresAt time.Time `json:"expires_at,omitempty" yaml:"expires_at,omitempty"` // zero if the lock never expires
+}
+
+// Returns whether the lock has expired
+func (i Info) expired(now time.Time) bool {
+	return !i.ExpiresAt.IsZero() && now.After(i.ExpiresAt)
+}
+
+func (i Info) String() string {
+	return fmt.Sprintf("%s (pid %d) running '%s' since %s", i.Holder, i.Pid, i.Command,
+		i.StartedAt.Format(time.RFC3339))
+}
+
+// Stores locks. Implementations must create locks atomically
+type Backend interface {
+	// Tries to acquire the lock with the given key. If it's already held, the current holder is
+	// returned and the lock isn't acquired.
+	Lock(key string, info Info) (*Info, error)
+	// Releases the lock with the given key if it's held by the given holder
+	Unlock(key string, info Info) error
+	// Replaces the lock with the given key with the given info (e.g. to extend when it expires) if
+	// it's still held by the same holder. Returns false if it's no longer held by them.
+	Refresh(key string, info Info) (bool, error)
+}
+
+// Factory that creates lock backends. Returns nil if locking is disabled
+func New(lockConfig config.LockConfig) (Backend, error) {
+	switch lockConfig.Backend {
+	case FILE, "":
+		return newFileBackend(lockConfig.Dir)
+	case HTTP:
+		return newHttpBackend(lockConfig)
+	case NONE:
+		return nil, nil
+	}
+
+	return nil, errors.New(fmt.Sprintf("Lock backend '%s' doesn't exist", lockConfig.Backend))
+}
+
+// A lock that's been acquired
+type Lock struct {
+	backend        Backend
+	key            string
+	info           Info
+	releaseOnce    sync.Once // locks may be released by signal handlers while commands exit normally
+	releaseErr     error
+	stopRefreshing chan struct{} // closed to stop refreshing the lock. Nil if it never expires
+	refreshDone    chan struct{} // closed once the lock has stopped being refreshed
+}
+
+// Acquires the lock with the given key. If it's already held by someone else an error is returned
+// unless their lock has expired or forceUnlock is true, in which case their lock is released first.
+// A ttl of 0 means the lock never expires. Otherwise the lock is refreshed in the background until
+// it's released so long runs don't lose it.
+func Acquire(backend Backend, key string, command string, ttl time.Duration, forceUnlock bool) (*Lock, error) {
+	info, err := newInfo(key, command, ttl)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	// we only need to retry once after releasing the existing lock
+	for attempt := 0; attempt < 2; attempt++ {
+		holder, err := backend.Lock(key, info)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Error acquiring lock for stack '%s'", key)
+		}
+
+		if holder == nil {
+			log.Logger.Infof("Acquired lock for stack '%s' (lock ID %s)", key, info.Id)
+			runLock := &Lock{backend: backend, key: key, info: info}
+			if ttl > 0 {
+				runLock.stopRefreshing = make(chan struct{})
+				runLock.refreshDone = make(chan struct{})
+				go runLock.refreshPeriodically(ttl)
+			}
+			return runLock, nil
+		}
+
+		switch {
+		case forceUnlock:
+			log.Logger.Warnf("Forcibly releasing the lock for stack '%s' held by %s", key, holder)
+		case holder.expired(time.Now()):
+			log.Logger.Warnf("Releasing the lock for stack '%s' held by %s because it expired at %s",
+				key, holder, holder.ExpiresAt.Format(time.RFC3339))
+		default:
+			return nil, errors.New(fmt.Sprintf("Stack '%s' is locked by %s (lock ID %s). Wait for "+
+				"it to finish, or pass '--force-unlock' if you're sure it's no longer running", key,
+				holder, holder.Id))
+		}
+
+		err = backend.Unlock(key, *holder)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Error releasing lock for stack '%s'", key)
+		}
+	}
+
+	return nil, errors.New(fmt.Sprintf("Failed to acquire lock for stack '%s'", key))
+}
+
+// Returns the key of the lock for a stack. Stacks are keyed by what they target rather than their
+// names so differently named stacks for the same cluster exclude each other.
+func StackKey(stackConfig interfaces.IStackConfig) string {
+	return strings.Join([]string{stackConfig.GetProvider(), stackConfig.GetAccount(),
+		stackConfig.GetRegion(), stackConfig.GetCluster()}, "_")
+}
+
+// Acquires the lock for a stack using the configured backend. The command sugarkube was invoked
+// with is recorded in the lock. Returns nil if locking is disabled.
+func AcquireForStack(stackObj interfaces.IStack, forceUnlock bool) (*Lock, error) {
+	lockConfig := config.CurrentConfig.Lock
+
+	backend, err := New(lockConfig)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if backend == nil {
+		log.Logger.Debugf("Locking is disabled")
+		return nil, nil
+	}
+
+	ttl := time.Duration(lockConfig.Ttl) * time.Second
+
+	runLock, err := Acquire(backend, StackKey(stackObj.GetConfig()), strings.Join(os.Args, " "), ttl,
+		forceUnlock)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return runLock, nil
+}
+
+// Releases the lock. It's safe to call this on a nil lock, more than once or concurrently. Only the
+// first call releases the lock. Other calls wait for it to finish and return the same error.
+func (l *Lock) Release() error {
+	if l == nil {
+		return nil
+	}
+
+	l.releaseOnce.Do(func() {
+		if l.stopRefreshing != nil {
+			close(l.stopRefreshing)
+			<-l.refreshDone
+		}
+
+		err := l.backend.Unlock(l.key, l.info)
+		if err != nil {
+			l.releaseErr = errors.Wrapf(err, "Error releasing lock for stack '%s'", l.key)
+			return
+		}
+
+		log.Logger.Infof("Released lock for stack '%s'", l.key)
+	})
+
+	return l.releaseErr
+}
+
+// Extends when the lock expires every third of its ttl until it's released or lost
+func (l *Lock) refreshPeriodically(ttl time.Duration) {
+	defer close(l.refreshDone)
+
+	ticker := time.NewTicker(ttl / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-l.stopRefreshing:
+			return
+		case <-ticker.C:
+			info := l.info
+			info.ExpiresAt = time.Now().UTC().Add(ttl)
+
+			held, err := l.backend.Refresh(l.key, info)
+			if err != nil {
+				// the lock will still be held until it expires so try again next time
+				log.Logger.Warnf("Error refreshing lock for stack '%s': %v", l.key, err)
+				continue
+			}
+
+			if !held {
+				log.Logger.Errorf("Lost the lock for stack '%s' (lock ID %s). Another run may "+
+					"now modify the stack", l.key, l.info.Id)
+				return
+			}
+
+			log.Logger.Debugf("Refreshed lock for stack '%s' until %s", l.key,
+				info.ExpiresAt.Format(time.RFC3339))
+			l.info = info
+		}
+	}
+}
+
+// Returns details of the current process to store in a lock
+func newInfo(key string, command string, ttl time.Duration) (Info, error) {
+	idBytes := make([]byte, 16)
+	_, err := rand.Read(idBytes)
+	if err != nil {
+		return Info{}, errors.WithStack(err)
+	}
+
+	username := "unknown"
+	currentUser, err := user.Current()
+	if err == nil {
+		username = currentUser.Username
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+
+	now := time.Now().UTC()
+
+	info := Info{
+		Id:        hex.EncodeToString(idBytes),
+		Stack:     key,
+		Holder:    fmt.Sprintf("%s@%s", username, hostname),
+		Pid:       os.Getpid(),
+		Command:   command,
+		StartedAt: now,
+	}
+
+	if ttl > 0 {
+		info.ExpiresAt = now.Add(ttl)
+	}
+
+	return info, nil
+}
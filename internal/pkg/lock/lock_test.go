@@ -0,0 +1,275 @@
+/*
+ * Copyright 2019 The Sugarkube Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package lock
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/sugarkube/sugarkube/internal/pkg/config"
+	"github.com/sugarkube/sugarkube/internal/pkg/log"
+	"github.com/sugarkube/sugarkube/internal/pkg/mock"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func init() {
+	log.ConfigureLogger("debug", false)
+}
+
+func newTestFileBackend(t *testing.T) (*FileBackend, func()) {
+	dir, err := ioutil.TempDir("", "locks-")
+	assert.Nil(t, err)
+
+	backend, err := newFileBackend(dir)
+	assert.Nil(t, err)
+
+	return backend, func() { os.RemoveAll(dir) }
+}
+
+func TestNew(t *testing.T) {
+	backend, err := New(config.LockConfig{Backend: NONE})
+	assert.Nil(t, err)
+	assert.Nil(t, backend)
+
+	backend, err = New(config.LockConfig{Backend: FILE, Dir: "locks"})
+	assert.Nil(t, err)
+	assert.IsType(t, &FileBackend{}, backend)
+
+	_, err = New(config.LockConfig{Backend: HTTP})
+	assert.NotNil(t, err)
+
+	_, err = New(config.LockConfig{Backend: "consul"})
+	assert.NotNil(t, err)
+}
+
+func TestStackKey(t *testing.T) {
+	dev := mock.Config{Name: "dev", Provider: "aws", Account: "dev", Region: "eu-west-1",
+		Cluster: "cluster1"}
+	assert.Equal(t, "aws_dev_eu-west-1_cluster1", StackKey(dev))
+
+	// stacks with different names for the same cluster share a lock
+	other := dev
+	other.Name = "dev-copy"
+	other.Profile = "other"
+	assert.Equal(t, StackKey(dev), StackKey(other))
+
+	other.Cluster = "cluster2"
+	assert.NotEqual(t, StackKey(dev), StackKey(other))
+}
+
+func TestAcquireFile(t *testing.T) {
+	backend, cleanup := newTestFileBackend(t)
+	defer cleanup()
+
+	first, err := Acquire(backend, "dev1", "sugarkube kapps install", 0, false)
+	assert.Nil(t, err)
+
+	holder, err := backend.read(backend.path("dev1"))
+	assert.Nil(t, err)
+	assert.Equal(t, first.info.Id, holder.Id)
+	assert.Equal(t, "dev1", holder.Stack)
+	assert.Equal(t, "sugarkube kapps install", holder.Command)
+	assert.Equal(t, os.Getpid(), holder.Pid)
+	assert.True(t, holder.ExpiresAt.IsZero())
+
+	_, err = Acquire(backend, "dev1", "sugarkube kapps delete", 0, false)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "Stack 'dev1' is locked by ")
+	assert.Contains(t, err.Error(), "running 'sugarkube kapps install'")
+
+	// other stacks aren't affected
+	other, err := Acquire(backend, "dev2", "sugarkube kapps install", 0, false)
+	assert.Nil(t, err)
+	assert.Nil(t, other.Release())
+
+	assert.Nil(t, first.Release())
+	// releasing twice is a no-op
+	assert.Nil(t, first.Release())
+
+	_, err = os.Stat(backend.path("dev1"))
+	assert.True(t, os.IsNotExist(err))
+
+	second, err := Acquire(backend, "dev1", "sugarkube kapps delete", 0, false)
+	assert.Nil(t, err)
+	assert.Nil(t, second.Release())
+}
+
+func TestReleaseConcurrently(t *testing.T) {
+	backend, cleanup := newTestFileBackend(t)
+	defer cleanup()
+
+	runLock, err := Acquire(backend, "dev1", "sugarkube kapps install", 0, false)
+	assert.Nil(t, err)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.Nil(t, runLock.Release())
+		}()
+	}
+	wg.Wait()
+
+	_, err = os.Stat(backend.path("dev1"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestRefresh(t *testing.T) {
+	backend, cleanup := newTestFileBackend(t)
+	defer cleanup()
+
+	runLock, err := Acquire(backend, "dev1", "sugarkube kapps install", 300*time.Millisecond, false)
+	assert.Nil(t, err)
+
+	// locks are refreshed so they don't expire while they're held
+	time.Sleep(time.Second)
+	holder, err := backend.read(backend.path("dev1"))
+	assert.Nil(t, err)
+	assert.False(t, holder.expired(time.Now()))
+
+	_, err = Acquire(backend, "dev1", "sugarkube kapps install", 0, false)
+	assert.NotNil(t, err)
+
+	assert.Nil(t, runLock.Release())
+	_, err = os.Stat(backend.path("dev1"))
+	assert.True(t, os.IsNotExist(err))
+
+	// locks held by someone else aren't refreshed
+	info, err := newInfo("dev1", "sugarkube kapps install", time.Hour)
+	assert.Nil(t, err)
+	held, err := backend.Refresh("dev1", info)
+	assert.Nil(t, err)
+	assert.False(t, held)
+
+	holder, err = backend.Lock("dev1", info)
+	assert.Nil(t, err)
+	assert.Nil(t, holder)
+
+	other := info
+	other.Id = "other"
+	held, err = backend.Refresh("dev1", other)
+	assert.Nil(t, err)
+	assert.False(t, held)
+
+	info.Command = "refreshed"
+	held, err = backend.Refresh("dev1", info)
+	assert.Nil(t, err)
+	assert.True(t, held)
+
+	holder, err = backend.read(backend.path("dev1"))
+	assert.Nil(t, err)
+	assert.Equal(t, "refreshed", holder.Command)
+}
+
+func TestRefreshWhileTakenOver(t *testing.T) {
+	backend, cleanup := newTestFileBackend(t)
+	defer cleanup()
+
+	info, err := newInfo("dev1", "sugarkube kapps install", time.Nanosecond)
+	assert.Nil(t, err)
+	holder, err := backend.Lock("dev1", info)
+	assert.Nil(t, err)
+	assert.Nil(t, holder)
+
+	// another run tries to break the expired lock and take it over between the lock being read and
+	// replaced by the refresh
+	other, err := newInfo("dev1", "sugarkube kapps delete", 0)
+	assert.Nil(t, err)
+	other.Id = "other"
+
+	takeoverCh := make(chan *Info)
+	beforeRefreshRename = func() {
+		go func() {
+			assert.Nil(t, backend.Unlock("dev1", info))
+			holder, err := backend.Lock("dev1", other)
+			assert.Nil(t, err)
+			takeoverCh <- holder
+		}()
+		time.Sleep(100 * time.Millisecond)
+	}
+	defer func() { beforeRefreshRename = func() {} }()
+
+	refreshed := info
+	refreshed.ExpiresAt = time.Now().UTC().Add(time.Hour)
+	held, err := backend.Refresh("dev1", refreshed)
+	assert.Nil(t, err)
+	assert.True(t, held)
+
+	// the takeover must have waited for the refresh so it found the refreshed lock
+	holder = <-takeoverCh
+	assert.NotNil(t, holder)
+	assert.Equal(t, info.Id, holder.Id)
+
+	holder, err = backend.read(backend.path("dev1"))
+	assert.Nil(t, err)
+	assert.Equal(t, info.Id, holder.Id)
+	assert.True(t, refreshed.ExpiresAt.Equal(holder.ExpiresAt))
+
+	assert.Nil(t, backend.Unlock("dev1", refreshed))
+	_, err = os.Stat(backend.path("dev1"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestAcquireForceUnlock(t *testing.T) {
+	backend, cleanup := newTestFileBackend(t)
+	defer cleanup()
+
+	first, err := Acquire(backend, "dev1", "sugarkube kapps install", 0, false)
+	assert.Nil(t, err)
+
+	second, err := Acquire(backend, "dev1", "sugarkube kapps install", 0, true)
+	assert.Nil(t, err)
+
+	// the first run mustn't release the lock now held by the second
+	assert.Nil(t, first.Release())
+	holder, err := backend.read(backend.path("dev1"))
+	assert.Nil(t, err)
+	assert.Equal(t, second.info.Id, holder.Id)
+
+	assert.Nil(t, second.Release())
+}
+
+func TestAcquireExpired(t *testing.T) {
+	backend, cleanup := newTestFileBackend(t)
+	defer cleanup()
+
+	_, err := Acquire(backend, "dev1", "sugarkube kapps install", time.Hour, false)
+	assert.Nil(t, err)
+
+	_, err = Acquire(backend, "dev1", "sugarkube kapps install", 0, false)
+	assert.NotNil(t, err)
+
+	// expire the lock
+	info, err := newInfo("dev1", "sugarkube kapps install", time.Nanosecond)
+	assert.Nil(t, err)
+	assert.Nil(t, os.Remove(backend.path("dev1")))
+	holder, err := backend.Lock("dev1", info)
+	assert.Nil(t, err)
+	assert.Nil(t, holder)
+
+	runLock, err := Acquire(backend, "dev1", "sugarkube kapps install", 0, false)
+	assert.Nil(t, err)
+	assert.Nil(t, runLock.Release())
+
+	// no temporary files should be left behind
+	matches, err := filepath.Glob(filepath.Join(backend.dir, ".tmp-*"))
+	assert.Nil(t, err)
+	assert.Empty(t, matches)
+}
@@ -0,0 +1,148 @@
+/*
+ * Copyright 2019 The Sugarkube Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package plan
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/sugarkube/sugarkube/internal/pkg/interfaces"
+	"github.com/sugarkube/sugarkube/internal/pkg/log"
+	"github.com/sugarkube/sugarkube/internal/pkg/templater"
+	"strings"
+)
+
+// A kapp that was left out of the DAG because its `when` condition was false
+type ExcludedKapp struct {
+	Id       string `json:"id"` // fully-qualified kapp ID
+	Manifest string `json:"manifest"`
+	Reason   string `json:"reason"`
+}
+
+// Evaluates the `when` conditions of installables in the manifests, returning those that should
+// be left out of the DAG. Conditions are evaluated with the same vars kapps are templated with.
+func evaluateConditions(stackObj interfaces.IStack, manifests []interfaces.IManifest) ([]ExcludedKapp, error) {
+	excluded := make([]ExcludedKapp, 0)
+
+	for _, manifest := range manifests {
+		for _, installableObj := range manifest.Installables() {
+			condition := strings.TrimSpace(installableObj.GetDescriptor().When)
+			if condition == "" {
+				continue
+			}
+
+			include, err := evaluateCondition(stackObj, installableObj, condition)
+			if err != nil {
+				return nil, errors.WithStack(err)
+			}
+
+			if include {
+				continue
+			}
+
+			log.Logger.Infof("Excluding kapp '%s' from the DAG because its condition '%s' is false",
+				installableObj.FullyQualifiedId(), condition)
+			excluded = append(excluded, ExcludedKapp{
+				Id:       installableObj.FullyQualifiedId(),
+				Manifest: installableObj.ManifestId(),
+				Reason:   fmt.Sprintf("when '%s' is false", condition),
+			})
+		}
+	}
+
+	return excluded, nil
+}
+
+// Returns whether an installable's condition is true using the vars the installable would be
+// templated with
+func evaluateCondition(stackObj interfaces.IStack, installableObj interfaces.IInstallable,
+	condition string) (bool, error) {
+	templatedVars, err := stackObj.GetTemplatedVars(installableObj, map[string]interface{}{})
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+
+	result, err := templater.EvaluateCondition(condition, templatedVars)
+	if err != nil {
+		return false, errors.Wrapf(err, "Error evaluating the condition of kapp '%s'",
+			installableObj.FullyQualifiedId())
+	}
+
+	return result, nil
+}
+
+// Removes excluded kapps from the descriptors. Dependencies on excluded kapps are replaced with
+// the excluded kapps' own dependencies so the remaining kapps are processed in the same order.
+func relaxDependencies(descriptors map[string]nodeDescriptor,
+	excluded map[string]bool) map[string]nodeDescriptor {
+	relaxed := make(map[string]nodeDescriptor, len(descriptors))
+
+	for descriptorId, descriptor := range descriptors {
+		if excluded[descriptorId] {
+			continue
+		}
+
+		relaxedDescriptor := nodeDescriptor{
+			dependsOn:      make([]string, 0),
+			sequential:     descriptor.sequential,
+			installableObj: descriptor.installableObj,
+		}
+		relaxedDescriptor.inherit(descriptor, descriptors, excluded, false, false,
+			map[string]bool{})
+
+		relaxed[descriptorId] = relaxedDescriptor
+	}
+
+	return relaxed
+}
+
+// Adds the dependencies of another descriptor to this one, recursing into the dependencies of
+// excluded kapps instead of depending on them directly. Dependencies are optional or
+// ordering-only if any dependency on the path to them is.
+func (d *nodeDescriptor) inherit(other nodeDescriptor, descriptors map[string]nodeDescriptor,
+	excluded map[string]bool, optional bool, runsAfter bool, visited map[string]bool) {
+	for _, dependencyId := range other.dependsOn {
+		isOptional := optional || other.optional[dependencyId]
+		isRunsAfter := runsAfter || other.runsAfter[dependencyId]
+
+		if excluded[dependencyId] {
+			if !visited[dependencyId] {
+				visited[dependencyId] = true
+				d.inherit(descriptors[dependencyId], descriptors, excluded, isOptional, isRunsAfter,
+					visited)
+			}
+			continue
+		}
+
+		if !d.addDependency(dependencyId) {
+			continue
+		}
+
+		if isOptional {
+			if d.optional == nil {
+				d.optional = make(map[string]bool, 0)
+			}
+			d.optional[dependencyId] = true
+		}
+
+		if isRunsAfter {
+			if d.runsAfter == nil {
+				d.runsAfter = make(map[string]bool, 0)
+			}
+			d.runsAfter[dependencyId] = true
+		}
+	}
+}
@@ -0,0 +1,168 @@
+/*
+ * Copyright 2019 The Sugarkube Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package plan
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"github.com/sugarkube/sugarkube/internal/pkg/config"
+	"github.com/sugarkube/sugarkube/internal/pkg/constants"
+	"github.com/sugarkube/sugarkube/internal/pkg/installable"
+	"github.com/sugarkube/sugarkube/internal/pkg/interfaces"
+	"github.com/sugarkube/sugarkube/internal/pkg/mock"
+	"github.com/sugarkube/sugarkube/internal/pkg/structs"
+	"testing"
+)
+
+type testManifest struct {
+	id           string
+	installables []interfaces.IInstallable
+	sequential   bool
+}
+
+func (m testManifest) Id() string {
+	return m.id
+}
+
+func (m testManifest) Installables() []interfaces.IInstallable {
+	return m.installables
+}
+
+func (m testManifest) IsSequential() bool {
+	return m.sequential
+}
+
+func newTestManifest(t *testing.T, id string, sequential bool,
+	descriptors ...structs.KappDescriptorWithMaps) interfaces.IManifest {
+	manifest := testManifest{id: id, sequential: sequential}
+
+	for _, descriptor := range descriptors {
+		installableObj, err := installable.New(id, []structs.KappDescriptorWithMaps{descriptor})
+		assert.Nil(t, err)
+		manifest.installables = append(manifest.installables, installableObj)
+	}
+
+	return manifest
+}
+
+func conditionsStack() interfaces.IStack {
+	return &mock.MockStack{
+		TemplatedVars: map[string]interface{}{
+			"stack": map[string]interface{}{
+				"provider": "local",
+				"region":   "eu-west-1",
+			},
+		},
+	}
+}
+
+func TestEvaluateCondition(t *testing.T) {
+	installableObj, err := installable.New("manifest", []structs.KappDescriptorWithMaps{{Id: "kapp"}})
+	assert.Nil(t, err)
+
+	tests := map[string]bool{
+		`eq .stack.provider "local"`:                             true,
+		`eq .stack.provider "aws"`:                               false,
+		`{{ hasPrefix "eu-" .stack.region }}`:                    true,
+		`{{ if eq .stack.provider "aws" }}true{{ end }}`:         false,
+		`{{ if eq .stack.provider "local" }}true{{ end }}`:       true,
+		`and (eq .stack.provider "local") (ne .stack.region "")`: true,
+	}
+
+	for condition, expected := range tests {
+		actual, err := evaluateCondition(conditionsStack(), installableObj, condition)
+		assert.Nil(t, err, condition)
+		assert.Equal(t, expected, actual, condition)
+	}
+
+	_, err = evaluateCondition(conditionsStack(), installableObj, ".stack.provider")
+	assert.Error(t, err)
+
+	_, err = evaluateCondition(conditionsStack(), installableObj, "{{ eq .stack.provider ")
+	assert.Error(t, err)
+}
+
+// Tests that kapps whose conditions are false are left out of the DAG and that kapps that depended
+// on them depend on their parents instead
+func TestCreateWithConditions(t *testing.T) {
+	awsOnly := structs.KappConfig{When: `eq .stack.provider "aws"`}
+
+	manifests := []interfaces.IManifest{
+		newTestManifest(t, "infra", true,
+			structs.KappDescriptorWithMaps{Id: "cluster"},
+			structs.KappDescriptorWithMaps{Id: "bucket", KappConfig: awsOnly},
+			structs.KappDescriptorWithMaps{Id: "ingress"}),
+		newTestManifest(t, "apps", false,
+			structs.KappDescriptorWithMaps{Id: "backup", KappConfig: structs.KappConfig{
+				DependsOn: []string{"infra:bucket"}, When: `eq .stack.provider "aws"`}},
+			structs.KappDescriptorWithMaps{Id: "web", KappConfig: structs.KappConfig{
+				DependsOn: []string{"infra:bucket", "backup"}}}),
+	}
+
+	dag, err := Create(conditionsStack(), manifests, []string{"infra:ingress", "infra:bucket",
+		"apps:web"}, nil, false, false)
+	assert.Nil(t, err)
+
+	graphObj, err := dag.Export()
+	assert.Nil(t, err)
+
+	assert.Equal(t, []GraphEdge{
+		{From: "infra:cluster", To: "apps:web", Type: constants.EdgeTypeDependsOn},
+		{From: "infra:cluster", To: "infra:ingress", Type: constants.EdgeTypeSequential},
+	}, graphObj.Edges)
+
+	assert.Equal(t, []ExcludedKapp{
+		{Id: "apps:backup", Manifest: "apps", Reason: `when 'eq .stack.provider "aws"' is false`},
+		{Id: "infra:bucket", Manifest: "infra", Reason: `when 'eq .stack.provider "aws"' is false`},
+	}, graphObj.Excluded)
+
+	var buffer bytes.Buffer
+	assert.Nil(t, graphObj.Write(&buffer, constants.GraphFormatDot))
+	assert.Contains(t, buffer.String(), `"infra:bucket" [label="infra:bucket\n(when 'eq .stack.provider \"aws\"' is false)", style=dotted, color=grey, fontcolor=grey];`)
+
+	previousConfig := config.CurrentConfig
+	config.CurrentConfig = &config.Config{NumWorkers: 1}
+	defer func() { config.CurrentConfig = previousConfig }()
+
+	buffer.Reset()
+	assert.Nil(t, dag.Print(&buffer))
+	assert.Contains(t, buffer.String(), "The following kapps were excluded from the DAG:\n"+
+		"  infra:bucket - when 'eq .stack.provider \"aws\"' is false\n"+
+		"  apps:backup - when 'eq .stack.provider \"aws\"' is false\n")
+}
+
+// Tests that relaxed dependencies keep the kind of the dependencies they replace
+func TestRelaxDependencies(t *testing.T) {
+	descriptors := map[string]nodeDescriptor{
+		"cluster":    {},
+		"monitoring": {dependsOn: []string{"cluster"}},
+		"app": {
+			dependsOn: []string{"monitoring"},
+			optional:  map[string]bool{"monitoring": true},
+		},
+	}
+
+	relaxed := relaxDependencies(descriptors, map[string]bool{"monitoring": true})
+
+	assert.Equal(t, map[string]nodeDescriptor{
+		"cluster": {dependsOn: []string{}},
+		"app": {
+			dependsOn: []string{"cluster"},
+			optional:  map[string]bool{"cluster": true},
+		},
+	}, relaxed)
+}
@@ -17,9 +17,11 @@
 package plan
 
 import (
+	"context"
 	"fmt"
 	"github.com/pkg/errors"
 	"github.com/sugarkube/sugarkube/internal/pkg/config"
+	"github.com/sugarkube/sugarkube/internal/pkg/constants"
 	"github.com/sugarkube/sugarkube/internal/pkg/interfaces"
 	"github.com/sugarkube/sugarkube/internal/pkg/log"
 	"gonum.org/v1/gonum/graph"
@@ -30,28 +32,56 @@ import (
 	"time"
 )
 
-const (
-	unprocessed = iota
-	running
-	finished
-)
-
 const markedNodeStr = "*"
-const defaultSleepInterval = 5 // milliseconds
 
 // Wrapper around a directed graph so we can define our own methods on it
 type Dag struct {
-	graph         *simple.DirectedGraph
-	SleepInterval time.Duration // time to wait after reaching the end of the graph before doing another pass
+	graph        *simple.DirectedGraph
+	Journal      *Journal       // if set, kapps will be journalled when installed so failed runs can be resumed
+	Outputs      *OutputCache   // if set, loaded outputs will be cached for use by later runs
+	OnlyMarked   bool           // if true, unmarked kapps won't be run to load their outputs. Cached outputs will be used
+	Durations    *Durations     // if set, durations of kapps will be recorded and used to prioritise the critical path
+	Fingerprints *Fingerprints  // if set, kapps that are unchanged since they were last installed won't be installed again
+	excluded     []ExcludedKapp // kapps left out of the DAG because their conditions were false
 }
 
 // Defines a node that should be created in the graph, along with parent dependencies. This is
 // just a descriptor of a node, not an actual graph node
 type nodeDescriptor struct {
 	dependsOn      []string
+	sequential     bool            // true if dependsOn was derived from the manifest being sequential
+	optional       map[string]bool // dependencies that are ignored if they don't exist
+	runsAfter      map[string]bool // dependencies that only affect ordering. Their outputs aren't loaded
 	installableObj interfaces.IInstallable
 }
 
+// Adds a dependency unless the descriptor already depends on it. Returns true if it was added.
+func (d *nodeDescriptor) addDependency(dependencyId string) bool {
+	for _, existing := range d.dependsOn {
+		if existing == dependencyId {
+			return false
+		}
+	}
+
+	d.dependsOn = append(d.dependsOn, dependencyId)
+	return true
+}
+
+// Returns the type of the edge that should be created for a dependency
+func (d nodeDescriptor) edgeType(dependencyId string) string {
+	if d.runsAfter[dependencyId] {
+		return constants.EdgeTypeRunsAfter
+	}
+	if d.optional[dependencyId] {
+		return constants.EdgeTypeOptionalDependsOn
+	}
+	if d.sequential {
+		return constants.EdgeTypeSequential
+	}
+
+	return constants.EdgeTypeDependsOn
+}
+
 // A node in a graph that also has a string name
 type NamedNode struct {
 	name           string // must be unique across all nodes in the graph
@@ -65,16 +95,50 @@ func (n NamedNode) ID() int64 {
 	return n.node.ID()
 }
 
-// Used to track whether a node has been processed
-type nodeStatus struct {
-	node   NamedNode
-	status int
+// A directed edge from a parent node to a child that records why the child depends on the parent
+type dependencyEdge struct {
+	from     graph.Node
+	to       graph.Node
+	edgeType string // one of the EdgeType* constants
+}
+
+func (e dependencyEdge) From() graph.Node {
+	return e.from
+}
+
+func (e dependencyEdge) To() graph.Node {
+	return e.to
+}
+
+func (e dependencyEdge) ReversedEdge() graph.Edge {
+	return dependencyEdge{from: e.to, to: e.from, edgeType: e.edgeType}
+}
+
+// Returns the type of an edge. Edges that weren't created as dependency edges are assumed to
+// have been declared with `depends_on`
+func edgeType(edge graph.Edge) string {
+	if dependency, ok := edge.(dependencyEdge); ok {
+		return dependency.edgeType
+	}
+
+	return constants.EdgeTypeDependsOn
+}
+
+// Sent by workers to tell the DAG walker they've finished processing a node. A non-nil
+// error means the node failed to be processed.
+type nodeResult struct {
+	node       NamedNode
+	err        error
+	notStarted bool // true if the worker didn't process the node because the DAG was interrupted
 }
 
 // Creates a DAG for installables in the given manifests. If a list of selected installable IDs is
-// given a subgraph will be returned containing only those installables and their ancestors.
-func Create(manifests []interfaces.IManifest, selectedInstallableIds []string,
-	includeParents bool) (*Dag, error) {
+// given a subgraph will be returned containing only those installables and their ancestors (plus
+// their descendants if includeChildren is true). Excluded installables will never be marked for
+// processing. If a stack is given, installables whose `when` conditions are false are left out of
+// the DAG entirely.
+func Create(stackObj interfaces.IStack, manifests []interfaces.IManifest, selectedInstallableIds []string,
+	excludedInstallableIds []string, includeParents bool, includeChildren bool) (*Dag, error) {
 	manifestIds := make([]string, 0)
 	for _, manifest := range manifests {
 		manifestIds = append(manifestIds, manifest.Id())
@@ -83,16 +147,47 @@ func Create(manifests []interfaces.IManifest, selectedInstallableIds []string,
 	log.Logger.Debugf("Creating DAG for installables '%s' in manifests %s",
 		strings.Join(selectedInstallableIds, ", "), strings.Join(manifestIds, ", "))
 	descriptors := findDependencies(manifests)
+
+	conditionallyExcluded := make([]ExcludedKapp, 0)
+	if stackObj != nil {
+		var err error
+		conditionallyExcluded, err = evaluateConditions(stackObj, manifests)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+
+	if len(conditionallyExcluded) > 0 {
+		excludedIds := make(map[string]bool, len(conditionallyExcluded))
+		for _, excludedKapp := range conditionallyExcluded {
+			excludedIds[excludedKapp.Id] = true
+		}
+
+		descriptors = relaxDependencies(descriptors, excludedIds)
+
+		selectedIds := make([]string, 0)
+		for _, installableId := range selectedInstallableIds {
+			if excludedIds[installableId] {
+				log.Logger.Infof("Not selecting kapp '%s' because its condition is false", installableId)
+				continue
+			}
+			selectedIds = append(selectedIds, installableId)
+		}
+		selectedInstallableIds = selectedIds
+	}
+
 	dag, err := build(descriptors)
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
 
-	dag, err = dag.subGraph(selectedInstallableIds, includeParents)
+	dag, err = dag.subGraph(selectedInstallableIds, excludedInstallableIds, includeParents, includeChildren)
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
 
+	dag.excluded = conditionallyExcluded
+
 	log.Logger.Debugf("Finished creating DAG")
 
 	return dag, nil
@@ -115,6 +210,12 @@ func build(descriptors map[string]nodeDescriptor) (*Dag, error) {
 			for _, dependencyId := range descriptor.dependsOn {
 				_, ok := descriptors[dependencyId]
 				if !ok {
+					if descriptor.optional[dependencyId] {
+						log.Logger.Infof("Ignoring optional dependency of '%s' on '%s' which "+
+							"isn't in the stack", descriptorId, dependencyId)
+						continue
+					}
+
 					return nil, fmt.Errorf("descriptor '%s' depends on a graph "+
 						"descriptor that doesn't exist: %s", descriptorId, dependencyId)
 				}
@@ -132,8 +233,8 @@ func build(descriptors map[string]nodeDescriptor) (*Dag, error) {
 				}
 
 				// now we have both nodes in the graph, create a directed edge between them
-				edge := graphObj.NewEdge(parentNode, descriptorNode)
-				graphObj.SetEdge(edge)
+				graphObj.SetEdge(dependencyEdge{from: parentNode, to: descriptorNode,
+					edgeType: descriptor.edgeType(dependencyId)})
 			}
 		}
 	}
@@ -143,8 +244,7 @@ func build(descriptors map[string]nodeDescriptor) (*Dag, error) {
 	}
 
 	dag := Dag{
-		graph:         graphObj,
-		SleepInterval: defaultSleepInterval * time.Millisecond,
+		graph: graphObj,
 	}
 
 	return &dag, nil
@@ -205,9 +305,25 @@ func (g *Dag) GetInstallables() []interfaces.IInstallable {
 }
 
 // Returns a new DAG comprising the nodes in the given input list and all their
-// ancestors. The returned graph is guaranteed to be a DAG. All nodes in the input list will be
-// marked for processing in the returned subgraph.
-func (g *Dag) subGraph(nodeNames []string, includeParents bool) (*Dag, error) {
+// ancestors. If includeChildren is true all descendants of the nodes will be added to the input
+// list too. The returned graph is guaranteed to be a DAG. All nodes in the input list will be
+// marked for processing in the returned subgraph. Excluded nodes will only be added to the
+// subgraph if they're ancestors of other nodes, and will never be marked for processing.
+func (g *Dag) subGraph(nodeNames []string, excludedNames []string, includeParents bool,
+	includeChildren bool) (*Dag, error) {
+
+	excluded := make(map[string]bool, len(excludedNames))
+	for _, nodeName := range excludedNames {
+		excluded[nodeName] = true
+	}
+
+	if includeChildren {
+		var err error
+		nodeNames, err = g.withDescendants(nodeNames, excluded)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
 
 	log.Logger.Debugf("Extracting sub-graph for nodes: %s", strings.Join(nodeNames, ", "))
 
@@ -226,12 +342,11 @@ func (g *Dag) subGraph(nodeNames []string, includeParents bool) (*Dag, error) {
 		// mark that we should process this node
 		ogNode := addNode(outputGraph, ogNodesByName, inputGraphNode.name,
 			inputGraphNode.installableObj, true)
-		addAncestors(g.graph, outputGraph, ogNodesByName, inputGraphNode, ogNode, includeParents)
+		addAncestors(g.graph, outputGraph, ogNodesByName, inputGraphNode, ogNode, includeParents, excluded)
 	}
 
 	dag := Dag{
-		graph:         outputGraph,
-		SleepInterval: defaultSleepInterval * time.Millisecond,
+		graph: outputGraph,
 	}
 
 	log.Logger.Debugf("Finished extracting sub-graph")
@@ -240,7 +355,8 @@ func (g *Dag) subGraph(nodeNames []string, includeParents bool) (*Dag, error) {
 }
 
 func addAncestors(inputGraph *simple.DirectedGraph, outputGraph *simple.DirectedGraph,
-	ogNodes map[string]NamedNode, igNode NamedNode, ogNode NamedNode, includeParents bool) {
+	ogNodes map[string]NamedNode, igNode NamedNode, ogNode NamedNode, includeParents bool,
+	excluded map[string]bool) {
 	igParents := inputGraph.To(igNode.ID())
 
 	for igParents.Next() {
@@ -249,33 +365,66 @@ func addAncestors(inputGraph *simple.DirectedGraph, outputGraph *simple.Directed
 		// we generally don't want to process ancestors, only use them to grab their
 		// outputs, but it depends on `includeParents`
 		ogParentNode := addNode(outputGraph, ogNodes, igParentNode.name,
-			igParentNode.installableObj, includeParents)
+			igParentNode.installableObj, includeParents && !excluded[igParentNode.name])
 
 		// now we have parent and child nodes in the output graph , create a directed
-		// edge between them
-		edge := outputGraph.NewEdge(ogParentNode, ogNode)
-		outputGraph.SetEdge(edge)
+		// edge between them of the same type as in the input graph
+		igEdge := inputGraph.Edge(igParentNode.ID(), igNode.ID())
+		outputGraph.SetEdge(dependencyEdge{from: ogParentNode, to: ogNode, edgeType: edgeType(igEdge)})
 
 		// now recurse to the parent of the parent node
-		addAncestors(inputGraph, outputGraph, ogNodes, igParentNode, ogParentNode, includeParents)
+		addAncestors(inputGraph, outputGraph, ogNodes, igParentNode, ogParentNode, includeParents, excluded)
 	}
 }
 
-// Returns a map of nodeStatuses for each node in the graph keyed by node ID
-func (g *Dag) nodeStatusesById() map[int64]nodeStatus {
-	nodeMap := make(map[int64]nodeStatus, 0)
+// Returns the given node names followed by the names of all their descendants, across all
+// manifests. Excluded descendants aren't returned but are still traversed, so their own
+// descendants will be returned.
+func (g *Dag) withDescendants(nodeNames []string, excluded map[string]bool) ([]string, error) {
+	nodesByName := g.nodesByName()
 
-	nodes := g.graph.Nodes()
+	seen := make(map[string]bool, 0)
+	names := make([]string, 0)
+	queue := make([]NamedNode, 0)
 
-	for nodes.Next() {
-		node := nodes.Node()
-		nodeMap[node.ID()] = nodeStatus{
-			node:   node.(NamedNode),
-			status: unprocessed,
+	for _, nodeName := range nodeNames {
+		node, ok := nodesByName[nodeName]
+		if !ok {
+			return nil, fmt.Errorf("Graph doesn't contain a node called '%s'", nodeName)
+		}
+
+		if !seen[nodeName] {
+			seen[nodeName] = true
+			names = append(names, nodeName)
+			queue = append(queue, node)
 		}
 	}
 
-	return nodeMap
+	for len(queue) > 0 {
+		node := queue[0]
+		queue = queue[1:]
+
+		children := g.graph.From(node.ID())
+		for children.Next() {
+			child := children.Node().(NamedNode)
+			if seen[child.name] {
+				continue
+			}
+
+			seen[child.name] = true
+			queue = append(queue, child)
+
+			if excluded[child.name] {
+				log.Logger.Debugf("Not selecting excluded descendant '%s' of '%s'", child.name, node.name)
+				continue
+			}
+
+			log.Logger.Debugf("Selecting '%s' because it's a descendant of '%s'", child.name, node.name)
+			names = append(names, child.name)
+		}
+	}
+
+	return names, nil
 }
 
 // Returns a map of nodes keyed by node name
@@ -292,23 +441,52 @@ func (g *Dag) nodesByName() map[string]NamedNode {
 	return nodeMap
 }
 
+// Options for a single walk of the DAG
+type walkOptions struct {
+	heartbeat  *heartbeat              // if set, progress will be printed periodically during the walk
+	priorities map[int64]time.Duration // if set, ready nodes with higher priorities are dispatched first
+}
+
 // Traverses the graph from the root to leaves. Nodes will only be processed once their
 // dependencies have been processed. Not having dependencies is a special case of this.
-func (g *Dag) walkDown(processCh chan<- NamedNode, doneCh chan NamedNode) chan bool {
-	return g.walk(true, processCh, doneCh)
-
+func (g *Dag) walkDown(ctx context.Context, failFast bool, options walkOptions, processCh chan<- NamedNode,
+	doneCh <-chan nodeResult) <-chan *Summary {
+	return g.walk(ctx, true, failFast, options, processCh, doneCh)
 }
 
 // Walks the DAG from leaves to root. A node will only be processed once all of its child nodes have been
 // processed. A leaf node is a special case of this that has no children.
-func (g *Dag) walkUp(processCh chan<- NamedNode, doneCh chan NamedNode) chan bool {
-	return g.walk(false, processCh, doneCh)
+func (g *Dag) walkUp(ctx context.Context, failFast bool, options walkOptions, processCh chan<- NamedNode,
+	doneCh <-chan nodeResult) <-chan *Summary {
+	return g.walk(ctx, false, failFast, options, processCh, doneCh)
 }
 
 // Walks the DAG in the given direction. If down==true nodes will only be processed if all parents have
 // been processed. If down==false it will walk up the DAG from leaves to root, only processing nodes if
 // all children have been processed.
-func (g *Dag) walk(down bool, processCh chan<- NamedNode, doneCh chan NamedNode) chan bool {
+//
+// Each node has a counter of the number of dependencies (parents when walking down, children when
+// walking up) that haven't finished yet. Nodes are sent to processCh as soon as their counter reaches
+// zero. When a worker sends a result to doneCh the counters of the node's dependants are decremented.
+// All state is owned by a single goroutine so no locking is required.
+//
+// If a worker returns an error for a node and failFast is true no more nodes will be dispatched and the
+// walk will end once all in-flight nodes have finished. Otherwise all dependants of the failed node are
+// skipped and independent branches of the DAG continue to be processed. A summary of the outcome of
+// each node is sent on the returned channel once the walk has ended.
+//
+// When several nodes are ready at once, those with the highest priorities in the options are
+// dispatched first (see nextDispatchable). If the options have a heartbeat, progress is printed
+// periodically.
+//
+// If the context is cancelled no more nodes will be dispatched. Nodes that fail while the context is
+// cancelled are recorded as having been interrupted.
+//
+// processCh must be unbuffered so nodes are only dispatched once a worker is free to start them.
+// Otherwise queued nodes would be treated as running (skewing their durations and the heartbeat)
+// and higher priority nodes that become ready later would be stuck behind them.
+func (g *Dag) walk(ctx context.Context, down bool, failFast bool, options walkOptions,
+	processCh chan<- NamedNode, doneCh <-chan nodeResult) <-chan *Summary {
 
 	if down {
 		log.Logger.Info("Starting walking down the DAG...")
@@ -316,75 +494,251 @@ func (g *Dag) walk(down bool, processCh chan<- NamedNode, doneCh chan NamedNode)
 		log.Logger.Info("Starting walking up the DAG...")
 	}
 
-	nodeStatusesById := g.nodeStatusesById()
-	log.Logger.Tracef("Node statuses by ID: %+v", nodeStatusesById)
-
 	numNodes := g.graph.Nodes().Len()
 	log.Logger.Debugf("Graph has %d nodes", numNodes)
 
-	// spawn a goroutine to listen to the doneCh to update the statuses of completed nodes
-	go func() {
-		for namedNode := range doneCh {
-			log.Logger.Debugf("Worker informs the DAG it's finished processing node '%s'", namedNode.name)
-			nodeItem := nodeStatusesById[namedNode.node.ID()]
-			nodeItem.status = finished
-			nodeStatusesById[namedNode.node.ID()] = nodeItem
+	pendingById := g.numDependenciesById(down)
+	log.Logger.Tracef("Number of dependencies by node ID: %+v", pendingById)
+
+	// nodes with no dependencies can be processed immediately
+	readyQueue := make([]NamedNode, 0)
+	nodes := g.graph.Nodes()
+	unlimitedGroups := make(map[string]bool, 0)
+	for nodes.Next() {
+		node := nodes.Node().(NamedNode)
+		if pendingById[node.ID()] == 0 {
+			readyQueue = append(readyQueue, node)
 		}
-	}()
 
-	finishedCh := make(chan bool)
+		group := concurrencyGroup(node)
+		if group != "" && concurrencyLimit(group) <= 0 && !unlimitedGroups[group] {
+			log.Logger.Warnf("No limit is configured for concurrency group '%s' (used by '%s'). Kapps in "+
+				"it will be processed using the global worker pool", group, node.name)
+			unlimitedGroups[group] = true
+		}
+	}
+
+	finishedCh := make(chan *Summary, 1)
 
 	go func() {
-		// loop until there are no nodes left which haven't been processed
-		for {
-			for node, nodeStatus := range nodeStatusesById {
-				namedNode := nodeStatusesById[node]
-
-				// only consider unprocessed nodes
-				if nodeStatus.status != unprocessed {
-					//log.Logger.Tracef("Skipping node '%s' with status '%v' on this pass...",
-					//	namedNode.node.name, nodeStatus.status)
+		summary := &Summary{}
+		visited := make(map[int64]bool, 0)
+		numRemaining := numNodes
+		numInFlight := 0
+		inFlightByGroup := make(map[string]int, 0)
+		halted := false
+
+		tickCh, stopTicker := options.heartbeat.ticker()
+		defer stopTicker()
+		startedAt := make(map[string]time.Time, 0)
+		durations := make([]time.Duration, 0)
+		ctxDone := ctx.Done()
+
+		for numRemaining > 0 && !(halted && numInFlight == 0) {
+			// a nil channel blocks forever, so we only try to dispatch a node if one is ready
+			var dispatchCh chan<- NamedNode
+			var nextNode NamedNode
+			nextIndex := -1
+			if !halted {
+				nextIndex = nextDispatchable(readyQueue, inFlightByGroup, options.priorities)
+			}
+			if nextIndex >= 0 {
+				dispatchCh = processCh
+				nextNode = readyQueue[nextIndex]
+			}
+
+			select {
+			case dispatchCh <- nextNode:
+				log.Logger.Debugf("All dependencies satisfied for '%s', added it to the "+
+					"processing queue", nextNode.name)
+				readyQueue = append(readyQueue[:nextIndex], readyQueue[nextIndex+1:]...)
+				numInFlight++
+				inFlightByGroup[concurrencyGroup(nextNode)]++
+				startedAt[nextNode.name] = time.Now()
+			case <-ctxDone:
+				log.Logger.Warnf("DAG interrupted. Won't process any more nodes. Waiting for %d "+
+					"in-flight nodes to finish...", numInFlight)
+				halted = true
+				// a nil channel blocks forever so we won't select this case again
+				ctxDone = nil
+			case <-tickCh:
+				options.heartbeat.print(time.Now(), progress{
+					startedAt:  startedAt,
+					durations:  durations,
+					numQueued:  len(readyQueue),
+					numBlocked: numRemaining - numInFlight - len(readyQueue),
+					numDone:    numNodes - numRemaining,
+					numNodes:   numNodes,
+				})
+			case result := <-doneCh:
+				namedNode := result.node
+				numInFlight--
+				inFlightByGroup[concurrencyGroup(namedNode)]--
+				numRemaining--
+				visited[namedNode.ID()] = true
+				nodeStartedAt := startedAt[namedNode.name]
+				nodeFinishedAt := time.Now()
+				delete(startedAt, namedNode.name)
+
+				if result.notStarted {
+					log.Logger.Debugf("Node '%s' wasn't processed because the DAG was interrupted",
+						namedNode.name)
+					summary.add(namedNode, NodeStatusSkipped, nil, time.Time{}, time.Time{})
+					continue
+				}
+
+				durations = append(durations, nodeFinishedAt.Sub(nodeStartedAt))
+
+				if result.err != nil && ctx.Err() != nil {
+					log.Logger.Warnf("Node '%s' was interrupted: %v", namedNode.name, result.err)
+					summary.add(namedNode, NodeStatusInterrupted, result.err, nodeStartedAt, nodeFinishedAt)
 					continue
 				}
 
-				var dependencies graph.Nodes
-				if down {
-					dependencies = g.graph.To(nodeStatus.node.ID())
-				} else {
-					dependencies = g.graph.From(nodeStatus.node.ID())
+				if result.err != nil {
+					log.Logger.Warnf("Worker failed to process node '%s': %v", namedNode.name,
+						result.err)
+					summary.add(namedNode, NodeStatusFailed, result.err, nodeStartedAt, nodeFinishedAt)
+
+					if failFast {
+						log.Logger.Infof("Won't process any more nodes in the DAG")
+						halted = true
+					} else {
+						numRemaining -= g.skipDependants(namedNode, down, visited, summary)
+					}
+					continue
 				}
 
-				// we have a node that needs to be processed. Check to see if its dependencies have
-				// been satisfied
-				if dependenciesSatisfied(dependencies, nodeStatusesById) {
-					log.Logger.Debugf("All dependencies satisfied for '%s', adding it to the "+
-						"processing queue", namedNode.node.name)
-					// update the status to running so we don't keep requeuing completed nodes
-					namedNode.status = running
-					nodeStatusesById[node] = namedNode
-					processCh <- namedNode.node
-				} else {
-					log.Logger.Tracef("Dependencies not satisfied for %s", namedNode.node.name)
+				log.Logger.Debugf("Worker informs the DAG it's finished processing node '%s'",
+					namedNode.name)
+				summary.add(namedNode, NodeStatusSucceeded, nil, nodeStartedAt, nodeFinishedAt)
+
+				dependants := g.dependants(namedNode, down)
+				for dependants.Next() {
+					dependant := dependants.Node().(NamedNode)
+					pendingById[dependant.ID()]--
+					if pendingById[dependant.ID()] == 0 {
+						readyQueue = append(readyQueue, dependant)
+					} else {
+						log.Logger.Tracef("Dependencies not satisfied for %s", dependant.name)
+					}
 				}
 			}
+		}
 
-			if allDone(nodeStatusesById) {
-				log.Logger.Infof("DAG fully processed")
-				close(finishedCh)
-				close(doneCh)
-				close(processCh)
-				break
-			} else {
-				// sleep a little bit to give jobs a chance to complete
-				log.Logger.Tracef("DAG still processing. Sleeping for %s...", g.SleepInterval)
-				time.Sleep(g.SleepInterval)
+		// if we halted early, nodes that were never dispatched have been skipped
+		nodes := g.graph.Nodes()
+		for nodes.Next() {
+			node := nodes.Node().(NamedNode)
+			if !visited[node.ID()] {
+				summary.add(node, NodeStatusSkipped, nil, time.Time{}, time.Time{})
 			}
 		}
+
+		log.Logger.Infof("DAG fully processed")
+		close(processCh)
+		finishedCh <- summary
+		close(finishedCh)
 	}()
 
 	return finishedCh
 }
 
+// Marks all nodes that transitively depend on the given node as skipped and returns the number of
+// nodes that were skipped. Their dependency counters are never decremented so they'll never be
+// dispatched.
+func (g *Dag) skipDependants(node NamedNode, down bool, visited map[int64]bool, summary *Summary) int {
+	numSkipped := 0
+
+	dependants := g.dependants(node, down)
+	for dependants.Next() {
+		dependant := dependants.Node().(NamedNode)
+		if visited[dependant.ID()] {
+			continue
+		}
+
+		log.Logger.Infof("Skipping node '%s' because '%s' failed", dependant.name, node.name)
+		visited[dependant.ID()] = true
+		summary.add(dependant, NodeStatusSkipped, nil, time.Time{}, time.Time{})
+		numSkipped++
+
+		numSkipped += g.skipDependants(dependant, down, visited, summary)
+	}
+
+	return numSkipped
+}
+
+// Returns a map of the number of dependencies each node has keyed by node ID. If down==true
+// dependencies are parents, otherwise they're children.
+func (g *Dag) numDependenciesById(down bool) map[int64]int {
+	numDependenciesById := make(map[int64]int, 0)
+
+	nodes := g.graph.Nodes()
+
+	for nodes.Next() {
+		node := nodes.Node()
+		if down {
+			numDependenciesById[node.ID()] = g.graph.To(node.ID()).Len()
+		} else {
+			numDependenciesById[node.ID()] = g.graph.From(node.ID()).Len()
+		}
+	}
+
+	return numDependenciesById
+}
+
+// Returns the index of the node in the ready queue with the highest priority that can be dispatched
+// without exceeding the limit of its concurrency group, or -1 if none can be. Nodes without a
+// priority have a priority of 0, and ties are broken in favour of the node that became ready first.
+func nextDispatchable(readyQueue []NamedNode, inFlightByGroup map[string]int,
+	priorities map[int64]time.Duration) int {
+	nextIndex := -1
+
+	for i, node := range readyQueue {
+		group := concurrencyGroup(node)
+		limit := concurrencyLimit(group)
+		if limit > 0 && inFlightByGroup[group] >= limit {
+			log.Logger.Tracef("Concurrency group '%s' is full so can't dispatch '%s' yet", group, node.name)
+			continue
+		}
+
+		if nextIndex < 0 || priorities[node.ID()] > priorities[readyQueue[nextIndex].ID()] {
+			nextIndex = i
+		}
+	}
+
+	return nextIndex
+}
+
+// Returns the concurrency group of a node, lowercased to match the keys of the configured limits
+func concurrencyGroup(node NamedNode) string {
+	if node.installableObj == nil {
+		return ""
+	}
+
+	return strings.ToLower(node.installableObj.GetDescriptor().ConcurrencyGroup)
+}
+
+// Returns the max number of nodes in a concurrency group that can be processed at once. 0 means
+// there's no limit.
+func concurrencyLimit(group string) int {
+	if group == "" || config.CurrentConfig == nil {
+		return 0
+	}
+
+	return config.CurrentConfig.ConcurrencyGroups[group]
+}
+
+// Returns the nodes that depend on the given node for the walk direction, i.e. children if
+// down==true, otherwise parents
+func (g *Dag) dependants(node NamedNode, down bool) graph.Nodes {
+	if down {
+		return g.graph.From(node.ID())
+	}
+
+	return g.graph.To(node.ID())
+}
+
 // Prints out the DAG to the writer
 func (g *Dag) Print(writer io.Writer) error {
 	_, err := fmt.Fprintf(writer, "\nCreated the following DAG. Nodes marked with a %s will "+
@@ -395,9 +749,9 @@ func (g *Dag) Print(writer io.Writer) error {
 
 	numWorkers := config.CurrentConfig.NumWorkers
 
-	processCh := make(chan NamedNode, numWorkers)
-	doneCh := make(chan NamedNode, numWorkers)
-	finishedCh := g.walkDown(processCh, doneCh)
+	processCh := make(chan NamedNode)
+	doneCh := make(chan nodeResult, numWorkers)
+	finishedCh := g.walkDown(context.Background(), true, walkOptions{}, processCh, doneCh)
 
 	go func() {
 		for node := range processCh {
@@ -416,48 +770,78 @@ func (g *Dag) Print(writer io.Writer) error {
 			}
 			_, err := fmt.Fprintf(writer, "  %s%s - depends on: %s\n", marked, node.name,
 				strings.Join(parentNames, ", "))
-			if err != nil {
-				panic(err)
-			}
-			doneCh <- node
+			doneCh <- nodeResult{node: node, err: err}
 		}
 	}()
 
-	<-finishedCh
+	summary := <-finishedCh
+	failed := summary.Failed()
+	if len(failed) > 0 {
+		return errors.WithStack(failed[0].Err)
+	}
 
 	_, err = fmt.Fprintf(writer, "\n")
 	if err != nil {
 		return errors.WithStack(err)
 	}
 
-	log.Logger.Debug("DAG printed")
+	if len(g.excluded) > 0 {
+		_, err = fmt.Fprintf(writer, "The following kapps were excluded from the DAG:\n")
+		if err != nil {
+			return errors.WithStack(err)
+		}
 
-	return nil
-}
+		for _, excludedKapp := range g.excluded {
+			_, err = fmt.Fprintf(writer, "  %s - %s\n", excludedKapp.Id, excludedKapp.Reason)
+			if err != nil {
+				return errors.WithStack(err)
+			}
+		}
 
-// Returns a boolean indicating whether all nodes have been processed
-func allDone(nodeStatuses map[int64]nodeStatus) bool {
-	for _, nodeStatus := range nodeStatuses {
-		if nodeStatus.status != finished {
-			return false
+		_, err = fmt.Fprintf(writer, "\n")
+		if err != nil {
+			return errors.WithStack(err)
 		}
 	}
 
-	return true
+	err = g.printSchedule(writer, constants.DagActionInstall)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	log.Logger.Debug("DAG printed")
+
+	return nil
 }
 
-// Returns a boolean indicating whether all dependencies of a node have been satisfied
-func dependenciesSatisfied(dependencies graph.Nodes, nodeStatuses map[int64]nodeStatus) bool {
+// Prints the expected critical path through the DAG and how long processing it should take if
+// durations have been recorded for any of the marked nodes
+func (g *Dag) printSchedule(writer io.Writer, action string) error {
+	scheduleObj, err := g.schedule(action)
+	if err != nil {
+		return errors.WithStack(err)
+	}
 
-	for dependencies.Next() {
-		dependency := dependencies.Node().(NamedNode)
-		nodeStatus := nodeStatuses[dependency.ID()]
-		if nodeStatus.status != finished {
-			log.Logger.Tracef("Dependent node '%s' hasn't finished (status=%+v)", dependency.name,
-				nodeStatus.status)
-			return false
+	if scheduleObj == nil {
+		return nil
+	}
+
+	steps := make([]string, 0)
+	for _, node := range scheduleObj.criticalPath {
+		// unmarked nodes only have their outputs loaded
+		if !node.marked {
+			continue
 		}
+		steps = append(steps, fmt.Sprintf("%s (%s)", node.name,
+			scheduleObj.expected[node.ID()].Round(time.Second)))
 	}
 
-	return true
+	_, err = fmt.Fprintf(writer, "Expected critical path when running '%s' (based on previous runs):\n"+
+		"  %s\nEstimated time: %s\n\n", action, strings.Join(steps, " -> "),
+		scheduleObj.eta.Round(time.Second))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return nil
 }
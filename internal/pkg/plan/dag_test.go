@@ -17,12 +17,21 @@
 package plan
 
 import (
+	"context"
 	"fmt"
 	"github.com/stretchr/testify/assert"
+	"github.com/sugarkube/sugarkube/internal/pkg/config"
+	"github.com/sugarkube/sugarkube/internal/pkg/constants"
+	"github.com/sugarkube/sugarkube/internal/pkg/installable"
+	"github.com/sugarkube/sugarkube/internal/pkg/interfaces"
 	"github.com/sugarkube/sugarkube/internal/pkg/log"
+	"github.com/sugarkube/sugarkube/internal/pkg/registry"
+	"github.com/sugarkube/sugarkube/internal/pkg/structs"
 	"github.com/sugarkube/sugarkube/internal/pkg/utils"
+	"strings"
 	"sync"
 	"testing"
+	"time"
 )
 
 func init() {
@@ -43,6 +52,22 @@ func getDescriptors() map[string]nodeDescriptor {
 	}
 }
 
+// Creates a DAG of kapps with the given descriptors in a manifest called 'manifest'. All the
+// kapps are marked.
+func createTestDag(t *testing.T, descriptors ...structs.KappDescriptorWithMaps) *Dag {
+	manifest := newTestManifest(t, "manifest", false, descriptors...)
+
+	selectedIds := make([]string, 0)
+	for _, installableObj := range manifest.Installables() {
+		selectedIds = append(selectedIds, installableObj.FullyQualifiedId())
+	}
+
+	dag, err := Create(nil, []interfaces.IManifest{manifest}, selectedIds, nil, false, false)
+	assert.Nil(t, err)
+
+	return dag
+}
+
 // Tests that DAGs are created correctly
 func TestBuildDag(t *testing.T) {
 	input := getDescriptors()
@@ -128,7 +153,7 @@ func TestTraverse(t *testing.T) {
 	}
 
 	processCh := make(chan NamedNode)
-	doneCh := make(chan NamedNode)
+	doneCh := make(chan nodeResult)
 
 	mutex := &sync.Mutex{}
 	numProcessed := 0
@@ -141,6 +166,7 @@ func TestTraverse(t *testing.T) {
 			for node := range processCh {
 				log.Logger.Infof("Processing '%s' in goroutine...", node.name)
 
+				mutex.Lock()
 				// make sure the first node we process is one of those marked as being allowed to
 				// be processed first
 				if numProcessed == 0 {
@@ -148,26 +174,346 @@ func TestTraverse(t *testing.T) {
 				}
 
 				lastProcessedId = node.name
-
-				mutex.Lock()
 				numProcessed++
 				mutex.Unlock()
 
-				doneCh <- node
+				doneCh <- nodeResult{node: node}
 			}
 		}()
 	}
 
-	finishedCh := dag.walkDown(processCh, doneCh)
+	finishedCh := dag.walkDown(context.Background(), true, walkOptions{}, processCh, doneCh)
 
 	// wait for traversal to finish
-	select {
-	case <-finishedCh:
-		break
-	}
+	<-finishedCh
 
 	// make sure the last to be processed is marked as being allowed to be last
 	assert.True(t, utils.InStringArray(possibleLastNodes, lastProcessedId))
+	assert.Equal(t, len(input), numProcessed)
+}
+
+// Tests that nodes are only processed after all their dependencies have finished when
+// walking in either direction
+func TestWalkOrder(t *testing.T) {
+	input := getDescriptors()
+	dag, err := build(input)
+	assert.Nil(t, err)
+
+	for _, down := range []bool{true, false} {
+		processCh := make(chan NamedNode)
+		doneCh := make(chan nodeResult)
+
+		mutex := &sync.Mutex{}
+		finished := make(map[string]bool, 0)
+
+		for i := 0; i < 3; i++ {
+			go func() {
+				for node := range processCh {
+					mutex.Lock()
+					dependencies := dag.graph.To(node.ID())
+					if !down {
+						dependencies = dag.graph.From(node.ID())
+					}
+
+					for dependencies.Next() {
+						dependency := dependencies.Node().(NamedNode)
+						assert.True(t, finished[dependency.name], "'%s' was processed before "+
+							"its dependency '%s' (down=%v)", node.name, dependency.name, down)
+					}
+
+					finished[node.name] = true
+					mutex.Unlock()
+
+					doneCh <- nodeResult{node: node}
+				}
+			}()
+		}
+
+		<-dag.walk(context.Background(), down, true, walkOptions{}, processCh, doneCh)
+
+		assert.Equal(t, len(input), len(finished))
+	}
+}
+
+// Runs workers that fail to process the named node and returns the summary of the walk
+func walkWithFailure(t *testing.T, dag *Dag, failFast bool, failingNode string) *Summary {
+	processCh := make(chan NamedNode)
+	doneCh := make(chan nodeResult)
+
+	for i := 0; i < 3; i++ {
+		go func() {
+			for node := range processCh {
+				var err error
+				if node.name == failingNode {
+					err = fmt.Errorf("failed processing '%s'", node.name)
+				}
+				doneCh <- nodeResult{node: node, err: err}
+			}
+		}()
+	}
+
+	return <-dag.walkDown(context.Background(), failFast, walkOptions{}, processCh, doneCh)
+}
+
+// Returns the names of nodes in the outcomes
+func outcomeNames(outcomes []NodeOutcome) []string {
+	names := make([]string, 0)
+	for _, outcome := range outcomes {
+		names = append(names, outcome.Name)
+	}
+
+	return names
+}
+
+// Tests that descendants of a failed node are skipped but independent branches are processed
+func TestWalkContinueAfterFailure(t *testing.T) {
+	input := getDescriptors()
+	dag, err := build(input)
+	assert.Nil(t, err)
+
+	summary := walkWithFailure(t, dag, false, "tiller")
+
+	assert.Equal(t, len(input), len(summary.Outcomes))
+	assert.Equal(t, []string{"tiller"}, outcomeNames(summary.Failed()))
+	assert.ElementsMatch(t, []string{"externalIngress", "wordpress1", "wordpress2", "varnish"},
+		outcomeNames(summary.Skipped()))
+	assert.ElementsMatch(t, []string{"independent", "cluster", "sharedRds"},
+		outcomeNames(summary.withStatus(NodeStatusSucceeded)))
+}
+
+// Tests that no further nodes are dispatched after a failure when failing fast
+func TestWalkFailFast(t *testing.T) {
+	input := getDescriptors()
+	dag, err := build(input)
+	assert.Nil(t, err)
+
+	summary := walkWithFailure(t, dag, true, "cluster")
+
+	assert.Equal(t, len(input), len(summary.Outcomes))
+	assert.Equal(t, []string{"cluster"}, outcomeNames(summary.Failed()))
+
+	skipped := outcomeNames(summary.Skipped())
+	for _, name := range []string{"tiller", "externalIngress", "wordpress1", "wordpress2", "varnish"} {
+		assert.True(t, utils.InStringArray(skipped, name), "'%s' wasn't skipped", name)
+	}
+}
+
+// Tests that no more nodes are processed after the walk is interrupted and that nodes that were running
+// are reported as having been interrupted
+func TestWalkInterrupted(t *testing.T) {
+	input := getDescriptors()
+	dag, err := build(input)
+	assert.Nil(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	processCh := make(chan NamedNode)
+	doneCh := make(chan nodeResult)
+
+	go func() {
+		for node := range processCh {
+			if ctx.Err() != nil {
+				doneCh <- nodeResult{node: node, notStarted: true}
+				continue
+			}
+
+			if node.name == "tiller" {
+				cancel()
+				doneCh <- nodeResult{node: node, err: ctx.Err()}
+				continue
+			}
+
+			doneCh <- nodeResult{node: node}
+		}
+	}()
+
+	summary := <-dag.walkDown(ctx, true, walkOptions{}, processCh, doneCh)
+
+	assert.Equal(t, len(input), len(summary.Outcomes))
+	assert.Equal(t, []string{"tiller"}, outcomeNames(summary.Interrupted()))
+	assert.Empty(t, summary.Failed())
+
+	skipped := outcomeNames(summary.Skipped())
+	for _, name := range []string{"externalIngress", "wordpress1", "wordpress2", "varnish"} {
+		assert.True(t, utils.InStringArray(skipped, name), "'%s' wasn't skipped", name)
+	}
+}
+
+// Tests that walking an empty DAG finishes immediately
+func TestWalkEmpty(t *testing.T) {
+	dag, err := build(map[string]nodeDescriptor{})
+	assert.Nil(t, err)
+
+	processCh := make(chan NamedNode)
+	doneCh := make(chan nodeResult)
+
+	<-dag.walkDown(context.Background(), true, walkOptions{}, processCh, doneCh)
+
+	_, ok := <-processCh
+	assert.False(t, ok)
+}
+
+// Tests that no more nodes in a concurrency group are processed at once than its configured limit
+// Tests that optional dependencies on kapps that aren't in the stack are ignored, and that edges
+// record the kind of dependency
+func TestBuildDagDependencyKinds(t *testing.T) {
+	descriptors := map[string]nodeDescriptor{
+		"cluster":    {},
+		"monitoring": {},
+		"app": {
+			dependsOn: []string{"cluster", "monitoring", "tracing", "dns"},
+			optional:  map[string]bool{"monitoring": true, "tracing": true},
+			runsAfter: map[string]bool{"dns": true},
+		},
+		"dns": {},
+	}
+
+	dag, err := build(descriptors)
+	assert.Nil(t, err)
+
+	expected := map[string]string{
+		"cluster":    constants.EdgeTypeDependsOn,
+		"monitoring": constants.EdgeTypeOptionalDependsOn,
+		"dns":        constants.EdgeTypeRunsAfter,
+	}
+
+	app := dag.nodesByName()["app"]
+	actual := make(map[string]string, 0)
+	parents := dag.graph.To(app.ID())
+	for parents.Next() {
+		parent := parents.Node().(NamedNode)
+		actual[parent.name] = edgeType(dag.graph.Edge(parent.ID(), app.ID()))
+	}
+
+	assert.Equal(t, expected, actual)
+
+	// hard dependencies on kapps that aren't in the stack are still an error
+	delete(descriptors, "cluster")
+	_, err = build(descriptors)
+	assert.Error(t, err)
+}
+
+// Tests that kapps that only run after a parent don't get its outputs
+func TestAddParentRegistriesRunsAfter(t *testing.T) {
+	descriptors := map[string]nodeDescriptor{}
+	for _, id := range []string{"database", "dns", "app"} {
+		installableObj, err := installable.New("manifest", []structs.KappDescriptorWithMaps{{Id: id}})
+		assert.Nil(t, err)
+
+		localRegistry := registry.New()
+		assert.Nil(t, localRegistry.Set(fmt.Sprintf("outputs.manifest:%s.host", id), id))
+		installableObj.SetLocalRegistry(localRegistry)
+
+		descriptors[id] = nodeDescriptor{installableObj: installableObj}
+	}
+
+	app := descriptors["app"]
+	app.dependsOn = []string{"database", "dns"}
+	app.runsAfter = map[string]bool{"dns": true}
+	descriptors["app"] = app
+
+	dag, err := build(descriptors)
+	assert.Nil(t, err)
+
+	node := dag.nodesByName()["app"]
+	assert.Nil(t, addParentRegistries(dag, node))
+
+	outputs := node.installableObj.GetLocalRegistry().AsMap()["outputs"].(map[string]interface{})
+	assert.Contains(t, outputs, "manifest:database")
+	assert.NotContains(t, outputs, "manifest:dns")
+}
+
+func TestWalkConcurrencyGroups(t *testing.T) {
+	previousConfig := config.CurrentConfig
+	config.CurrentConfig = &config.Config{ConcurrencyGroups: map[string]int{"terraform": 1}}
+	defer func() { config.CurrentConfig = previousConfig }()
+
+	descriptors := map[string]nodeDescriptor{}
+	for _, id := range []string{"tf1", "tf2", "tf3", "helm1", "helm2"} {
+		group := ""
+		if strings.HasPrefix(id, "tf") {
+			group = "Terraform"
+		}
+
+		installableObj, err := installable.New("manifest", []structs.KappDescriptorWithMaps{
+			{Id: id, KappConfig: structs.KappConfig{ConcurrencyGroup: group}}})
+		assert.Nil(t, err)
+		descriptors[id] = nodeDescriptor{installableObj: installableObj}
+	}
+
+	dag, err := build(descriptors)
+	assert.Nil(t, err)
+
+	processCh := make(chan NamedNode)
+	doneCh := make(chan nodeResult)
+
+	mutex := &sync.Mutex{}
+	inFlight := map[string]int{}
+	maxInFlight := map[string]int{}
+
+	for i := 0; i < len(descriptors); i++ {
+		go func() {
+			for node := range processCh {
+				group := concurrencyGroup(node)
+
+				mutex.Lock()
+				inFlight[group]++
+				if inFlight[group] > maxInFlight[group] {
+					maxInFlight[group] = inFlight[group]
+				}
+				mutex.Unlock()
+
+				time.Sleep(10 * time.Millisecond)
+
+				mutex.Lock()
+				inFlight[group]--
+				mutex.Unlock()
+
+				doneCh <- nodeResult{node: node}
+			}
+		}()
+	}
+
+	summary := <-dag.walkDown(context.Background(), true, walkOptions{}, processCh, doneCh)
+
+	assert.Equal(t, len(descriptors), len(summary.withStatus(NodeStatusSucceeded)))
+	assert.Equal(t, 1, maxInFlight["terraform"])
+	// ungrouped nodes use the global worker pool
+	assert.Equal(t, 2, maxInFlight[""])
+}
+
+func TestWalkPriorities(t *testing.T) {
+	dag, err := build(map[string]nodeDescriptor{
+		"short":  {},
+		"medium": {},
+		"long":   {},
+	})
+	assert.Nil(t, err)
+
+	nodesByName := dag.nodesByName()
+	options := walkOptions{
+		priorities: map[int64]time.Duration{
+			nodesByName["short"].ID():  time.Second,
+			nodesByName["medium"].ID(): time.Minute,
+			nodesByName["long"].ID():   time.Hour,
+		},
+	}
+
+	processCh := make(chan NamedNode)
+	doneCh := make(chan nodeResult)
+
+	order := make([]string, 0)
+	go func() {
+		for node := range processCh {
+			order = append(order, node.name)
+			doneCh <- nodeResult{node: node}
+		}
+	}()
+
+	summary := <-dag.walkDown(context.Background(), true, options, processCh, doneCh)
+	assert.Equal(t, 3, len(summary.withStatus(NodeStatusSucceeded)))
+	assert.Equal(t, []string{"long", "medium", "short"}, order)
 }
 
 // Test we can extract subgraphs of the node
@@ -178,7 +524,7 @@ func TestSubGraph(t *testing.T) {
 
 	nodeNames := []string{"wordpress1", "independent"}
 
-	subGraph, err := dag.subGraph(nodeNames, false)
+	subGraph, err := dag.subGraph(nodeNames, nil, false, false)
 	assert.Nil(t, err)
 
 	nodesByName := subGraph.nodesByName()
@@ -188,6 +534,51 @@ func TestSubGraph(t *testing.T) {
 	}
 }
 
+// Test selecting children marks all descendants of selected nodes except excluded ones
+func TestSubGraphWithChildren(t *testing.T) {
+	dag, err := build(getDescriptors())
+	assert.Nil(t, err)
+
+	subGraph, err := dag.subGraph([]string{"tiller"}, []string{"wordpress1"}, false, true)
+	assert.Nil(t, err)
+
+	assert.Equal(t, map[string]bool{
+		"cluster":         false,
+		"tiller":          true,
+		"externalIngress": true,
+		"sharedRds":       false,
+		"wordpress2":      true,
+		"varnish":         true,
+	}, markedByName(subGraph))
+
+	// excluded parents aren't marked even if parents should be processed
+	subGraph, err = dag.subGraph([]string{"tiller"}, []string{"wordpress1", "sharedRds"}, true, true)
+	assert.Nil(t, err)
+
+	assert.Equal(t, map[string]bool{
+		"cluster":         true,
+		"tiller":          true,
+		"externalIngress": true,
+		"sharedRds":       false,
+		"wordpress2":      true,
+		"varnish":         true,
+	}, markedByName(subGraph))
+
+	// excluded descendants are still traversed
+	subGraph, err = dag.subGraph([]string{"externalIngress"}, []string{"wordpress2"}, false, true)
+	assert.Nil(t, err)
+	assert.True(t, markedByName(subGraph)["varnish"])
+	assert.False(t, markedByName(subGraph)["wordpress2"])
+}
+
+func markedByName(dag *Dag) map[string]bool {
+	marked := make(map[string]bool, 0)
+	for name, node := range dag.nodesByName() {
+		marked[name] = node.marked
+	}
+	return marked
+}
+
 func assertDependencies(t *testing.T, graphObj *Dag, descriptors map[string]nodeDescriptor,
 	nodesByName map[string]NamedNode, nodeName string, shouldProcess bool) {
 	node := nodesByName[nodeName]
@@ -0,0 +1,160 @@
+/*
+ * Copyright 2019 The Sugarkube Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package plan
+
+import (
+	"github.com/pkg/errors"
+	"github.com/sugarkube/sugarkube/internal/pkg/cacher"
+	"github.com/sugarkube/sugarkube/internal/pkg/constants"
+	"github.com/sugarkube/sugarkube/internal/pkg/log"
+	"github.com/sugarkube/sugarkube/internal/pkg/utils"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const durationsFileName = "durations.yaml"
+
+// Records how long each kapp took to be installed and deleted in each stack so kapps on the longest
+// remaining path through the DAG can be processed first, and so the expected duration of a run can
+// be estimated
+type Durations struct {
+	path      string
+	stackName string
+	mutex     sync.Mutex
+	stacks    map[string]map[string]kappDurations // keyed by stack name, then fully-qualified kapp ID
+}
+
+// The format of the durations file on disk
+type durationsFile struct {
+	Stacks map[string]map[string]kappDurations
+}
+
+// The number of seconds the last successful run of each action took
+type kappDurations struct {
+	Install float64 `yaml:"install,omitempty"`
+	Delete  float64 `yaml:"delete,omitempty"`
+}
+
+// Loads durations recorded by previous runs in the given cache directory for the named stack
+func NewDurations(cacheDir string, stackName string) (*Durations, error) {
+	absCacheDir, err := filepath.Abs(cacheDir)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	durations := &Durations{
+		path:      filepath.Join(absCacheDir, cacher.CacheDir, durationsFileName),
+		stackName: stackName,
+		stacks:    map[string]map[string]kappDurations{},
+	}
+
+	if _, err := os.Stat(durations.path); err != nil {
+		log.Logger.Debugf("No kapp durations found at '%s'", durations.path)
+		return durations, nil
+	}
+
+	contents := durationsFile{}
+	err = utils.LoadYamlFile(durations.path, &contents)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if contents.Stacks != nil {
+		durations.stacks = contents.Stacks
+	}
+
+	return durations, nil
+}
+
+// Returns how long the named kapp took the last time the action was successfully run on it
+func (d *Durations) expected(name string, action string) (time.Duration, bool) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	entry, ok := d.stacks[d.stackName][name]
+	if !ok {
+		return 0, false
+	}
+
+	seconds := entry.Install
+	if action == constants.DagActionDelete {
+		seconds = entry.Delete
+	}
+
+	if seconds <= 0 {
+		return 0, false
+	}
+
+	return time.Duration(seconds * float64(time.Second)), true
+}
+
+// Records how long each marked node that succeeded took to process and writes the durations to disk.
+// Nodes whose names are in the skip list (e.g. because they were resumed from a journal instead of
+// being processed) aren't recorded.
+func (d *Durations) record(summary *Summary, skip map[string]bool) error {
+	if summary.Action != constants.DagActionInstall && summary.Action != constants.DagActionDelete &&
+		summary.Action != constants.DagActionApply {
+		return nil
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	stackDurations, ok := d.stacks[d.stackName]
+	if !ok {
+		stackDurations = map[string]kappDurations{}
+		d.stacks[d.stackName] = stackDurations
+	}
+
+	numRecorded := 0
+
+	for _, outcome := range summary.Outcomes {
+		if !outcome.Marked || outcome.Status != NodeStatusSucceeded || skip[outcome.Name] {
+			continue
+		}
+
+		seconds := outcome.FinishedAt.Sub(outcome.StartedAt).Seconds()
+		action := outcome.Action
+		if action == "" {
+			action = summary.Action
+		}
+
+		entry := stackDurations[outcome.Name]
+		if action == constants.DagActionDelete {
+			entry.Delete = seconds
+		} else {
+			entry.Install = seconds
+		}
+		stackDurations[outcome.Name] = entry
+		numRecorded++
+	}
+
+	if numRecorded == 0 {
+		return nil
+	}
+
+	err := writeYamlFile(d.path, durationsFile{Stacks: d.stacks})
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	log.Logger.Debugf("Recorded durations of %d kapps in '%s'", numRecorded, d.path)
+
+	return nil
+}
@@ -0,0 +1,132 @@
+/*
+ * Copyright 2019 The Sugarkube Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package plan
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/sugarkube/sugarkube/internal/pkg/config"
+	"github.com/sugarkube/sugarkube/internal/pkg/constants"
+	"github.com/sugarkube/sugarkube/internal/pkg/structs"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestDurationsRecord(t *testing.T) {
+	cacheDir, err := ioutil.TempDir("", "durations-")
+	assert.Nil(t, err)
+	defer os.RemoveAll(cacheDir)
+
+	durations, err := NewDurations(cacheDir, "stack")
+	assert.Nil(t, err)
+
+	startedAt := time.Date(2019, 5, 1, 12, 0, 0, 0, time.UTC)
+	nodes := createTestDag(t, structs.KappDescriptorWithMaps{Id: "succeeded"},
+		structs.KappDescriptorWithMaps{Id: "failed"}, structs.KappDescriptorWithMaps{Id: "unmarked"},
+		structs.KappDescriptorWithMaps{Id: "resumed"}).nodesByName()
+	unmarked := nodes["manifest:unmarked"]
+	unmarked.marked = false
+
+	summary := &Summary{Action: constants.DagActionInstall}
+	summary.add(nodes["manifest:succeeded"], NodeStatusSucceeded, nil, startedAt,
+		startedAt.Add(90*time.Second))
+	summary.add(nodes["manifest:failed"], NodeStatusFailed, nil, startedAt,
+		startedAt.Add(time.Second))
+	summary.add(unmarked, NodeStatusSucceeded, nil, startedAt,
+		startedAt.Add(time.Second))
+	summary.add(nodes["manifest:resumed"], NodeStatusSucceeded, nil, startedAt,
+		startedAt.Add(time.Second))
+
+	assert.Nil(t, durations.record(summary, map[string]bool{"manifest:resumed": true}))
+
+	reloaded, err := NewDurations(cacheDir, "stack")
+	assert.Nil(t, err)
+
+	duration, ok := reloaded.expected("manifest:succeeded", constants.DagActionInstall)
+	assert.True(t, ok)
+	assert.Equal(t, 90*time.Second, duration)
+
+	for _, name := range []string{"manifest:failed", "manifest:unmarked", "manifest:resumed"} {
+		_, ok := reloaded.expected(name, constants.DagActionInstall)
+		assert.False(t, ok, name)
+	}
+
+	_, ok = reloaded.expected("manifest:succeeded", constants.DagActionDelete)
+	assert.False(t, ok)
+
+	// durations are recorded per stack
+	otherStack, err := NewDurations(cacheDir, "other")
+	assert.Nil(t, err)
+	_, ok = otherStack.expected("manifest:succeeded", constants.DagActionInstall)
+	assert.False(t, ok)
+}
+
+func TestSchedule(t *testing.T) {
+	previousConfig := config.CurrentConfig
+	config.CurrentConfig = &config.Config{NumWorkers: 2}
+	defer func() { config.CurrentConfig = previousConfig }()
+
+	dag, err := build(getDescriptors())
+	assert.Nil(t, err)
+
+	scheduleObj, err := dag.schedule(constants.DagActionInstall)
+	assert.Nil(t, err)
+	assert.Nil(t, scheduleObj)
+
+	// wordpress1 hasn't been installed before so is expected to take the mean of the others (40s)
+	seconds := map[string]float64{
+		"independent":     30,
+		"cluster":         10,
+		"tiller":          20,
+		"externalIngress": 30,
+		"sharedRds":       100,
+		"wordpress2":      40,
+		"varnish":         50,
+	}
+	stackDurations := map[string]kappDurations{}
+	for name, value := range seconds {
+		stackDurations[name] = kappDurations{Install: value}
+	}
+	dag.Durations = &Durations{
+		stackName: "stack",
+		stacks:    map[string]map[string]kappDurations{"stack": stackDurations},
+	}
+
+	scheduleObj, err = dag.schedule(constants.DagActionInstall)
+	assert.Nil(t, err)
+
+	nodesByName := dag.nodesByName()
+	assert.Equal(t, 40*time.Second, scheduleObj.expected[nodesByName["wordpress1"].ID()])
+	assert.Equal(t, 150*time.Second, scheduleObj.remaining[nodesByName["cluster"].ID()])
+	assert.Equal(t, 190*time.Second, scheduleObj.remaining[nodesByName["sharedRds"].ID()])
+	assert.Equal(t, 50*time.Second, scheduleObj.remaining[nodesByName["varnish"].ID()])
+
+	pathNames := make([]string, 0)
+	for _, node := range scheduleObj.criticalPath {
+		pathNames = append(pathNames, node.name)
+	}
+	assert.Equal(t, []string{"sharedRds", "wordpress2", "varnish"}, pathNames)
+
+	// the critical path takes longer than 2 workers processing 320s of kapps
+	assert.Equal(t, 190*time.Second, scheduleObj.eta)
+
+	// no durations have been recorded for deleting kapps
+	scheduleObj, err = dag.schedule(constants.DagActionDelete)
+	assert.Nil(t, err)
+	assert.Nil(t, scheduleObj)
+}
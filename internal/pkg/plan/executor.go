@@ -17,12 +17,14 @@
 package plan
 
 import (
+	"context"
 	"fmt"
 	"github.com/imdario/mergo"
 	"github.com/pkg/errors"
 	"github.com/sugarkube/sugarkube/internal/pkg/cmd/cli/cluster"
 	"github.com/sugarkube/sugarkube/internal/pkg/config"
 	"github.com/sugarkube/sugarkube/internal/pkg/constants"
+	"github.com/sugarkube/sugarkube/internal/pkg/installable"
 	"github.com/sugarkube/sugarkube/internal/pkg/installer"
 	"github.com/sugarkube/sugarkube/internal/pkg/interfaces"
 	"github.com/sugarkube/sugarkube/internal/pkg/log"
@@ -33,81 +35,234 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 )
 
 // Traverses the DAG executing the named action on marked/processable nodes depending on the
-// given options
-func (d *Dag) Execute(action string, stackObj interfaces.IStack, plan bool, approved bool, skipPreActions bool,
-	skipPostActions bool, ignoreErrors bool, dryRun bool) error {
+// given options. The failure policy determines whether to stop processing the DAG as soon as a
+// kapp fails or to carry on processing kapps that don't depend on failed kapps. A summary of the
+// outcome of processing each node is returned along with an error if any kapps failed.
+//
+// If the context is cancelled no more kapps will be processed. Running kapps are sent the signal that
+// interrupted sugarkube (see utils.WithInterrupt) and any sensitive files written by kapps are deleted.
+func (d *Dag) Execute(ctx context.Context, action string, stackObj interfaces.IStack, plan bool, approved bool,
+	skipPreActions bool, skipPostActions bool, ignoreErrors bool, dryRun bool, failurePolicy string) (*Summary, error) {
 	numWorkers := config.CurrentConfig.NumWorkers
 
-	processCh := make(chan NamedNode, numWorkers)
-	doneCh := make(chan NamedNode)
-	errCh := make(chan error)
+	var failFast bool
+	switch failurePolicy {
+	case constants.FailurePolicyFailFast:
+		failFast = true
+	case constants.FailurePolicyContinue:
+		failFast = false
+	default:
+		return nil, fmt.Errorf("Invalid failure policy '%s'. Valid values are: %s, %s", failurePolicy,
+			constants.FailurePolicyFailFast, constants.FailurePolicyContinue)
+	}
+
+	processCh := make(chan NamedNode)
+	doneCh := make(chan nodeResult)
 
 	log.Logger.Infof("Executing DAG with action=%s, plan=%v, approved=%v, "+
-		"skipPostActions=%v, ignoreErrors=%v, dryRun=%v", action, plan, approved, skipPostActions,
-		ignoreErrors, dryRun)
+		"skipPostActions=%v, ignoreErrors=%v, dryRun=%v, failurePolicy=%s", action, plan, approved,
+		skipPostActions, ignoreErrors, dryRun, failurePolicy)
+
+	// when applying, kapps are walked in the order they need to be installed or deleted in
+	walkDag := d
+	if action == constants.DagActionApply {
+		var err error
+		walkDag, err = d.reconciled()
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
 
-	// create the worker pool
-	for w := int(0); w < numWorkers; w++ {
-		go worker(d, processCh, doneCh, errCh, action, stackObj, plan, approved, skipPreActions, skipPostActions,
-			ignoreErrors, dryRun)
+	// these options only apply to the walk that performs the action, not to any preliminary walks
+	// to load outputs
+	options := walkOptions{}
+
+	if config.CurrentConfig.HeartbeatInterval > 0 {
+		options.heartbeat = &heartbeat{
+			interval:   time.Duration(config.CurrentConfig.HeartbeatInterval) * time.Second,
+			out:        os.Stdout,
+			numWorkers: numWorkers,
+		}
+	}
+
+	if action == constants.DagActionInstall || action == constants.DagActionDelete ||
+		action == constants.DagActionApply {
+		scheduleObj, err := walkDag.schedule(action)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		if scheduleObj != nil {
+			log.Logger.Infof("Processing kapps on the critical path first. Estimated time: %s",
+				scheduleObj.eta.Round(time.Second))
+			options.priorities = scheduleObj.remaining
+		}
+	}
+
+	// commands run by installers are logged to files in each kapp's cache dir
+	if !dryRun {
+		runId := newRunId()
+		log.Logger.Infof("Commands run for kapps will be logged under '%s' in their cache directories",
+			filepath.Join(KappLogsDir(""), runId))
+		ctx = withRunId(ctx, runId)
 	}
 
-	var finishedCh <-chan bool
+	startedAt := time.Now()
+
+	var finishedCh <-chan *Summary
 
 	switch action {
 	case constants.DagActionTemplate, constants.DagActionClean, constants.DagActionOutput,
 		constants.DagActionInstall:
-		finishedCh = d.walkDown(processCh, doneCh)
+		finishedCh = d.walkDown(ctx, failFast, options, processCh, doneCh)
 	case constants.DagActionDelete:
 		// first walk down the DAG to load outputs and build local registries for the kapps, then walk
 		// up it executing the marked ones
-		err := initLocalRegistries(d, numWorkers, stackObj, action, approved, dryRun)
+		err := initLocalRegistries(ctx, d, numWorkers, stackObj, action, approved, dryRun)
 		if err != nil {
-			return errors.WithStack(err)
+			if ctx.Err() != nil {
+				d.deleteSensitiveFiles(dryRun)
+			}
+			return nil, errors.WithStack(err)
 		}
-		finishedCh = d.walkUp(processCh, doneCh)
+		finishedCh = d.walkUp(ctx, failFast, options, processCh, doneCh)
+	case constants.DagActionApply:
+		// kapps that will be deleted need their parents' outputs, but some parents may only be
+		// deleted afterwards, so load outputs for all kapps first like when deleting
+		if d.hasDeletions() {
+			err := initLocalRegistries(ctx, d, numWorkers, stackObj, constants.DagActionDelete, approved,
+				dryRun)
+			if err != nil {
+				if ctx.Err() != nil {
+					d.deleteSensitiveFiles(dryRun)
+				}
+				return nil, errors.WithStack(err)
+			}
+		}
+		finishedCh = walkDag.walkDown(ctx, failFast, options, processCh, doneCh)
 	default:
-		return fmt.Errorf("Invalid action on DAG: %s", action)
+		return nil, fmt.Errorf("Invalid action on DAG: %s", action)
+	}
+
+	// create the worker pool
+	for w := int(0); w < numWorkers; w++ {
+		go worker(ctx, d, processCh, doneCh, action, stackObj, plan, approved, skipPreActions, skipPostActions,
+			ignoreErrors, dryRun)
 	}
 
 	log.Logger.Debug("Blocking waiting for the DAG to finish processing...")
 
-	for {
-		// Note: Do NOT add a case for doneCh or it'll introduce a race that prevents the DAG from
-		// updating the status of each node
-		select {
-		case err := <-errCh:
-			return errors.Wrapf(err, "Error processing kapp")
-		case <-finishedCh:
-			log.Logger.Infof("Finished processing kapps")
-			return nil
+	summary := <-finishedCh
+	summary.Action = action
+	summary.Plan = plan
+	summary.Approved = approved
+	summary.DryRun = dryRun
+	summary.StartedAt = startedAt
+	summary.FinishedAt = time.Now()
+
+	nodesByName := d.nodesByName()
+	for i, outcome := range summary.Outcomes {
+		summary.Outcomes[i].Action = nodeAction(action, nodesByName[outcome.Name])
+	}
+
+	// only approved runs actually install or delete kapps so other durations aren't representative
+	if d.Durations != nil && approved && !dryRun {
+		notProcessed := map[string]bool{}
+		if d.Journal != nil {
+			notProcessed = d.Journal.resumedNodes()
+		}
+		if d.Fingerprints != nil {
+			for name := range d.Fingerprints.unchangedNodes() {
+				notProcessed[name] = true
+			}
+		}
+
+		err := d.Durations.record(summary, notProcessed)
+		if err != nil {
+			log.Logger.Warnf("Error recording durations of kapps: %v", err)
+		}
+	}
+
+	if ctx.Err() != nil {
+		d.deleteSensitiveFiles(dryRun)
+
+		interruptedNames := make([]string, 0)
+		for _, outcome := range summary.Interrupted() {
+			interruptedNames = append(interruptedNames, outcome.Name)
+		}
+
+		return summary, errors.New(fmt.Sprintf("Interrupted while processing kapps. %d kapp(s) were "+
+			"interrupted while running: %s", len(interruptedNames), strings.Join(interruptedNames, ", ")))
+	}
+
+	failed := summary.Failed()
+	if len(failed) > 0 {
+		if failFast {
+			return summary, errors.Wrapf(failed[0].Err, "Error processing kapp '%s'", failed[0].Name)
+		}
+
+		failedNames := make([]string, len(failed))
+		for i, outcome := range failed {
+			failedNames[i] = outcome.Name
+		}
+
+		return summary, errors.New(fmt.Sprintf("%d kapp(s) failed to be processed: %s",
+			len(failed), strings.Join(failedNames, ", ")))
+	}
+
+	log.Logger.Infof("Finished processing kapps")
+	return summary, nil
+}
+
+// Deletes sensitive outputs and rendered sensitive templates of all kapps in the DAG, e.g. in case
+// kapps were interrupted before they could be cleaned up. Errors are logged instead of being returned
+// so we try to delete as many files as possible.
+func (d *Dag) deleteSensitiveFiles(dryRun bool) {
+	for _, installableObj := range d.GetInstallables() {
+		paths, err := installableObj.SensitiveFiles()
+		if err != nil {
+			log.Logger.Warnf("Error finding sensitive files for kapp '%s': %v",
+				installableObj.FullyQualifiedId(), err)
+			continue
+		}
+
+		for _, path := range paths {
+			if _, err := os.Stat(path); err != nil {
+				continue
+			}
+
+			if dryRun {
+				log.Logger.Infof("[Dry run] Would delete sensitive file '%s'", path)
+				continue
+			}
+
+			log.Logger.Infof("Deleting sensitive file '%s' of kapp '%s'", path,
+				installableObj.FullyQualifiedId())
+			err = os.Remove(path)
+			if err != nil {
+				log.Logger.Warnf("Error deleting sensitive file '%s': %v", path, err)
+			}
 		}
 	}
 }
 
 // Traverses the DAG printing vars for all marked nodes, optionally suppressing output for certain keys
-func (d *Dag) ExecuteGetVars(action string, stackObj interfaces.IStack, loadOutputs bool, suppress []string) error {
+func (d *Dag) ExecuteGetVars(ctx context.Context, action string, stackObj interfaces.IStack, loadOutputs bool, suppress []string) error {
 	numWorkers := config.CurrentConfig.NumWorkers
 
-	processCh := make(chan NamedNode, numWorkers)
-	doneCh := make(chan NamedNode)
-	errCh := make(chan error)
+	processCh := make(chan NamedNode)
+	doneCh := make(chan nodeResult)
 
 	log.Logger.Infof("Executing DAG with action=%s", action)
 
-	// create the worker pool
-	for w := int(0); w < numWorkers; w++ {
-		go varsWorker(processCh, doneCh, errCh, stackObj, suppress)
-	}
-
-	var finishedCh <-chan bool
+	var finishedCh <-chan *Summary
 
 	if loadOutputs {
 		// initialise local registries to make outputs available
-		err := initLocalRegistries(d, numWorkers, stackObj, action, false, false)
+		err := initLocalRegistries(ctx, d, numWorkers, stackObj, action, false, false)
 		if err != nil {
 			return errors.WithStack(err)
 		}
@@ -117,357 +272,440 @@ func (d *Dag) ExecuteGetVars(action string, stackObj interfaces.IStack, loadOutp
 
 	switch action {
 	case constants.DagActionVars:
-		finishedCh = d.walkDown(processCh, doneCh)
+		finishedCh = d.walkDown(ctx, true, walkOptions{}, processCh, doneCh)
 	default:
 		return fmt.Errorf("Invalid action on DAG: %s", action)
 	}
 
+	// create the worker pool
+	for w := int(0); w < numWorkers; w++ {
+		go varsWorker(processCh, doneCh, stackObj, suppress)
+	}
+
 	log.Logger.Debug("Blocking waiting for the DAG to finish processing...")
 
-	for {
-		// Note: Do NOT add a case for doneCh or it'll introduce a race that prevents the DAG from
-		// updating the status of each node
-		select {
-		case err := <-errCh:
-			return errors.Wrapf(err, "Error processing kapp")
-		case <-finishedCh:
-			log.Logger.Infof("Finished processing kapps")
-			return nil
-		}
+	summary := <-finishedCh
+
+	failed := summary.Failed()
+	if len(failed) > 0 {
+		return errors.Wrapf(failed[0].Err, "Error processing kapp '%s'", failed[0].Name)
 	}
+
+	log.Logger.Infof("Finished processing kapps")
+	return nil
 }
 
 // Creates a pool of workers to populate the local registries on installables in the DAG
-func initLocalRegistries(dagObj *Dag, numWorkers int, stackObj interfaces.IStack, action string,
+func initLocalRegistries(ctx context.Context, dagObj *Dag, numWorkers int, stackObj interfaces.IStack, action string,
 	approved bool, dryRun bool) error {
 
 	log.Logger.Debug("Walking down the DAG to initialise local registries")
 
 	// create a new set of channels for the workers
-	processCh := make(chan NamedNode, numWorkers)
-	doneCh := make(chan NamedNode)
-	errCh := make(chan error)
+	processCh := make(chan NamedNode)
+	doneCh := make(chan nodeResult)
+
+	finishedCh := dagObj.walkDown(ctx, true, walkOptions{}, processCh, doneCh)
 
 	for w := int(0); w < numWorkers; w++ {
-		go registryWorker(dagObj, processCh, doneCh, errCh, stackObj, action, approved, dryRun)
+		go registryWorker(ctx, dagObj, processCh, doneCh, stackObj, action, approved, dryRun)
 	}
 
-	finishedCh := dagObj.walkDown(processCh, doneCh)
+	summary := <-finishedCh
 
-	for {
-		// Note: Do NOT add a case for doneCh or it'll introduce a race that prevents the DAG from
-		// updating the status of each node
-		select {
-		case err := <-errCh:
-			return errors.Wrapf(err, "Error processing registry workers")
-		case <-finishedCh:
-			log.Logger.Infof("Finished processing registry workers")
-			return nil
-		}
+	if ctx.Err() != nil {
+		return errors.Wrap(ctx.Err(), "Interrupted while loading outputs")
+	}
+
+	failed := summary.Failed()
+	if len(failed) > 0 {
+		return errors.Wrapf(failed[0].Err, "Error processing registry workers")
 	}
+
+	log.Logger.Infof("Finished processing registry workers")
+	return nil
 }
 
-func registryWorker(dagObj *Dag, processCh <-chan NamedNode, doneCh chan<- NamedNode, errCh chan error,
+// Loads outputs for each node it receives and adds them to the node's local registry
+func registryWorker(ctx context.Context, dagObj *Dag, processCh <-chan NamedNode, doneCh chan<- nodeResult,
 	stackObj interfaces.IStack, action string, approved bool, dryRun bool) {
 
 	for node := range processCh {
-		installableObj := node.installableObj
+		if ctx.Err() != nil {
+			doneCh <- nodeResult{node: node, notStarted: true}
+			continue
+		}
 
-		addParentRegistries(dagObj, node, errCh)
+		err := initLocalRegistry(ctx, dagObj, node, stackObj, action, approved, dryRun)
+		log.Logger.Tracef("Registry worker finished processing kapp '%s' (node=%#v)",
+			node.installableObj.FullyQualifiedId(), node)
+		doneCh <- nodeResult{node: node, err: err}
+	}
+}
 
-		kappRootDir := installableObj.GetCacheDir()
-		log.Logger.Infof("Registry worker received kapp '%s' in %s for processing", installableObj.FullyQualifiedId(), kappRootDir)
+// Loads outputs for a node and merges them with its parents' outputs in its local registry
+func initLocalRegistry(ctx context.Context, dagObj *Dag, node NamedNode, stackObj interfaces.IStack, action string,
+	approved bool, dryRun bool) error {
+	installableObj := node.installableObj
 
-		// todo - print (to stdout) details of the kapp being executed
+	err := addParentRegistries(dagObj, node)
+	if err != nil {
+		return errors.WithStack(err)
+	}
 
-		_, err := os.Stat(kappRootDir)
-		if err != nil {
-			msg := fmt.Sprintf("Kapp '%s' doesn't exist in the cache at '%s'", installableObj.Id(), kappRootDir)
-			log.Logger.Warn(msg)
-			errCh <- errors.Wrap(err, msg)
-			return
-		}
+	kappRootDir := installableObj.GetCacheDir()
+	log.Logger.Infof("Registry worker received kapp '%s' in %s for processing", installableObj.FullyQualifiedId(), kappRootDir)
 
-		// kapp exists, Instantiate an installer in case we need it (for now, this will always be a Make installer)
-		installerImpl, err := installer.New(installer.MAKE, stackObj.GetProvider())
-		if err != nil {
-			errCh <- errors.Wrapf(err, "Error instantiating installer for "+
-				"kapp '%s'", installableObj.Id())
-			return
-		}
+	ctx = withKappOutputStream(ctx, installableObj)
+	ctx = withKappLogs(ctx, installableObj)
 
-		// template the kapp's descriptor, including the global registry
-		templatedVars, err := stackObj.GetTemplatedVars(installableObj,
-			installerImpl.GetVars(action, approved))
-		if err != nil {
-			errCh <- errors.WithStack(err)
-			return
-		}
+	// todo - print (to stdout) details of the kapp being executed
 
-		err = installableObj.TemplateDescriptor(templatedVars)
-		if err != nil {
-			errCh <- errors.WithStack(err)
-			return
-		}
+	installerImpl, err := newInstaller(installableObj, stackObj)
+	if err != nil {
+		return errors.WithStack(err)
+	}
 
-		// try loading outputs, but don't fail if we can't
-		outputs, err := getOutputs(installableObj, stackObj, installerImpl, true, dryRun)
-		if err != nil {
-			errCh <- errors.WithStack(err)
-			return
-		}
+	// template the kapp's descriptor, including the global registry
+	templatedVars, err := stackObj.GetTemplatedVars(installableObj,
+		installerImpl.GetVars(action, approved))
+	if err != nil {
+		return errors.WithStack(err)
+	}
 
-		addInstallableLocalRegistry(node, outputs, errCh)
+	err = installableObj.TemplateDescriptor(templatedVars)
+	if err != nil {
+		return errors.WithStack(err)
+	}
 
-		log.Logger.Tracef("Registry worker finished processing kapp '%s' (node=%#v)", installableObj.FullyQualifiedId(),
-			node)
-		doneCh <- node
-		log.Logger.Tracef("Registry worker end of loop for kapp '%s'", installableObj.FullyQualifiedId())
+	// try loading outputs, but don't fail if we can't
+	outputs, err := loadOutputs(ctx, dagObj, node, installerImpl, stackObj, true, dryRun)
+	if err != nil {
+		return errors.WithStack(err)
 	}
+
+	return addInstallableLocalRegistry(node, outputs)
+}
+
+// Makes sure a kapp exists in the cache and returns an installer for it
+func newInstaller(installableObj interfaces.IInstallable, stackObj interfaces.IStack) (interfaces.IInstaller, error) {
+	kappRootDir := installableObj.GetCacheDir()
+
+	_, err := os.Stat(kappRootDir)
+	if err != nil {
+		msg := fmt.Sprintf("Kapp '%s' doesn't exist in the cache at '%s'", installableObj.Id(), kappRootDir)
+		log.Logger.Warn(msg)
+		return nil, errors.Wrap(err, msg)
+	}
+
+	// kapp exists, Instantiate the installer it's configured to use in case we need it
+	installerImpl, err := installer.New(installableObj, stackObj.GetProvider())
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error instantiating installer for "+
+			"kapp '%s'", installableObj.Id())
+	}
+
+	return installerImpl, nil
 }
 
-// Processes an installable, either installing/deleting it, running post actions or
-// loading its outputs, etc.
-func worker(dagObj *Dag, processCh <-chan NamedNode, doneCh chan<- NamedNode, errCh chan error,
-	action string, stackObj interfaces.IStack, plan bool, approved bool, skipPreActions bool, skipPostActions bool,
-	ignoreErrors bool, dryRun bool) {
+// Processes installables, either installing/deleting them, running post actions or
+// loading their outputs, etc.
+func worker(ctx context.Context, dagObj *Dag, processCh <-chan NamedNode, doneCh chan<- nodeResult,
+	action string, stackObj interfaces.IStack, plan bool, approved bool, skipPreActions bool,
+	skipPostActions bool, ignoreErrors bool, dryRun bool) {
 
 	for node := range processCh {
-		installableObj := node.installableObj
+		// nodes may have been queued before the DAG was interrupted
+		if ctx.Err() != nil {
+			doneCh <- nodeResult{node: node, notStarted: true}
+			continue
+		}
 
-		addParentRegistries(dagObj, node, errCh)
+		err := processNode(ctx, dagObj, node, action, stackObj, plan, approved, skipPreActions, skipPostActions,
+			ignoreErrors, dryRun)
+		log.Logger.Tracef("Worker finished processing kapp '%s' (node=%#v)",
+			node.installableObj.FullyQualifiedId(), node)
+		doneCh <- nodeResult{node: node, err: err}
+	}
+}
 
-		kappRootDir := installableObj.GetCacheDir()
-		log.Logger.Infof("Worker received kapp '%s' in %s for processing", installableObj.FullyQualifiedId(), kappRootDir)
+// Returns a context that streams the output of commands run for the installable to stdout, prefixed
+// with its fully-qualified ID, unless streaming has been disabled in the config
+func withKappOutputStream(ctx context.Context, installableObj interfaces.IInstallable) context.Context {
+	if config.CurrentConfig == nil || !config.CurrentConfig.StreamOutput {
+		return ctx
+	}
 
-		// todo - print (to stdout) details of the kapp being executed
+	return utils.WithOutputStream(ctx, os.Stdout, installableObj.FullyQualifiedId(),
+		config.CurrentConfig.ColourOutput)
+}
 
-		_, err := os.Stat(kappRootDir)
-		if err != nil {
-			msg := fmt.Sprintf("Kapp '%s' doesn't exist in the cache at '%s'", installableObj.Id(), kappRootDir)
-			log.Logger.Warn(msg)
-			errCh <- errors.Wrap(err, msg)
-			return
+// Processes a single installable according to the action
+func processNode(ctx context.Context, dagObj *Dag, node NamedNode, action string, stackObj interfaces.IStack, plan bool,
+	approved bool, skipPreActions bool, skipPostActions bool, ignoreErrors bool, dryRun bool) error {
+	installableObj := node.installableObj
+
+	// when applying, kapps are installed or deleted depending on their state
+	action = nodeAction(action, node)
+
+	err := addParentRegistries(dagObj, node)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	kappRootDir := installableObj.GetCacheDir()
+	log.Logger.Infof("Worker received kapp '%s' in %s for processing", installableObj.FullyQualifiedId(), kappRootDir)
+
+	ctx = withKappOutputStream(ctx, installableObj)
+	ctx = withKappLogs(ctx, installableObj)
+
+	// todo - print (to stdout) details of the kapp being executed
+
+	installerImpl, err := newInstaller(installableObj, stackObj)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	switch action {
+	case constants.DagActionInstall:
+		journal := dagObj.Journal
+		var hash string
+
+		// only approved runs actually install kapps so there's no point journalling anything else
+		if journal != nil && approved {
+			hash, err = inputsHash(installableObj, stackObj, installerImpl.GetVars(action, approved))
+			if err != nil {
+				return errors.WithStack(err)
+			}
+
+			entry, ok := journal.lookup(node, hash)
+			if ok {
+				log.Logger.Infof("Kapp '%s' completed at %s with identical inputs. Loading its outputs "+
+					"from the journal instead of processing it", installableObj.FullyQualifiedId(),
+					entry.CompletedAt)
+				return resumeFromJournal(node, entry, installerImpl, stackObj, skipPostActions, dryRun)
+			}
 		}
 
-		// kapp exists, Instantiate an installer in case we need it (for now, this will always be a Make installer)
-		installerImpl, err := installer.New(installer.MAKE, stackObj.GetProvider())
+		outputs, succeeded, err := installOrDelete(ctx, dagObj, true, node, installerImpl, stackObj, plan, approved,
+			skipPreActions, skipPostActions, ignoreErrors, dryRun)
 		if err != nil {
-			errCh <- errors.Wrapf(err, "Error instantiating installer for "+
-				"kapp '%s'", installableObj.Id())
-			return
-		}
-
-		switch action {
-		case constants.DagActionInstall:
-			installOrDelete(true, dagObj, node, installerImpl, stackObj, plan, approved, skipPreActions,
-				skipPostActions, ignoreErrors, dryRun, errCh)
-		case constants.DagActionDelete:
-			installOrDelete(false, dagObj, node, installerImpl, stackObj, plan, approved, skipPreActions,
-				skipPostActions, ignoreErrors, dryRun, errCh)
-		case constants.DagActionClean:
-			if node.marked {
-				// template the kapp's descriptor, including the global registry
-				templatedVars, err := stackObj.GetTemplatedVars(installableObj,
-					installerImpl.GetVars(action, approved))
-				err = installableObj.TemplateDescriptor(templatedVars)
-				if err != nil {
-					errCh <- errors.WithStack(err)
-					return
-				}
+			return errors.WithStack(err)
+		}
 
-				err = installerImpl.Clean(installableObj, stackObj, dryRun)
-				if err != nil {
-					errCh <- errors.Wrapf(err, "Error cleaning kapp '%s'", installableObj.Id())
-					return
-				}
+		// kapps whose errors were ignored weren't installed so must be processed again when resuming
+		if journal != nil && approved && !dryRun && succeeded {
+			return journal.record(node, hash, outputs)
+		}
+	case constants.DagActionDelete:
+		_, _, err = installOrDelete(ctx, dagObj, false, node, installerImpl, stackObj, plan, approved, skipPreActions,
+			skipPostActions, ignoreErrors, dryRun)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	case constants.DagActionClean:
+		if node.marked {
+			// template the kapp's descriptor, including the global registry
+			templatedVars, err := stackObj.GetTemplatedVars(installableObj,
+				installerImpl.GetVars(action, approved))
+			if err != nil {
+				return errors.WithStack(err)
 			}
-		case constants.DagActionOutput:
-			if node.marked {
-				// template the kapp's descriptor, including the global registry
-				templatedVars, err := stackObj.GetTemplatedVars(installableObj,
-					installerImpl.GetVars(action, approved))
-				err = installableObj.TemplateDescriptor(templatedVars)
-				if err != nil {
-					errCh <- errors.WithStack(err)
-					return
-				}
 
-				err = installerImpl.Output(installableObj, stackObj, dryRun)
-				if err != nil {
-					errCh <- errors.Wrapf(err, "Error generating output for kapp '%s'", installableObj.Id())
-					return
-				}
-			}
-		case constants.DagActionTemplate:
-			// Template nodes before trying to get the output in case getting the output relies on templated
-			// files, e.g. terraform backends
-			installerVars := installerImpl.GetVars(action, approved)
-			if node.marked {
-				err = renderKappTemplates(stackObj, installableObj, installerVars, dryRun)
-				if err != nil {
-					if ignoreErrors {
-						log.Logger.Warnf("Ignoring error templating kapp: %#v", err)
-						doneCh <- node
-					} else {
-						errCh <- errors.WithStack(err)
-					}
-					return
-				}
+			err = installableObj.TemplateDescriptor(templatedVars)
+			if err != nil {
+				return errors.WithStack(err)
 			}
 
+			err = installerImpl.Clean(withTargetLog(ctx, installer.TargetClean), installableObj, stackObj, dryRun)
+			if err != nil {
+				return errors.Wrapf(err, "Error cleaning kapp '%s'", installableObj.Id())
+			}
+		}
+	case constants.DagActionOutput:
+		if node.marked {
 			// template the kapp's descriptor, including the global registry
 			templatedVars, err := stackObj.GetTemplatedVars(installableObj,
 				installerImpl.GetVars(action, approved))
+			if err != nil {
+				return errors.WithStack(err)
+			}
+
 			err = installableObj.TemplateDescriptor(templatedVars)
 			if err != nil {
-				errCh <- errors.WithStack(err)
-				return
+				return errors.WithStack(err)
 			}
 
-			// try loading outputs, but don't fail if we can't
-			outputs, err := getOutputs(installableObj, stackObj, installerImpl, true, dryRun)
+			err = withRetries(ctx, installableObj, installer.TargetOutput, dryRun, func() error {
+				return installerImpl.Output(withTargetLog(ctx, installer.TargetOutput), installableObj, stackObj,
+					dryRun)
+			})
+			if err != nil {
+				return errors.Wrapf(err, "Error generating output for kapp '%s'", installableObj.Id())
+			}
+		}
+	case constants.DagActionTemplate:
+		// Template nodes before trying to get the output in case getting the output relies on templated
+		// files, e.g. terraform backends
+		installerVars := installerImpl.GetVars(action, approved)
+		if node.marked {
+			err = installable.RenderKappTemplates(stackObj, installableObj, installerVars, dryRun)
 			if err != nil {
 				if ignoreErrors {
-					log.Logger.Warnf("Ignoring error getting outputs: %#v", err)
-					doneCh <- node
-				} else {
-					errCh <- errors.WithStack(err)
+					log.Logger.Warnf("Ignoring error templating kapp: %#v", err)
+					return nil
 				}
-				return
+				return errors.WithStack(err)
 			}
+		}
 
-			addInstallableLocalRegistry(node, outputs, errCh)
-
-			// only template marked nodes
-			if node.marked {
-				err = renderKappTemplates(stackObj, installableObj, installerVars, dryRun)
-				if err != nil {
-					if ignoreErrors {
-						log.Logger.Warnf("Ignoring error templating kapp: %#v", err)
-						doneCh <- node
-					} else {
-						errCh <- errors.WithStack(err)
-					}
-					return
-				}
+		// template the kapp's descriptor, including the global registry
+		templatedVars, err := stackObj.GetTemplatedVars(installableObj,
+			installerImpl.GetVars(action, approved))
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		err = installableObj.TemplateDescriptor(templatedVars)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		// try loading outputs, but don't fail if we can't
+		outputs, err := loadOutputs(ctx, dagObj, node, installerImpl, stackObj, true, dryRun)
+		if err != nil {
+			if ignoreErrors {
+				log.Logger.Warnf("Ignoring error getting outputs: %#v", err)
+				return nil
 			}
+			return errors.WithStack(err)
 		}
 
-		log.Logger.Tracef("Worker finished processing kapp '%s' (node=%#v)", installableObj.FullyQualifiedId(),
-			node)
-		doneCh <- node
-		log.Logger.Tracef("Worker end of loop for kapp '%s'", installableObj.FullyQualifiedId())
+		err = addInstallableLocalRegistry(node, outputs)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		// only template marked nodes
+		if node.marked {
+			err = installable.RenderKappTemplates(stackObj, installableObj, installerVars, dryRun)
+			if err != nil {
+				if ignoreErrors {
+					log.Logger.Warnf("Ignoring error templating kapp: %#v", err)
+					return nil
+				}
+				return errors.WithStack(err)
+			}
+		}
 	}
+
+	return nil
 }
 
 // Prints out the variables for each marked node
-func varsWorker(processCh <-chan NamedNode, doneCh chan<- NamedNode, errCh chan error, stackObj interfaces.IStack,
+func varsWorker(processCh <-chan NamedNode, doneCh chan<- nodeResult, stackObj interfaces.IStack,
 	suppress []string) {
 
 	for node := range processCh {
-		installableObj := node.installableObj
+		err := printVars(node, stackObj, suppress)
+		log.Logger.Tracef("Vars worker finished processing kapp '%s' (node=%#v)",
+			node.installableObj.FullyQualifiedId(), node)
+		doneCh <- nodeResult{node: node, err: err}
+	}
+}
 
-		if !node.marked {
-			log.Logger.Debugf("Not printing variables for unmarked node: '%s'", installableObj.FullyQualifiedId())
-			doneCh <- node
-			continue
-		}
+// Prints the variables and templated config for a node if it's marked
+func printVars(node NamedNode, stackObj interfaces.IStack, suppress []string) error {
+	installableObj := node.installableObj
 
-		kappRootDir := installableObj.GetCacheDir()
-		log.Logger.Infof("Worker received kapp '%s' in %s for processing", installableObj.FullyQualifiedId(), kappRootDir)
+	if !node.marked {
+		log.Logger.Debugf("Not printing variables for unmarked node: '%s'", installableObj.FullyQualifiedId())
+		return nil
+	}
 
-		// todo - print (to stdout) details of the kapp being executed
+	kappRootDir := installableObj.GetCacheDir()
+	log.Logger.Infof("Worker received kapp '%s' in %s for processing", installableObj.FullyQualifiedId(), kappRootDir)
 
-		_, err := os.Stat(kappRootDir)
-		if err != nil {
-			msg := fmt.Sprintf("Kapp '%s' doesn't exist in the cache at '%s'", installableObj.Id(), kappRootDir)
-			log.Logger.Warn(msg)
-			errCh <- errors.Wrap(err, msg)
-			return
-		}
+	// todo - print (to stdout) details of the kapp being executed
 
-		// kapp exists, Instantiate an installer in case we need it (for now, this will always be a Make installer)
-		installerImpl, err := installer.New(installer.MAKE, stackObj.GetProvider())
-		if err != nil {
-			errCh <- errors.Wrapf(err, "Error instantiating installer for "+
-				"kapp '%s'", installableObj.Id())
-			return
-		}
+	installerImpl, err := newInstaller(installableObj, stackObj)
+	if err != nil {
+		return errors.WithStack(err)
+	}
 
-		log.Logger.Debugf("Getting variables for kapp '%s'", installableObj.FullyQualifiedId())
+	log.Logger.Debugf("Getting variables for kapp '%s'", installableObj.FullyQualifiedId())
 
-		// template the kapp's descriptor, including the global registry
-		templatedVars, err := stackObj.GetTemplatedVars(installableObj,
-			installerImpl.GetVars("<action, e.g. install/delete>", false))
-
-		if len(suppress) > 0 {
-			for _, exclusion := range suppress {
-				// trim any leading zeroes for compatibility with how variables are referred to in templates
-				exclusion = strings.TrimPrefix(exclusion, ".")
-				blanked := utils.BlankNestedMap(map[string]interface{}{}, strings.Split(exclusion, "."))
-				log.Logger.Debugf("blanked=%#v", blanked)
-
-				err = mergo.Merge(&templatedVars, blanked, mergo.WithOverride)
-				if err != nil {
-					errCh <- errors.WithStack(err)
-					return
-				}
-			}
-		}
+	// template the kapp's descriptor, including the global registry
+	templatedVars, err := stackObj.GetTemplatedVars(installableObj,
+		installerImpl.GetVars("<action, e.g. install/delete>", false))
+	if err != nil {
+		return errors.WithStack(err)
+	}
 
-		yamlData, err := yaml.Marshal(&templatedVars)
-		if err != nil {
-			errCh <- errors.WithStack(err)
-			return
-		}
+	if len(suppress) > 0 {
+		for _, exclusion := range suppress {
+			// trim any leading zeroes for compatibility with how variables are referred to in templates
+			exclusion = strings.TrimPrefix(exclusion, ".")
+			blanked := utils.BlankNestedMap(map[string]interface{}{}, strings.Split(exclusion, "."))
+			log.Logger.Debugf("blanked=%#v", blanked)
 
-		_, err = fmt.Printf("\n***** Start variables for kapp '%s' *****\n"+
-			"%s***** End variables for kapp '%s' *****\n",
-			installableObj.FullyQualifiedId(), yamlData, installableObj.FullyQualifiedId())
-		if err != nil {
-			errCh <- errors.WithStack(err)
-			return
+			err = mergo.Merge(&templatedVars, blanked, mergo.WithOverride)
+			if err != nil {
+				return errors.WithStack(err)
+			}
 		}
+	}
 
-		err = installableObj.TemplateDescriptor(templatedVars)
-		if err != nil {
-			errCh <- errors.WithStack(err)
-			return
-		}
+	yamlData, err := yaml.Marshal(&templatedVars)
+	if err != nil {
+		return errors.WithStack(err)
+	}
 
-		kappConfig, err := yaml.Marshal(installableObj.GetDescriptor())
-		if err != nil {
-			errCh <- errors.WithStack(err)
-			return
-		}
+	_, err = fmt.Printf("\n***** Start variables for kapp '%s' *****\n"+
+		"%s***** End variables for kapp '%s' *****\n",
+		installableObj.FullyQualifiedId(), yamlData, installableObj.FullyQualifiedId())
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	err = installableObj.TemplateDescriptor(templatedVars)
+	if err != nil {
+		return errors.WithStack(err)
+	}
 
-		_, err = fmt.Printf("\n***** Start config for kapp '%s' *****\n"+
-			"%s***** End config for kapp '%s' *****\n",
-			installableObj.FullyQualifiedId(), kappConfig, installableObj.FullyQualifiedId())
+	kappConfig, err := yaml.Marshal(installableObj.GetDescriptor())
+	if err != nil {
+		return errors.WithStack(err)
+	}
 
-		log.Logger.Tracef("Vars worker finished processing kapp '%s' (node=%#v)", installableObj.FullyQualifiedId(),
-			node)
-		doneCh <- node
-		log.Logger.Tracef("Vars worker end of loop for kapp '%s'", installableObj.FullyQualifiedId())
+	_, err = fmt.Printf("\n***** Start config for kapp '%s' *****\n"+
+		"%s***** End config for kapp '%s' *****\n",
+		installableObj.FullyQualifiedId(), kappConfig, installableObj.FullyQualifiedId())
+	if err != nil {
+		return errors.WithStack(err)
 	}
+
+	return nil
 }
 
 // Implements the install action. Nodes that should be processed are installed. All nodes load any outputs
-// and merge them with their parents' outputs.
-func installOrDelete(install bool, dagObj *Dag, node NamedNode, installerImpl interfaces.IInstaller,
+// and merge them with their parents' outputs. Any outputs that were loaded are returned, along with
+// whether the node was processed successfully (i.e. false if an error was ignored).
+func installOrDelete(ctx context.Context, dagObj *Dag, install bool, node NamedNode, installerImpl interfaces.IInstaller,
 	stackObj interfaces.IStack, plan bool, approved bool, skipPreActions bool, skipPostActions bool, ignoreErrors bool,
-	dryRun bool, errCh chan error) {
+	dryRun bool) (map[string]interface{}, bool, error) {
 
 	installableObj := node.installableObj
 
 	actionName := "install"
+	target := installer.TargetInstall
 	installerMethod := installerImpl.Install
 	preActions := installableObj.PreInstallActions()
 	postActions := installableObj.PostInstallActions()
 
 	if !install {
 		actionName = "delete"
+		target = installer.TargetDelete
 		installerMethod = installerImpl.Delete
 		preActions = installableObj.PreDeleteActions()
 		postActions = installableObj.PostDeleteActions()
@@ -477,24 +715,33 @@ func installOrDelete(install bool, dagObj *Dag, node NamedNode, installerImpl in
 	installerVars := installerImpl.GetVars(actionName, approved)
 
 	// render templates in case any are used as outputs for some reason
-	err := renderKappTemplates(stackObj, installableObj, installerVars, dryRun)
+	err := installable.RenderKappTemplates(stackObj, installableObj, installerVars, dryRun)
 	if err != nil {
-		errCh <- errors.WithStack(err)
-		return
+		return nil, false, errors.WithStack(err)
+	}
+
+	// kapps that haven't changed since they were last installed don't need installing again
+	var newFingerprint string
+	unchanged := false
+	if install && node.marked && approved && !dryRun && dagObj.Fingerprints != nil {
+		newFingerprint, unchanged = dagObj.Fingerprints.check(node, stackObj, installerVars)
 	}
 
+	installed := false
+
 	// only plan or process kapps that have been flagged for processing
-	if node.marked {
+	if node.marked && !unchanged {
 		if plan {
-			err = installerMethod(installableObj, stackObj, false, dryRun)
+			err = withRetries(ctx, installableObj, target, dryRun, func() error {
+				return installerMethod(withTargetLog(ctx, target), installableObj, stackObj, false, dryRun)
+			})
 			if err != nil {
 				if ignoreErrors {
 					log.Logger.Warnf("Ignoring error planning kapp '%s': %#v",
 						installableObj.FullyQualifiedId(), err)
-					return
+					return nil, false, nil
 				}
-				errCh <- errors.Wrapf(err, "Error planning kapp '%s'", installableObj.Id())
-				return
+				return nil, false, errors.Wrapf(err, "Error planning kapp '%s'", installableObj.Id())
 			}
 		}
 
@@ -511,22 +758,27 @@ func installOrDelete(install bool, dagObj *Dag, node NamedNode, installerImpl in
 						actionName, installableObj.FullyQualifiedId())
 					skipInstallerMethod = true
 				default:
-					executeAction(action, installableObj, stackObj, errCh, dryRun)
+					err = executeAction(action, installableObj, stackObj, dryRun)
+					if err != nil {
+						return nil, false, errors.WithStack(err)
+					}
 				}
 			}
 		}
 
 		if approved && !skipInstallerMethod {
-			err = installerMethod(installableObj, stackObj, approved, dryRun)
+			err = withRetries(ctx, installableObj, target, dryRun, func() error {
+				return installerMethod(withTargetLog(ctx, target), installableObj, stackObj, approved, dryRun)
+			})
 			if err != nil {
 				if ignoreErrors {
 					log.Logger.Warnf("Ignoring error processing kapp '%s': %#v",
 						installableObj.FullyQualifiedId(), err)
-					return
+					return nil, false, nil
 				}
-				errCh <- errors.Wrapf(err, "Error processing kapp '%s'", installableObj.Id())
-				return
+				return nil, false, errors.Wrapf(err, "Error processing kapp '%s'", installableObj.Id())
 			}
+			installed = true
 		}
 	}
 
@@ -536,21 +788,22 @@ func installOrDelete(install bool, dagObj *Dag, node NamedNode, installerImpl in
 	var outputs map[string]interface{}
 	if install && approved {
 		// fail if outputs don't exist
-		outputs, err = getOutputs(installableObj, stackObj, installerImpl, false, dryRun)
+		outputs, err = loadOutputs(ctx, dagObj, node, installerImpl, stackObj, false, dryRun)
 		if err != nil {
-			errCh <- errors.WithStack(err)
-			return
+			return nil, false, errors.WithStack(err)
 		}
 	}
 
 	// build the kapp's local registry
-	addInstallableLocalRegistry(node, outputs, errCh)
+	err = addInstallableLocalRegistry(node, outputs)
+	if err != nil {
+		return nil, false, errors.WithStack(err)
+	}
 
 	// rerender templates so they can use kapp outputs (e.g. before adding the paths to rendered templates as provider vars)
-	err = renderKappTemplates(stackObj, installableObj, installerVars, dryRun)
+	err = installable.RenderKappTemplates(stackObj, installableObj, installerVars, dryRun)
 	if err != nil {
-		errCh <- errors.WithStack(err)
-		return
+		return nil, false, errors.WithStack(err)
 	}
 
 	// only execute post actions if approved==true
@@ -558,20 +811,109 @@ func installOrDelete(install bool, dagObj *Dag, node NamedNode, installerImpl in
 		log.Logger.Infof("Will run %d post %s actions", len(postActions), actionName)
 
 		for _, action := range postActions {
-			executeAction(action, installableObj, stackObj, errCh, dryRun)
+			// other post actions were run when unchanged kapps were installed, but provider vars
+			// only live in memory so need adding again for descendants to use them
+			if unchanged && action.Id != constants.ActionAddProviderVarsFiles {
+				continue
+			}
+
+			err = executeAction(action, installableObj, stackObj, dryRun)
+			if err != nil {
+				return nil, false, errors.WithStack(err)
+			}
+		}
+	}
+
+	// the worst that can happen if this fails is that the kapp is installed again next time
+	if installed && newFingerprint != "" {
+		err = dagObj.Fingerprints.record(node, newFingerprint)
+		if err != nil {
+			log.Logger.Warnf("Error recording the fingerprint of kapp '%s': %v",
+				installableObj.FullyQualifiedId(), err)
 		}
 	}
+
+	return outputs, true, nil
+}
+
+// Restores the state of a node that completed in a previous run from its journal entry instead of
+// processing it again
+func resumeFromJournal(node NamedNode, entry journalEntry, installerImpl interfaces.IInstaller,
+	stackObj interfaces.IStack, skipPostActions bool, dryRun bool) error {
+	installableObj := node.installableObj
+
+	err := addInstallableLocalRegistry(node, entry.Outputs)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	installerVars := installerImpl.GetVars(constants.DagActionInstall, true)
+	err = installable.RenderKappTemplates(stackObj, installableObj, installerVars, dryRun)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	// other post actions would already have been run, but provider vars only live in memory so
+	// need adding again for descendants to use them
+	if node.marked && !skipPostActions {
+		for _, action := range installableObj.PostInstallActions() {
+			if action.Id != constants.ActionAddProviderVarsFiles {
+				continue
+			}
+
+			err = executeAction(action, installableObj, stackObj, dryRun)
+			if err != nil {
+				return errors.WithStack(err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Loads the outputs of a node. If only marked nodes should be processed, outputs for unmarked nodes
+// are taken from the output cache instead of running the kapp. Otherwise outputs are generated by
+// the kapp and cached for later runs.
+func loadOutputs(ctx context.Context, dagObj *Dag, node NamedNode, installerImpl interfaces.IInstaller, stackObj interfaces.IStack,
+	ignoreMissing bool, dryRun bool) (map[string]interface{}, error) {
+	outputCache := dagObj.Outputs
+
+	if dagObj.OnlyMarked && !node.marked {
+		if outputCache == nil {
+			log.Logger.Infof("Not loading outputs for unmarked kapp '%s'", node.name)
+			return nil, nil
+		}
+
+		return outputCache.lookup(node), nil
+	}
+
+	outputs, err := getOutputs(ctx, node.installableObj, stackObj, installerImpl, ignoreMissing, dryRun)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if outputCache != nil && !dryRun {
+		err = outputCache.record(node, outputs)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+
+	return outputs, nil
 }
 
 // Makes a kapp generate its output then loads and returns them
-func getOutputs(installableObj interfaces.IInstallable, stackObj interfaces.IStack,
+func getOutputs(ctx context.Context, installableObj interfaces.IInstallable, stackObj interfaces.IStack,
 	installerImpl interfaces.IInstaller, ignoreMissing bool, dryRun bool) (map[string]interface{}, error) {
 	var outputs map[string]interface{}
 
 	// try to load kapp outputs and fail if we can't (assume we only need to do this when installing)
 	if installableObj.HasOutputs() {
 		// run the output target to write outputs to files
-		err := installerImpl.Output(installableObj, stackObj, dryRun)
+		err := withRetries(ctx, installableObj, installer.TargetOutput, dryRun, func() error {
+			return installerImpl.Output(withTargetLog(ctx, installer.TargetOutput), installableObj, stackObj,
+				dryRun)
+		})
 		if err != nil {
 			return nil, errors.Wrapf(err, "Error writing output for kapp '%s'", installableObj.Id())
 		}
@@ -590,7 +932,7 @@ func getOutputs(installableObj interfaces.IInstallable, stackObj interfaces.ISta
 // parent's manifest ID is different to the current node's manifest ID registry keys for
 // non fully-qualified installable IDs will be deleted from the registry before merging. In
 // all cases the special value 'this' will not be merged either.
-func addParentRegistries(dagObj *Dag, node NamedNode, errCh chan<- error) {
+func addParentRegistries(dagObj *Dag, node NamedNode) error {
 	localRegistry := registry.New()
 
 	// clear any default values from the registry before using it
@@ -603,6 +945,13 @@ func addParentRegistries(dagObj *Dag, node NamedNode, errCh chan<- error) {
 	for parents.Next() {
 		parent := parents.Node().(NamedNode)
 
+		// kapps that only need to run after their parent don't get its outputs
+		if edgeType(dagObj.graph.Edge(parent.ID(), node.ID())) == constants.EdgeTypeRunsAfter {
+			log.Logger.Debugf("Not adding outputs of '%s' to the registry of '%s' because it only "+
+				"runs after it", parent.name, node.name)
+			continue
+		}
+
 		parentRegistry := parent.installableObj.GetLocalRegistry()
 
 		// if may not be set, e.g. if we ignored errors while creating the cache
@@ -613,8 +962,7 @@ func addParentRegistries(dagObj *Dag, node NamedNode, errCh chan<- error) {
 		for k, v := range parentRegistry.AsMap() {
 			err := localRegistry.Set(k, v)
 			if err != nil {
-				errCh <- errors.WithStack(err)
-				return
+				return errors.WithStack(err)
 			}
 		}
 
@@ -629,43 +977,42 @@ func addParentRegistries(dagObj *Dag, node NamedNode, errCh chan<- error) {
 	}
 
 	node.installableObj.SetLocalRegistry(localRegistry)
+	return nil
 }
 
 // Add outputs to the kapp's local registry
-func addInstallableLocalRegistry(node NamedNode, outputs map[string]interface{}, errCh chan<- error) {
+func addInstallableLocalRegistry(node NamedNode, outputs map[string]interface{}) error {
 
 	localRegistry := node.installableObj.GetLocalRegistry()
 
 	// only add outputs if any were passed in
 	if outputs != nil && len(outputs) > 0 {
-		err := addOutputsToRegistry(node.installableObj, outputs, localRegistry)
+		err := installable.AddOutputsToRegistry(node.installableObj, outputs, localRegistry)
 		if err != nil {
-			errCh <- errors.WithStack(err)
-			return
+			return errors.WithStack(err)
 		}
 	}
 
 	node.installableObj.SetLocalRegistry(localRegistry)
+	return nil
 }
 
-// Executes post actions
+// Executes pre/post actions
 func executeAction(action structs.Action, installableObj interfaces.IInstallable,
-	stackObj interfaces.IStack, errCh chan error, dryRun bool) {
+	stackObj interfaces.IStack, dryRun bool) error {
 	log.Logger.Infof("Executing action '%s' for installable '%s'", action, installableObj.FullyQualifiedId())
 	switch action.Id {
 	case constants.ActionClusterUpdate:
 		err := cluster.UpdateCluster(os.Stdout, stackObj, true, dryRun)
 		if err != nil {
-			errCh <- errors.Wrapf(err, "Error updating cluster, triggered by kapp '%s'",
+			return errors.Wrapf(err, "Error updating cluster, triggered by kapp '%s'",
 				installableObj.Id())
-			return
 		}
 	case constants.ActionClusterDelete:
 		err := stackObj.GetProvisioner().Delete(true, dryRun)
 		if err != nil {
-			errCh <- errors.Wrapf(err, "Error deleting cluster, triggered by kapp '%s'",
+			return errors.Wrapf(err, "Error deleting cluster, triggered by kapp '%s'",
 				installableObj.Id())
-			return
 		}
 	case constants.ActionAddProviderVarsFiles:
 		// todo - run each path through the templater
@@ -682,10 +1029,11 @@ func executeAction(action structs.Action, installableObj interfaces.IInstallable
 		// refresh the provider vars so the extra vars files we've just added are loaded
 		err := stackObj.RefreshProviderVars()
 		if err != nil {
-			errCh <- errors.WithStack(err)
-			return
+			return errors.WithStack(err)
 		}
 	}
+
+	return nil
 }
 
 // Deletes all outputs from the registry that aren't fully qualified
@@ -710,94 +1058,3 @@ func deleteSpecialThisOutput(registry interfaces.IRegistry) {
 	registry.Delete(strings.Join([]string{constants.RegistryKeyOutputs,
 		constants.RegistryKeyThis}, constants.RegistryFieldSeparator))
 }
-
-// Adds output from an installable to the registry
-func addOutputsToRegistry(installableObj interfaces.IInstallable, outputs map[string]interface{},
-	registry interfaces.IRegistry) error {
-
-	// We convert kapp IDs to have underscores because Go's templating library throws its toys out
-	// the pram when it find a map key with a hyphen in. K8s is the opposite, so this seems like
-	// the least worst way of accommodating both
-	underscoredInstallableId := strings.Replace(installableObj.Id(), "-", "_", -1)
-	underscoredInstallableFQId := strings.Replace(installableObj.FullyQualifiedId(), "-", "_", -1)
-	underscoredInstallableFQId = strings.Replace(underscoredInstallableFQId, constants.NamespaceSeparator,
-		constants.TemplateNamespaceSeparator, -1)
-
-	prefixes := []string{
-		// "outputs.this"
-		strings.Join([]string{constants.RegistryKeyOutputs, constants.RegistryKeyThis}, constants.RegistryFieldSeparator),
-		// short prefix - can be used by other kapps in the manifest
-		strings.Join([]string{constants.RegistryKeyOutputs, underscoredInstallableId},
-			constants.RegistryFieldSeparator),
-		// fully-qualified prefix - can be used by kapps in other manifests
-		strings.Join([]string{constants.RegistryKeyOutputs,
-			underscoredInstallableFQId}, constants.RegistryFieldSeparator),
-	}
-
-	// store the output under various keys
-	for outputId, output := range outputs {
-		for _, prefix := range prefixes {
-			underscoredOutputId := strings.Replace(outputId, "-", "_", -1)
-			key := strings.Join([]string{prefix, underscoredOutputId}, constants.RegistryFieldSeparator)
-			err := registry.Set(key, output)
-			if err != nil {
-				return errors.WithStack(err)
-			}
-		}
-	}
-
-	return nil
-}
-
-// Renders templates for a kapp
-func renderKappTemplates(stackObj interfaces.IStack, installableObj interfaces.IInstallable,
-	installerVars map[string]interface{}, dryRun bool) error {
-
-	// merge all the vars required to render the kapp's sugarkube.yaml file
-	templatedVars, err := stackObj.GetTemplatedVars(installableObj, installerVars)
-	if err != nil {
-		return errors.WithStack(err)
-	}
-
-	// template the descriptor again in case variables refer to outputs
-	err = installableObj.TemplateDescriptor(templatedVars)
-	if err != nil {
-		return errors.WithStack(err)
-	}
-
-	// get the updated template vars
-	templatedVars, err = stackObj.GetTemplatedVars(installableObj, installerVars)
-	if err != nil {
-		return errors.WithStack(err)
-	}
-
-	renderedTemplatePaths, err := installableObj.RenderTemplates(templatedVars, stackObj.GetConfig(),
-		dryRun)
-	if err != nil {
-		return errors.WithStack(err)
-	}
-
-	// merge renderedTemplates into the templatedVars under the "kapp.templates" key. This will
-	// allow us to support writing files to temporary (dynamic) locations later if we like
-	renderedTemplatesMap := map[string]interface{}{
-		constants.KappVarsKappKey: map[string]interface{}{
-			constants.KappVarsTemplatesKey: renderedTemplatePaths,
-		},
-	}
-
-	log.Logger.Debugf("Merging rendered template paths into stack config: %#v",
-		renderedTemplatePaths)
-
-	err = mergo.Merge(&templatedVars, renderedTemplatesMap, mergo.WithOverride)
-	if err != nil {
-		return errors.WithStack(err)
-	}
-
-	// remerge and template the kapp's descriptor so it can access the paths of any rendered templates
-	err = installableObj.TemplateDescriptor(templatedVars)
-	if err != nil {
-		return errors.WithStack(err)
-	}
-
-	return nil
-}
@@ -0,0 +1,327 @@
+/*
+ * Copyright 2019 The Sugarkube Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package plan
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/sugarkube/sugarkube/internal/pkg/constants"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// A serialisable representation of a DAG
+type Graph struct {
+	Nodes        []GraphNode    `json:"nodes"`
+	Edges        []GraphEdge    `json:"edges"`
+	CriticalPath []string       `json:"critical_path,omitempty"` // IDs of nodes on the expected critical path
+	EtaSeconds   float64        `json:"eta_seconds,omitempty"`   // how long installing marked kapps should take
+	Excluded     []ExcludedKapp `json:"excluded,omitempty"`      // kapps left out because their conditions were false
+}
+
+type GraphNode struct {
+	Id              string   `json:"id"` // fully-qualified kapp ID
+	Manifest        string   `json:"manifest"`
+	State           string   `json:"state"`
+	Marked          bool     `json:"marked"` // whether the node will be processed
+	Sources         []string `json:"sources"`
+	ExpectedSeconds float64  `json:"expected_seconds,omitempty"` // based on durations of previous runs
+	Critical        bool     `json:"critical,omitempty"`         // whether the node is on the critical path
+}
+
+type GraphEdge struct {
+	From string `json:"from"` // the parent
+	To   string `json:"to"`   // the child
+	Type string `json:"type"` // one of the EdgeType* constants
+}
+
+// Returns a serialisable representation of the DAG. Nodes and edges are sorted by ID so the
+// output is stable across runs. If durations have been recorded by previous runs the expected
+// critical path when installing the marked nodes is included.
+func (g *Dag) Export() (*Graph, error) {
+	graphObj := &Graph{
+		Nodes: make([]GraphNode, 0),
+		Edges: make([]GraphEdge, 0),
+	}
+
+	scheduleObj, err := g.schedule(constants.DagActionInstall)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	critical := make(map[int64]bool, 0)
+	if scheduleObj != nil {
+		graphObj.EtaSeconds = scheduleObj.eta.Seconds()
+		for _, node := range scheduleObj.criticalPath {
+			graphObj.CriticalPath = append(graphObj.CriticalPath, node.name)
+			critical[node.ID()] = true
+		}
+	}
+
+	nodes := g.graph.Nodes()
+	for nodes.Next() {
+		node := nodes.Node().(NamedNode)
+
+		graphNode := GraphNode{
+			Id:      node.name,
+			Marked:  node.marked,
+			Sources: make([]string, 0),
+		}
+
+		if scheduleObj != nil {
+			graphNode.ExpectedSeconds = scheduleObj.expected[node.ID()].Seconds()
+			graphNode.Critical = critical[node.ID()]
+		}
+
+		if node.installableObj != nil {
+			graphNode.Manifest = node.installableObj.ManifestId()
+			graphNode.State = node.installableObj.State()
+
+			for _, source := range node.installableObj.GetDescriptor().Sources {
+				graphNode.Sources = append(graphNode.Sources, source.Uri)
+			}
+			sort.Strings(graphNode.Sources)
+		}
+
+		graphObj.Nodes = append(graphObj.Nodes, graphNode)
+	}
+
+	edges := g.graph.Edges()
+	for edges.Next() {
+		edge := edges.Edge()
+		graphObj.Edges = append(graphObj.Edges, GraphEdge{
+			From: edge.From().(NamedNode).name,
+			To:   edge.To().(NamedNode).name,
+			Type: edgeType(edge),
+		})
+	}
+
+	graphObj.Excluded = append(graphObj.Excluded, g.excluded...)
+
+	sort.Slice(graphObj.Excluded, func(i, j int) bool {
+		return graphObj.Excluded[i].Id < graphObj.Excluded[j].Id
+	})
+
+	sort.Slice(graphObj.Nodes, func(i, j int) bool {
+		return graphObj.Nodes[i].Id < graphObj.Nodes[j].Id
+	})
+
+	sort.Slice(graphObj.Edges, func(i, j int) bool {
+		if graphObj.Edges[i].From == graphObj.Edges[j].From {
+			return graphObj.Edges[i].To < graphObj.Edges[j].To
+		}
+		return graphObj.Edges[i].From < graphObj.Edges[j].From
+	})
+
+	return graphObj, nil
+}
+
+// Writes the graph to the writer in the given format
+func (g *Graph) Write(writer io.Writer, format string) error {
+	switch format {
+	case constants.GraphFormatDot:
+		return g.writeDot(writer)
+	case constants.GraphFormatMermaid:
+		return g.writeMermaid(writer)
+	case constants.GraphFormatJson:
+		return g.writeJson(writer)
+	default:
+		return errors.New(fmt.Sprintf("Unsupported graph format '%s'. Valid formats are: %s", format,
+			strings.Join([]string{constants.GraphFormatDot, constants.GraphFormatMermaid,
+				constants.GraphFormatJson}, ", ")))
+	}
+}
+
+// Returns the nodes grouped by manifest ID, plus the sorted manifest IDs
+func (g *Graph) nodesByManifest() (map[string][]GraphNode, []string) {
+	nodesByManifest := make(map[string][]GraphNode, 0)
+	manifestIds := make([]string, 0)
+
+	for _, node := range g.Nodes {
+		if _, ok := nodesByManifest[node.Manifest]; !ok {
+			manifestIds = append(manifestIds, node.Manifest)
+		}
+		nodesByManifest[node.Manifest] = append(nodesByManifest[node.Manifest], node)
+	}
+
+	sort.Strings(manifestIds)
+
+	return nodesByManifest, manifestIds
+}
+
+// Returns the text to label a node with in addition to its ID, i.e. its state plus how long it's
+// expected to take if that's known
+func (n GraphNode) annotation() string {
+	if n.ExpectedSeconds <= 0 {
+		return n.State
+	}
+
+	return fmt.Sprintf("%s, ~%s", n.State,
+		(time.Duration(n.ExpectedSeconds * float64(time.Second))).Round(time.Second))
+}
+
+// Writes the graph in Graphviz DOT format. Nodes are clustered by manifest, marked nodes are drawn
+// in bold, nodes on the critical path are red and edges created because a manifest is sequential
+// are dashed. Excluded kapps are drawn in grey in a separate cluster labelled with the reason
+// they were excluded.
+func (g *Graph) writeDot(writer io.Writer) error {
+	var builder strings.Builder
+
+	builder.WriteString("digraph sugarkube {\n")
+	builder.WriteString("  rankdir=TB;\n")
+	builder.WriteString("  node [shape=box];\n")
+
+	nodesByManifest, manifestIds := g.nodesByManifest()
+
+	for i, manifestId := range manifestIds {
+		builder.WriteString(fmt.Sprintf("  subgraph cluster_%d {\n", i))
+		builder.WriteString(fmt.Sprintf("    label=%q;\n", manifestId))
+
+		for _, node := range nodesByManifest[manifestId] {
+			attributes := []string{
+				fmt.Sprintf("label=%q", fmt.Sprintf("%s\n(%s)", node.Id, node.annotation())),
+			}
+			if node.Critical {
+				attributes = append(attributes, "color=red")
+			}
+			if node.Marked {
+				attributes = append(attributes, "style=bold")
+			} else {
+				attributes = append(attributes, "style=dashed")
+			}
+			if len(node.Sources) > 0 {
+				attributes = append(attributes, fmt.Sprintf("tooltip=%q",
+					strings.Join(node.Sources, "\n")))
+			}
+
+			builder.WriteString(fmt.Sprintf("    %q [%s];\n", node.Id, strings.Join(attributes, ", ")))
+		}
+
+		builder.WriteString("  }\n")
+	}
+
+	if len(g.Excluded) > 0 {
+		builder.WriteString("  subgraph cluster_excluded {\n")
+		builder.WriteString("    label=\"excluded\";\n")
+		for _, excludedKapp := range g.Excluded {
+			builder.WriteString(fmt.Sprintf("    %q [label=%q, style=dotted, color=grey, fontcolor=grey];\n",
+				excludedKapp.Id, fmt.Sprintf("%s\n(%s)", excludedKapp.Id, excludedKapp.Reason)))
+		}
+		builder.WriteString("  }\n")
+	}
+
+	for _, edge := range g.Edges {
+		attributes := []string{fmt.Sprintf("label=%q", edge.Type)}
+		switch edge.Type {
+		case constants.EdgeTypeSequential:
+			attributes = append(attributes, "style=dashed")
+		case constants.EdgeTypeRunsAfter:
+			attributes = append(attributes, "style=dotted")
+		}
+
+		builder.WriteString(fmt.Sprintf("  %q -> %q [%s];\n", edge.From, edge.To,
+			strings.Join(attributes, ", ")))
+	}
+
+	builder.WriteString("}\n")
+
+	_, err := io.WriteString(writer, builder.String())
+	return errors.WithStack(err)
+}
+
+// Writes the graph as a Mermaid flowchart. Mermaid IDs can't contain the namespace separator so
+// nodes are given synthetic IDs and labelled with their kapp ID instead.
+func (g *Graph) writeMermaid(writer io.Writer) error {
+	var builder strings.Builder
+
+	builder.WriteString("graph TD\n")
+
+	mermaidIds := make(map[string]string, len(g.Nodes))
+	for i, node := range g.Nodes {
+		mermaidIds[node.Id] = fmt.Sprintf("n%d", i)
+	}
+
+	nodesByManifest, manifestIds := g.nodesByManifest()
+	markedIds := make([]string, 0)
+	criticalIds := make([]string, 0)
+
+	for i, manifestId := range manifestIds {
+		builder.WriteString(fmt.Sprintf("  subgraph m%d [\"%s\"]\n", i, manifestId))
+		for _, node := range nodesByManifest[manifestId] {
+			builder.WriteString(fmt.Sprintf("    %s[\"%s<br/>(%s)\"]\n", mermaidIds[node.Id],
+				node.Id, node.annotation()))
+			if node.Marked {
+				markedIds = append(markedIds, mermaidIds[node.Id])
+			}
+			if node.Critical {
+				criticalIds = append(criticalIds, mermaidIds[node.Id])
+			}
+		}
+		builder.WriteString("  end\n")
+	}
+
+	if len(g.Excluded) > 0 {
+		excludedIds := make([]string, 0, len(g.Excluded))
+		builder.WriteString("  subgraph excluded [\"excluded\"]\n")
+		for i, excludedKapp := range g.Excluded {
+			excludedId := fmt.Sprintf("x%d", i)
+			excludedIds = append(excludedIds, excludedId)
+			builder.WriteString(fmt.Sprintf("    %s[\"%s<br/>(%s)\"]\n", excludedId, excludedKapp.Id,
+				strings.Replace(excludedKapp.Reason, "\"", "#quot;", -1)))
+		}
+		builder.WriteString("  end\n")
+		builder.WriteString("  classDef excluded stroke-dasharray:5 5,color:#999\n")
+		builder.WriteString(fmt.Sprintf("  class %s excluded\n", strings.Join(excludedIds, ",")))
+	}
+
+	for _, edge := range g.Edges {
+		arrow := "-->"
+		if edge.Type == constants.EdgeTypeSequential || edge.Type == constants.EdgeTypeRunsAfter {
+			arrow = "-.->"
+		}
+
+		builder.WriteString(fmt.Sprintf("  %s %s|%s| %s\n", mermaidIds[edge.From], arrow, edge.Type,
+			mermaidIds[edge.To]))
+	}
+
+	if len(markedIds) > 0 {
+		builder.WriteString("  classDef marked font-weight:bold,stroke-width:3px\n")
+		builder.WriteString(fmt.Sprintf("  class %s marked\n", strings.Join(markedIds, ",")))
+	}
+
+	if len(criticalIds) > 0 {
+		builder.WriteString("  classDef critical stroke:#f00\n")
+		builder.WriteString(fmt.Sprintf("  class %s critical\n", strings.Join(criticalIds, ",")))
+	}
+
+	_, err := io.WriteString(writer, builder.String())
+	return errors.WithStack(err)
+}
+
+// Writes the graph as indented JSON
+func (g *Graph) writeJson(writer io.Writer) error {
+	jsonData, err := json.MarshalIndent(g, "", "  ")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	_, err = fmt.Fprintf(writer, "%s\n", jsonData)
+	return errors.WithStack(err)
+}
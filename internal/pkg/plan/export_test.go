@@ -0,0 +1,107 @@
+/*
+ * Copyright 2019 The Sugarkube Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package plan
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/sugarkube/sugarkube/internal/pkg/constants"
+	"testing"
+)
+
+func getExportDag(t *testing.T) *Dag {
+	dag, err := build(map[string]nodeDescriptor{
+		"cluster": {},
+		"tiller":  {dependsOn: []string{"cluster"}, sequential: true},
+		"ingress": {dependsOn: []string{"tiller"}},
+	})
+	assert.Nil(t, err)
+
+	dag, err = dag.subGraph([]string{"ingress"}, nil, false, false)
+	assert.Nil(t, err)
+
+	return dag
+}
+
+func TestExport(t *testing.T) {
+	graphObj, err := getExportDag(t).Export()
+	assert.Nil(t, err)
+
+	expected := &Graph{
+		Nodes: []GraphNode{
+			{Id: "cluster", Marked: false, Sources: []string{}},
+			{Id: "ingress", Marked: true, Sources: []string{}},
+			{Id: "tiller", Marked: false, Sources: []string{}},
+		},
+		Edges: []GraphEdge{
+			{From: "cluster", To: "tiller", Type: constants.EdgeTypeSequential},
+			{From: "tiller", To: "ingress", Type: constants.EdgeTypeDependsOn},
+		},
+	}
+
+	assert.Equal(t, expected, graphObj)
+}
+
+func TestExportFormats(t *testing.T) {
+	graphObj, err := getExportDag(t).Export()
+	assert.Nil(t, err)
+
+	var buffer bytes.Buffer
+	assert.Nil(t, graphObj.Write(&buffer, constants.GraphFormatJson))
+	parsed := &Graph{}
+	assert.Nil(t, json.Unmarshal(buffer.Bytes(), parsed))
+	assert.Equal(t, graphObj, parsed)
+
+	buffer.Reset()
+	assert.Nil(t, graphObj.Write(&buffer, constants.GraphFormatDot))
+	assert.Contains(t, buffer.String(), `"cluster" -> "tiller" [label="sequential", style=dashed];`)
+	assert.Contains(t, buffer.String(), `"tiller" -> "ingress" [label="depends_on"];`)
+
+	buffer.Reset()
+	assert.Nil(t, graphObj.Write(&buffer, constants.GraphFormatMermaid))
+	assert.Contains(t, buffer.String(), "n0 -.->|sequential| n2")
+	assert.Contains(t, buffer.String(), "n2 -->|depends_on| n1")
+	assert.Contains(t, buffer.String(), "class n1 marked")
+
+	assert.NotNil(t, graphObj.Write(&buffer, "png"))
+}
+
+func TestExportCriticalPath(t *testing.T) {
+	dag := getExportDag(t)
+	dag.Durations = &Durations{
+		stackName: "stack",
+		stacks: map[string]map[string]kappDurations{
+			"stack": {"ingress": {Install: 90}},
+		},
+	}
+
+	graphObj, err := dag.Export()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"cluster", "tiller", "ingress"}, graphObj.CriticalPath)
+	assert.Equal(t, float64(90), graphObj.EtaSeconds)
+	assert.Equal(t, float64(90), graphObj.Nodes[1].ExpectedSeconds)
+	assert.True(t, graphObj.Nodes[1].Critical)
+
+	var buffer bytes.Buffer
+	assert.Nil(t, graphObj.Write(&buffer, constants.GraphFormatDot))
+	assert.Contains(t, buffer.String(), `"ingress" [label="ingress\n(, ~1m30s)", color=red, style=bold];`)
+
+	buffer.Reset()
+	assert.Nil(t, graphObj.Write(&buffer, constants.GraphFormatMermaid))
+	assert.Contains(t, buffer.String(), "class n0,n1,n2 critical")
+}
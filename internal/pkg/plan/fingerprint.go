@@ -0,0 +1,242 @@
+/*
+ * Copyright 2019 The Sugarkube Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package plan
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/sugarkube/sugarkube/internal/pkg/acquirer"
+	"github.com/sugarkube/sugarkube/internal/pkg/cacher"
+	"github.com/sugarkube/sugarkube/internal/pkg/interfaces"
+	"github.com/sugarkube/sugarkube/internal/pkg/log"
+	"github.com/sugarkube/sugarkube/internal/pkg/utils"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+const fingerprintsFileName = "fingerprints.yaml"
+
+// Tracks fingerprints of everything that determines what installing each kapp would do. Fingerprints
+// are stored in each kapp's cache directory after it's successfully installed. Kapps whose
+// fingerprints haven't changed since they were last installed in the same stack don't need to be
+// installed again, but their outputs are still loaded.
+type Fingerprints struct {
+	stackName string
+	force     bool // if true, kapps are installed even if they're unchanged
+	mutex     sync.Mutex
+	unchanged map[string]bool // names of nodes that weren't installed because they were unchanged
+}
+
+// The format of the fingerprints file in a kapp's cache directory
+type fingerprintsFile struct {
+	Stacks map[string]fingerprintEntry // keyed by stack name
+}
+
+type fingerprintEntry struct {
+	Fingerprint string
+	InstalledAt time.Time `yaml:"installed_at"`
+}
+
+// Creates an object to track fingerprints of kapps installed in the named stack. If force is true
+// unchanged kapps will be installed anyway, but their fingerprints are still recorded.
+func NewFingerprints(stackName string, force bool) *Fingerprints {
+	return &Fingerprints{
+		stackName: stackName,
+		force:     force,
+		unchanged: map[string]bool{},
+	}
+}
+
+// Computes a node's fingerprint and returns it along with whether the node is unchanged since it was
+// last installed. Errors are logged and the node is treated as changed.
+func (f *Fingerprints) check(node NamedNode, stackObj interfaces.IStack,
+	installerVars map[string]interface{}) (string, bool) {
+	installableObj := node.installableObj
+
+	current, err := fingerprint(installableObj, stackObj, installerVars)
+	if err != nil {
+		log.Logger.Warnf("Error fingerprinting kapp '%s'. It will be installed: %v",
+			installableObj.FullyQualifiedId(), err)
+		return "", false
+	}
+
+	if f.force {
+		return current, false
+	}
+
+	entry, err := f.load(installableObj)
+	if err != nil {
+		log.Logger.Warnf("Error loading the fingerprint of kapp '%s'. It will be installed: %v",
+			installableObj.FullyQualifiedId(), err)
+		return current, false
+	}
+
+	if entry.Fingerprint != current {
+		log.Logger.Debugf("Kapp '%s' has changed since it was last installed in stack '%s'",
+			installableObj.FullyQualifiedId(), f.stackName)
+		return current, false
+	}
+
+	log.Logger.Infof("Kapp '%s' is unchanged since it was installed at %s. Skipping installing it",
+		installableObj.FullyQualifiedId(), entry.InstalledAt)
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.unchanged[node.name] = true
+
+	return current, true
+}
+
+// Returns the names of nodes that weren't installed because they were unchanged
+func (f *Fingerprints) unchangedNodes() map[string]bool {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	unchanged := make(map[string]bool, len(f.unchanged))
+	for name := range f.unchanged {
+		unchanged[name] = true
+	}
+
+	return unchanged
+}
+
+// Records the fingerprint of a node that was successfully installed. Each kapp has its own file so
+// nodes can be recorded concurrently.
+func (f *Fingerprints) record(node NamedNode, fingerprint string) error {
+	contents, err := loadFingerprintsFile(node.installableObj)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	contents.Stacks[f.stackName] = fingerprintEntry{
+		Fingerprint: fingerprint,
+		InstalledAt: time.Now().UTC(),
+	}
+
+	path := fingerprintsPath(node.installableObj)
+	err = writeYamlFile(path, contents)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	log.Logger.Debugf("Recorded the fingerprint of kapp '%s' in '%s'", node.name, path)
+
+	return nil
+}
+
+// Returns the fingerprint recorded when the kapp was last installed in the stack
+func (f *Fingerprints) load(installableObj interfaces.IInstallable) (fingerprintEntry, error) {
+	contents, err := loadFingerprintsFile(installableObj)
+	if err != nil {
+		return fingerprintEntry{}, errors.WithStack(err)
+	}
+
+	return contents.Stacks[f.stackName], nil
+}
+
+func fingerprintsPath(installableObj interfaces.IInstallable) string {
+	return filepath.Join(installableObj.GetCacheDir(), cacher.CacheDir, fingerprintsFileName)
+}
+
+func loadFingerprintsFile(installableObj interfaces.IInstallable) (fingerprintsFile, error) {
+	contents := fingerprintsFile{}
+	path := fingerprintsPath(installableObj)
+
+	if _, err := os.Stat(path); err == nil {
+		err = utils.LoadYamlFile(path, &contents)
+		if err != nil {
+			return contents, errors.WithStack(err)
+		}
+	}
+
+	if contents.Stacks == nil {
+		contents.Stacks = map[string]fingerprintEntry{}
+	}
+
+	return contents, nil
+}
+
+// Returns a hash of everything that determines what installing a kapp would do: the hashes of its
+// acquired sources, the contents of its rendered templates, its merged and templated vars and its
+// descriptor (which includes its installer's args). Templates must have been rendered first.
+func fingerprint(installableObj interfaces.IInstallable, stackObj interfaces.IStack,
+	installerVars map[string]interface{}) (string, error) {
+
+	inputs, err := inputsHash(installableObj, stackObj, installerVars)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	hash := sha256.New()
+	_, err = fmt.Fprintf(hash, "inputs %s\n", inputs)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	acquirers, err := installableObj.Acquirers()
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	sourceKeys := make([]string, 0, len(acquirers))
+	for key := range acquirers {
+		sourceKeys = append(sourceKeys, key)
+	}
+	sort.Strings(sourceKeys)
+
+	for _, key := range sourceKeys {
+		sourceDir, err := cacher.SourceDir(installableObj.GetCacheDir(), acquirers[key])
+		if err != nil {
+			return "", errors.WithStack(err)
+		}
+
+		sourceHash, err := acquirer.Hash(acquirers[key], sourceDir)
+		if err != nil {
+			return "", errors.Wrapf(err, "Error hashing source '%s'", acquirers[key].Uri())
+		}
+
+		_, err = fmt.Fprintf(hash, "source %s %s\n", key, sourceHash)
+		if err != nil {
+			return "", errors.WithStack(err)
+		}
+	}
+
+	for _, template := range installableObj.GetDescriptor().Templates {
+		destPath := template.Dest
+		if !filepath.IsAbs(destPath) {
+			destPath = filepath.Join(installableObj.GetConfigFileDir(), destPath)
+		}
+
+		data, err := ioutil.ReadFile(destPath)
+		if err != nil {
+			return "", errors.Wrapf(err, "Error reading rendered template '%s'", destPath)
+		}
+
+		_, err = fmt.Fprintf(hash, "template %s %x\n", template.Dest, sha256.Sum256(data))
+		if err != nil {
+			return "", errors.WithStack(err)
+		}
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
@@ -0,0 +1,92 @@
+/*
+ * Copyright 2019 The Sugarkube Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package plan
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/sugarkube/sugarkube/internal/pkg/mock"
+	"github.com/sugarkube/sugarkube/internal/pkg/structs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFingerprints(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "fingerprint-")
+	assert.Nil(t, err)
+	defer os.RemoveAll(tempDir)
+
+	// the kapp has a local source and a rendered template
+	sourceDir := filepath.Join(tempDir, "source")
+	assert.Nil(t, os.MkdirAll(sourceDir, 0755))
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(sourceDir, "Makefile"), []byte("install:\n"), 0644))
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(tempDir, "values.yaml"), []byte("replicas: 1\n"), 0644))
+
+	node := createTestDag(t, structs.KappDescriptorWithMaps{
+		Id: "kapp",
+		KappConfig: structs.KappConfig{
+			Templates: []structs.Template{{Source: "values.tpl", Dest: filepath.Join(tempDir, "values.yaml")}},
+		},
+		Sources: map[string]structs.Source{
+			"source": {Id: "source", Uri: "file://" + sourceDir},
+		},
+	}).nodesByName()["manifest:kapp"]
+	assert.Nil(t, node.installableObj.SetTopLevelCacheDir(filepath.Join(tempDir, "cache")))
+
+	stackObj := conditionsStack()
+	installerVars := map[string]interface{}{"action": "install"}
+
+	fingerprints := NewFingerprints("dev", false)
+
+	// kapps that have never been installed have changed
+	fingerprint, unchanged := fingerprints.check(node, stackObj, installerVars)
+	assert.NotEmpty(t, fingerprint)
+	assert.False(t, unchanged)
+
+	assert.Nil(t, fingerprints.record(node, fingerprint))
+
+	current, unchanged := fingerprints.check(node, stackObj, installerVars)
+	assert.Equal(t, fingerprint, current)
+	assert.True(t, unchanged)
+	assert.Equal(t, map[string]bool{node.name: true}, fingerprints.unchangedNodes())
+
+	// fingerprints are recorded per stack
+	_, unchanged = NewFingerprints("prod", false).check(node, stackObj, installerVars)
+	assert.False(t, unchanged)
+
+	// forcing installs ignores recorded fingerprints
+	current, unchanged = NewFingerprints("dev", true).check(node, stackObj, installerVars)
+	assert.Equal(t, fingerprint, current)
+	assert.False(t, unchanged)
+
+	// changing vars, rendered templates or sources changes the fingerprint
+	otherRegion := conditionsStack().(*mock.MockStack)
+	otherRegion.TemplatedVars["stack"].(map[string]interface{})["region"] = "us-east-1"
+	_, unchanged = fingerprints.check(node, otherRegion, installerVars)
+	assert.False(t, unchanged)
+
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(tempDir, "values.yaml"), []byte("replicas: 2\n"), 0644))
+	templateFingerprint, unchanged := fingerprints.check(node, stackObj, installerVars)
+	assert.NotEqual(t, fingerprint, templateFingerprint)
+	assert.False(t, unchanged)
+
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(tempDir, "source", "Makefile"), []byte("delete:\n"), 0644))
+	sourceFingerprint, unchanged := fingerprints.check(node, stackObj, installerVars)
+	assert.NotEqual(t, templateFingerprint, sourceFingerprint)
+	assert.False(t, unchanged)
+}
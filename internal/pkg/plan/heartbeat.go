@@ -0,0 +1,112 @@
+/*
+ * Copyright 2019 The Sugarkube Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package plan
+
+import (
+	"fmt"
+	"github.com/sugarkube/sugarkube/internal/pkg/log"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Periodically prints the progress of walking the DAG so users (and CI systems) can see it's still
+// doing something while long-running kapps are processed
+type heartbeat struct {
+	interval   time.Duration
+	out        io.Writer
+	numWorkers int
+}
+
+// A snapshot of the progress of a walk
+type progress struct {
+	startedAt  map[string]time.Time // start times of nodes that are currently being processed
+	durations  []time.Duration      // how long each finished node took to process
+	numQueued  int                  // nodes whose dependencies are satisfied but haven't been dispatched
+	numBlocked int                  // nodes waiting for their dependencies to finish
+	numDone    int                  // nodes that have finished processing or been skipped
+	numNodes   int
+}
+
+// Returns a ticker channel that fires at the heartbeat interval, or nil if heartbeats are disabled.
+// The returned stop function must be called once the walk finishes.
+func (h *heartbeat) ticker() (<-chan time.Time, func()) {
+	if h == nil || h.interval <= 0 {
+		return nil, func() {}
+	}
+
+	ticker := time.NewTicker(h.interval)
+	return ticker.C, ticker.Stop
+}
+
+// Prints the progress of the walk
+func (h *heartbeat) print(now time.Time, p progress) {
+	var builder strings.Builder
+
+	builder.WriteString(fmt.Sprintf("[%s] %d running, %d queued, %d blocked, %d of %d finished. "+
+		"Estimated time remaining: %s\n", now.Format("15:04:05"), len(p.startedAt), p.numQueued,
+		p.numBlocked, p.numDone, p.numNodes, h.estimateRemaining(now, p)))
+
+	names := make([]string, 0, len(p.startedAt))
+	for name := range p.startedAt {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		builder.WriteString(fmt.Sprintf("  %s (running for %s)\n", name,
+			now.Sub(p.startedAt[name]).Round(time.Second)))
+	}
+
+	_, err := io.WriteString(h.out, builder.String())
+	if err != nil {
+		log.Logger.Warnf("Error printing heartbeat: %v", err)
+	}
+}
+
+// Estimates how long it'll take to process the remaining nodes based on the mean duration of the
+// nodes that have finished so far, assuming all workers are kept busy
+func (h *heartbeat) estimateRemaining(now time.Time, p progress) string {
+	if len(p.durations) == 0 {
+		return "unknown"
+	}
+
+	var total time.Duration
+	for _, duration := range p.durations {
+		total += duration
+	}
+	mean := total / time.Duration(len(p.durations))
+
+	remaining := mean * time.Duration(p.numQueued+p.numBlocked)
+	for _, startedAt := range p.startedAt {
+		if elapsed := now.Sub(startedAt); elapsed < mean {
+			remaining += mean - elapsed
+		}
+	}
+
+	numWorkers := h.numWorkers
+	numUnfinished := len(p.startedAt) + p.numQueued + p.numBlocked
+	if numWorkers > numUnfinished {
+		numWorkers = numUnfinished
+	}
+	if numWorkers > 1 {
+		remaining /= time.Duration(numWorkers)
+	}
+
+	return remaining.Round(time.Second).String()
+}
@@ -0,0 +1,85 @@
+/*
+ * Copyright 2019 The Sugarkube Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package plan
+
+import (
+	"bytes"
+	"context"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHeartbeatPrint(t *testing.T) {
+	var buffer bytes.Buffer
+	heartbeatObj := &heartbeat{interval: time.Second, out: &buffer, numWorkers: 2}
+
+	now := time.Date(2019, 5, 1, 12, 30, 0, 0, time.UTC)
+
+	heartbeatObj.print(now, progress{
+		startedAt: map[string]time.Time{
+			"manifest:kappB": now.Add(-90 * time.Second),
+			"manifest:kappA": now.Add(-20 * time.Second),
+		},
+		durations:  []time.Duration{time.Minute, 3 * time.Minute},
+		numQueued:  1,
+		numBlocked: 2,
+		numDone:    2,
+		numNodes:   7,
+	})
+
+	// mean duration is 2 minutes, so 3 unstarted kapps take 6 minutes, plus 100s and 30s for
+	// the running kapps, split over 2 workers
+	assert.Equal(t, "[12:30:00] 2 running, 1 queued, 2 blocked, 2 of 7 finished. "+
+		"Estimated time remaining: 4m5s\n"+
+		"  manifest:kappA (running for 20s)\n"+
+		"  manifest:kappB (running for 1m30s)\n", buffer.String())
+
+	buffer.Reset()
+	heartbeatObj.print(now, progress{numNodes: 3, numBlocked: 3})
+	assert.Equal(t, "[12:30:00] 0 running, 0 queued, 3 blocked, 0 of 3 finished. "+
+		"Estimated time remaining: unknown\n", buffer.String())
+}
+
+func TestWalkHeartbeat(t *testing.T) {
+	dag, err := build(getDescriptors())
+	assert.Nil(t, err)
+
+	var buffer bytes.Buffer
+	options := walkOptions{
+		heartbeat: &heartbeat{interval: 5 * time.Millisecond, out: &buffer, numWorkers: 1},
+	}
+
+	processCh := make(chan NamedNode)
+	doneCh := make(chan nodeResult)
+
+	go func() {
+		for node := range processCh {
+			time.Sleep(20 * time.Millisecond)
+			doneCh <- nodeResult{node: node}
+		}
+	}()
+
+	summary := <-dag.walkDown(context.Background(), true, options, processCh, doneCh)
+	assert.Equal(t, len(getDescriptors()), len(summary.withStatus(NodeStatusSucceeded)))
+
+	// the buffer is only written to by the walk so it's safe to read once the walk has finished
+	output := buffer.String()
+	assert.Contains(t, output, "1 running")
+	assert.True(t, strings.Contains(output, "(running for "))
+}
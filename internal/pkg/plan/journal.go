@@ -0,0 +1,224 @@
+/*
+ * Copyright 2019 The Sugarkube Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package plan
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/pkg/errors"
+	"github.com/sugarkube/sugarkube/internal/pkg/cacher"
+	"github.com/sugarkube/sugarkube/internal/pkg/interfaces"
+	"github.com/sugarkube/sugarkube/internal/pkg/log"
+	"github.com/sugarkube/sugarkube/internal/pkg/utils"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const journalFileName = "journal.yaml"
+
+// Records which nodes were successfully processed while installing a DAG so a failed run can be
+// resumed. Each entry contains a hash of the inputs the node was processed with plus its outputs, so
+// nodes can be skipped on subsequent runs as long as nothing has changed.
+type Journal struct {
+	path    string
+	mutex   sync.Mutex
+	entries map[string]journalEntry
+	resumed map[string]bool // names of nodes that were skipped because they were found in the journal
+}
+
+// The format of the journal on disk
+type journalFile struct {
+	Entries map[string]journalEntry
+}
+
+type journalEntry struct {
+	InputsHash  string    `yaml:"inputs_hash"`
+	Installed   bool      // false if only the node's outputs were loaded
+	CompletedAt time.Time `yaml:"completed_at"`
+	Outputs     map[string]interface{}
+}
+
+// Creates a journal in the given cache directory. If resume is true entries from the previous run
+// will be loaded, otherwise the journal will start out empty and replace the previous one as soon
+// as the first entry is recorded.
+func NewJournal(cacheDir string, resume bool) (*Journal, error) {
+	absCacheDir, err := filepath.Abs(cacheDir)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	journal := &Journal{
+		path:    filepath.Join(absCacheDir, cacher.CacheDir, journalFileName),
+		entries: map[string]journalEntry{},
+		resumed: map[string]bool{},
+	}
+
+	if !resume {
+		return journal, nil
+	}
+
+	if _, err := os.Stat(journal.path); err != nil {
+		log.Logger.Warnf("No journal found at '%s'. All kapps will be processed", journal.path)
+		return journal, nil
+	}
+
+	contents := journalFile{}
+	err = utils.LoadYamlFile(journal.path, &contents)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if contents.Entries != nil {
+		journal.entries = contents.Entries
+	}
+
+	log.Logger.Infof("Loaded %d entries from journal '%s'", len(journal.entries), journal.path)
+
+	return journal, nil
+}
+
+// Returns the journal entry for a node if it can be skipped, i.e. if it completed in a previous run
+// with identical inputs. Nodes that are marked for processing can only be skipped if they were
+// installed in the previous run and not just had their outputs loaded.
+func (j *Journal) lookup(node NamedNode, inputsHash string) (journalEntry, bool) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	entry, ok := j.entries[node.name]
+	if !ok {
+		return journalEntry{}, false
+	}
+
+	if entry.InputsHash != inputsHash {
+		log.Logger.Infof("Inputs for kapp '%s' have changed since it was journalled", node.name)
+		return journalEntry{}, false
+	}
+
+	if node.marked && !entry.Installed {
+		log.Logger.Infof("Kapp '%s' wasn't installed when it was journalled", node.name)
+		return journalEntry{}, false
+	}
+
+	j.resumed[node.name] = true
+
+	return entry, true
+}
+
+// Returns the names of nodes that were resumed from the journal instead of being processed
+func (j *Journal) resumedNodes() map[string]bool {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	resumed := make(map[string]bool, len(j.resumed))
+	for name := range j.resumed {
+		resumed[name] = true
+	}
+
+	return resumed
+}
+
+// Records that a node was successfully processed and writes the journal to disk
+func (j *Journal) record(node NamedNode, inputsHash string, outputs map[string]interface{}) error {
+	// don't write sensitive outputs to disk. The kapp will just be processed again on the next run
+	if hasSensitiveOutputs(node.installableObj) {
+		log.Logger.Infof("Not journalling kapp '%s' because it has sensitive outputs", node.name)
+		return nil
+	}
+
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	j.entries[node.name] = journalEntry{
+		InputsHash:  inputsHash,
+		Installed:   node.marked,
+		CompletedAt: time.Now().UTC(),
+		Outputs:     outputs,
+	}
+
+	err := writeYamlFile(j.path, journalFile{Entries: j.entries})
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	log.Logger.Debugf("Journalled kapp '%s' to '%s'", node.name, j.path)
+
+	return nil
+}
+
+// Returns a hash of everything that affects how a kapp is processed, i.e. its merged descriptor
+// and the fully templated vars (including outputs from its parents)
+func inputsHash(installableObj interfaces.IInstallable, stackObj interfaces.IStack,
+	installerVars map[string]interface{}) (string, error) {
+
+	templatedVars, err := stackObj.GetTemplatedVars(installableObj, installerVars)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	yamlVars, err := yaml.Marshal(templatedVars)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	yamlDescriptor, err := yaml.Marshal(installableObj.GetDescriptor())
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	hash := sha256.New()
+	hash.Write(yamlVars)
+	hash.Write(yamlDescriptor)
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// Returns whether any of a kapp's outputs are sensitive
+func hasSensitiveOutputs(installableObj interfaces.IInstallable) bool {
+	for _, output := range installableObj.GetDescriptor().Outputs {
+		if output.Sensitive {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Writes data as YAML to a file that's only readable by the current user. The data is written to a
+// temporary file which is then renamed so partially written files are never left behind.
+func writeYamlFile(path string, data interface{}) error {
+	yamlData, err := yaml.Marshal(data)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	tmpPath := path + ".tmp"
+	err = ioutil.WriteFile(tmpPath, yamlData, 0600)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return errors.WithStack(os.Rename(tmpPath, path))
+}
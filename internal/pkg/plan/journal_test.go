@@ -0,0 +1,88 @@
+/*
+ * Copyright 2019 The Sugarkube Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package plan
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/sugarkube/sugarkube/internal/pkg/structs"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestJournalResume(t *testing.T) {
+	cacheDir, err := ioutil.TempDir("", "journal-")
+	assert.Nil(t, err)
+	defer os.RemoveAll(cacheDir)
+
+	nodes := createTestDag(t, structs.KappDescriptorWithMaps{Id: "installed"},
+		structs.KappDescriptorWithMaps{Id: "loaded"}).nodesByName()
+	installed := nodes["manifest:installed"]
+	markedLoaded := nodes["manifest:loaded"]
+	loaded := markedLoaded
+	loaded.marked = false
+	outputs := map[string]interface{}{"out": "value"}
+
+	journal, err := NewJournal(cacheDir, false)
+	assert.Nil(t, err)
+	assert.Nil(t, journal.record(installed, "hash1", outputs))
+	assert.Nil(t, journal.record(loaded, "hash2", nil))
+
+	// starting a new run without resuming ignores the previous journal
+	journal, err = NewJournal(cacheDir, false)
+	assert.Nil(t, err)
+	_, ok := journal.lookup(installed, "hash1")
+	assert.False(t, ok)
+
+	journal, err = NewJournal(cacheDir, true)
+	assert.Nil(t, err)
+
+	entry, ok := journal.lookup(installed, "hash1")
+	assert.True(t, ok)
+	assert.True(t, entry.Installed)
+	assert.Equal(t, outputs, entry.Outputs)
+
+	// changed inputs mean the kapp must be processed again
+	_, ok = journal.lookup(installed, "changed")
+	assert.False(t, ok)
+
+	// kapps that only had their outputs loaded can be skipped unless they're now marked
+	_, ok = journal.lookup(loaded, "hash2")
+	assert.True(t, ok)
+	_, ok = journal.lookup(markedLoaded, "hash2")
+	assert.False(t, ok)
+}
+
+func TestJournalSkipsSensitiveOutputs(t *testing.T) {
+	cacheDir, err := ioutil.TempDir("", "journal-")
+	assert.Nil(t, err)
+	defer os.RemoveAll(cacheDir)
+
+	node := createTestDag(t, structs.KappDescriptorWithMaps{
+		Id:      "secret",
+		Outputs: map[string]structs.Output{"creds": {Id: "creds", Sensitive: true}},
+	}).nodesByName()["manifest:secret"]
+
+	journal, err := NewJournal(cacheDir, false)
+	assert.Nil(t, err)
+	assert.Nil(t, journal.record(node, "hash", map[string]interface{}{"creds": "password"}))
+
+	journal, err = NewJournal(cacheDir, true)
+	assert.Nil(t, err)
+	_, ok := journal.lookup(node, "hash")
+	assert.False(t, ok)
+}
@@ -0,0 +1,152 @@
+/*
+ * Copyright 2019 The Sugarkube Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package plan
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"github.com/sugarkube/sugarkube/internal/pkg/cacher"
+	"github.com/sugarkube/sugarkube/internal/pkg/config"
+	"github.com/sugarkube/sugarkube/internal/pkg/interfaces"
+	"github.com/sugarkube/sugarkube/internal/pkg/log"
+	"github.com/sugarkube/sugarkube/internal/pkg/utils"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+const kappLogsDirName = "logs"
+
+// run IDs are timestamps so sorting them by name sorts them chronologically
+const runIdFormat = "20060102-150405.000"
+
+type runIdKey struct{}
+type kappLogsKey struct{}
+
+// Returns a new ID for a run of sugarkube
+func newRunId() string {
+	return time.Now().UTC().Format(runIdFormat)
+}
+
+// Returns a context that makes installers log the commands they run to files for the given run
+func withRunId(ctx context.Context, runId string) context.Context {
+	return context.WithValue(ctx, runIdKey{}, runId)
+}
+
+// Returns the directory containing the logs of all runs for a kapp with the given cache directory
+func KappLogsDir(kappCacheDir string) string {
+	return filepath.Join(kappCacheDir, cacher.CacheDir, kappLogsDirName)
+}
+
+// Returns the IDs of the runs a kapp has logs for, oldest first
+func KappLogRuns(kappCacheDir string) ([]string, error) {
+	logsDir := KappLogsDir(kappCacheDir)
+
+	infos, err := ioutil.ReadDir(logsDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []string{}, nil
+		}
+		return nil, errors.WithStack(err)
+	}
+
+	runIds := make([]string, 0)
+	for _, info := range infos {
+		if info.IsDir() {
+			runIds = append(runIds, info.Name())
+		}
+	}
+
+	sort.Strings(runIds)
+	return runIds, nil
+}
+
+// If the context has a run ID, returns a context that logs commands run for the installable to its
+// log directory for the run. Logs from old runs are pruned according to the config.
+func withKappLogs(ctx context.Context, installableObj interfaces.IInstallable) context.Context {
+	runId, ok := ctx.Value(runIdKey{}).(string)
+	if !ok {
+		return ctx
+	}
+
+	if config.CurrentConfig != nil {
+		logsConfig := config.CurrentConfig.KappLogs
+		err := pruneKappLogs(installableObj.GetCacheDir(), runId, logsConfig.MaxRuns,
+			time.Duration(logsConfig.MaxAge)*24*time.Hour)
+		if err != nil {
+			log.Logger.Warnf("Error pruning logs for kapp '%s': %v", installableObj.FullyQualifiedId(), err)
+		}
+	}
+
+	return context.WithValue(ctx, kappLogsKey{},
+		filepath.Join(KappLogsDir(installableObj.GetCacheDir()), runId))
+}
+
+// Returns a context that logs commands run with it to the log file for the given installer target
+// if the context was returned by withKappLogs
+func withTargetLog(ctx context.Context, target string) context.Context {
+	dir, ok := ctx.Value(kappLogsKey{}).(string)
+	if !ok {
+		return ctx
+	}
+
+	return utils.WithCommandLog(ctx, filepath.Join(dir, target+".log"))
+}
+
+// Deletes logs for runs of a kapp other than the current run so that logs are kept for at most
+// `maxRuns` runs (including the current one) and none are older than `maxAge`. Zero values
+// disable each limit.
+func pruneKappLogs(kappCacheDir string, currentRunId string, maxRuns int, maxAge time.Duration) error {
+	runIds, err := KappLogRuns(kappCacheDir)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	previousRunIds := make([]string, 0)
+	for _, runId := range runIds {
+		if runId != currentRunId {
+			previousRunIds = append(previousRunIds, runId)
+		}
+	}
+
+	logsDir := KappLogsDir(kappCacheDir)
+
+	for i, runId := range previousRunIds {
+		runDir := filepath.Join(logsDir, runId)
+
+		prune := maxRuns > 0 && len(previousRunIds)-i >= maxRuns
+		if !prune && maxAge > 0 {
+			info, err := os.Stat(runDir)
+			if err != nil {
+				return errors.WithStack(err)
+			}
+			prune = time.Since(info.ModTime()) > maxAge
+		}
+
+		if prune {
+			log.Logger.Debugf("Pruning logs in '%s'", runDir)
+			err = os.RemoveAll(runDir)
+			if err != nil {
+				return errors.WithStack(err)
+			}
+		}
+	}
+
+	return nil
+}
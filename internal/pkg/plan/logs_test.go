@@ -0,0 +1,83 @@
+/*
+ * Copyright 2019 The Sugarkube Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package plan
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func makeLogRuns(t *testing.T, kappCacheDir string, runIds ...string) {
+	for _, runId := range runIds {
+		runDir := filepath.Join(KappLogsDir(kappCacheDir), runId)
+		assert.Nil(t, os.MkdirAll(runDir, 0755))
+		assert.Nil(t, ioutil.WriteFile(filepath.Join(runDir, "install.log"), []byte("log"), 0644))
+	}
+}
+
+func TestPruneKappLogsByCount(t *testing.T) {
+	kappCacheDir, err := ioutil.TempDir("", "kapp-logs-")
+	assert.Nil(t, err)
+	defer os.RemoveAll(kappCacheDir)
+
+	makeLogRuns(t, kappCacheDir, "20190101-000000.000", "20190102-000000.000", "20190103-000000.000",
+		"20190104-000000.000")
+
+	err = pruneKappLogs(kappCacheDir, "20190105-000000.000", 3, 0)
+	assert.Nil(t, err)
+
+	// the current run counts towards the limit
+	runIds, err := KappLogRuns(kappCacheDir)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"20190103-000000.000", "20190104-000000.000"}, runIds)
+
+	// nothing is pruned when there's no limit
+	err = pruneKappLogs(kappCacheDir, "20190105-000000.000", 0, 0)
+	assert.Nil(t, err)
+	runIds, err = KappLogRuns(kappCacheDir)
+	assert.Nil(t, err)
+	assert.Len(t, runIds, 2)
+}
+
+func TestPruneKappLogsByAge(t *testing.T) {
+	kappCacheDir, err := ioutil.TempDir("", "kapp-logs-")
+	assert.Nil(t, err)
+	defer os.RemoveAll(kappCacheDir)
+
+	makeLogRuns(t, kappCacheDir, "20190101-000000.000", "20190102-000000.000")
+
+	oldTime := time.Now().Add(-48 * time.Hour)
+	assert.Nil(t, os.Chtimes(filepath.Join(KappLogsDir(kappCacheDir), "20190101-000000.000"), oldTime,
+		oldTime))
+
+	err = pruneKappLogs(kappCacheDir, "20190102-000000.000", 10, 24*time.Hour)
+	assert.Nil(t, err)
+
+	runIds, err := KappLogRuns(kappCacheDir)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"20190102-000000.000"}, runIds)
+}
+
+func TestKappLogRunsMissing(t *testing.T) {
+	runIds, err := KappLogRuns(filepath.Join(os.TempDir(), "missing-kapp"))
+	assert.Nil(t, err)
+	assert.Empty(t, runIds)
+}
@@ -33,6 +33,7 @@ func findDependencies(manifests []interfaces.IManifest) map[string]nodeDescripto
 		previousInstallable = ""
 		for _, installableObj := range manifest.Installables() {
 			dependencies := make([]string, 0)
+			sequential := false
 
 			log.Logger.Tracef("Candidate dependency: %#v", installableObj)
 
@@ -43,16 +44,12 @@ func findDependencies(manifests []interfaces.IManifest) map[string]nodeDescripto
 					log.Logger.Tracef("Adding previous installable '%s' as a dependency",
 						previousInstallable)
 					dependencies = append(dependencies, previousInstallable)
+					sequential = true
 				} else if len(installableObj.GetDescriptor().DependsOn) > 0 {
 					log.Logger.Tracef("Installable '%s' depends on %v", installableObj.FullyQualifiedId(),
 						installableObj.GetDescriptor().DependsOn)
 					for _, dependency := range installableObj.GetDescriptor().DependsOn {
-						// fully-qualify the dependency if it's not already
-						if !strings.Contains(dependency, constants.NamespaceSeparator) {
-							dependency = strings.Join([]string{installableObj.ManifestId(), dependency},
-								constants.NamespaceSeparator)
-						}
-						dependencies = append(dependencies, dependency)
+						dependencies = append(dependencies, qualifyDependency(installableObj, dependency))
 					}
 				}
 			} else {
@@ -63,23 +60,53 @@ func findDependencies(manifests []interfaces.IManifest) map[string]nodeDescripto
 				log.Logger.Tracef("Installable '%s' depends on %v", installableObj.FullyQualifiedId(),
 					installableObj.GetDescriptor().DependsOn)
 				for _, dependency := range installableObj.GetDescriptor().DependsOn {
-					// fully-qualify the dependency if it's not already
-					if !strings.Contains(dependency, constants.NamespaceSeparator) {
-						dependency = strings.Join([]string{installableObj.ManifestId(), dependency},
-							constants.NamespaceSeparator)
-					}
-					dependencies = append(dependencies, dependency)
+					dependencies = append(dependencies, qualifyDependency(installableObj, dependency))
 				}
 			}
 
-			descriptors[installableObj.FullyQualifiedId()] = nodeDescriptor{
+			descriptor := nodeDescriptor{
 				dependsOn:      dependencies,
+				sequential:     sequential,
 				installableObj: installableObj,
 			}
 
+			// optional and ordering-only dependencies are honoured whether or not the manifest is
+			// sequential. Hard dependencies take precedence if a kapp is declared more than once.
+			for _, dependency := range installableObj.GetDescriptor().OptionalDependsOn {
+				dependency = qualifyDependency(installableObj, dependency)
+				if descriptor.addDependency(dependency) {
+					if descriptor.optional == nil {
+						descriptor.optional = make(map[string]bool, 0)
+					}
+					descriptor.optional[dependency] = true
+				}
+			}
+
+			for _, dependency := range installableObj.GetDescriptor().RunsAfter {
+				dependency = qualifyDependency(installableObj, dependency)
+				if descriptor.addDependency(dependency) {
+					if descriptor.runsAfter == nil {
+						descriptor.runsAfter = make(map[string]bool, 0)
+					}
+					descriptor.runsAfter[dependency] = true
+				}
+			}
+
+			descriptors[installableObj.FullyQualifiedId()] = descriptor
+
 			previousInstallable = installableObj.FullyQualifiedId()
 		}
 	}
 
 	return descriptors
 }
+
+// Fully-qualifies the ID of a dependency of an installable if it's not already, assuming
+// unqualified IDs refer to kapps in the same manifest
+func qualifyDependency(installableObj interfaces.IInstallable, dependency string) string {
+	if strings.Contains(dependency, constants.NamespaceSeparator) {
+		return dependency
+	}
+
+	return strings.Join([]string{installableObj.ManifestId(), dependency}, constants.NamespaceSeparator)
+}
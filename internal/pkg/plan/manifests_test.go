@@ -58,9 +58,9 @@ func TestFindDependencies(t *testing.T) {
 
 	expected := map[string]nodeDescriptor{
 		"manifest2:kappC": {dependsOn: []string{}, installableObj: manifests[0].Installables()[0]},
-		"manifest2:kappB": {dependsOn: []string{"manifest2:kappC"}, installableObj: manifests[0].Installables()[1]},
-		"manifest2:kappD": {dependsOn: []string{"manifest2:kappB"}, installableObj: manifests[0].Installables()[2]},
-		"manifest2:kappA": {dependsOn: []string{"manifest2:kappD"}, installableObj: manifests[0].Installables()[3]},
+		"manifest2:kappB": {dependsOn: []string{"manifest2:kappC"}, sequential: true, installableObj: manifests[0].Installables()[1]},
+		"manifest2:kappD": {dependsOn: []string{"manifest2:kappB"}, sequential: true, installableObj: manifests[0].Installables()[2]},
+		"manifest2:kappA": {dependsOn: []string{"manifest2:kappD"}, sequential: true, installableObj: manifests[0].Installables()[3]},
 		"manifest3:kappX": {dependsOn: []string{}, installableObj: manifests[1].Installables()[0]},
 		"manifest3:kappY": {dependsOn: []string{}, installableObj: manifests[1].Installables()[1]},
 	}
@@ -0,0 +1,172 @@
+/*
+ * Copyright 2019 The Sugarkube Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package plan
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/sugarkube/sugarkube/internal/pkg/cacher"
+	"github.com/sugarkube/sugarkube/internal/pkg/log"
+	"github.com/sugarkube/sugarkube/internal/pkg/utils"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+const outputCacheFileName = "outputs.yaml"
+
+// Stores the last known outputs of each kapp so they can be used instead of running kapps to generate
+// their outputs, e.g. when only processing marked kapps. Also tracks which kapps used cached outputs
+// or had none during the current run so users can be warned about them.
+type OutputCache struct {
+	path    string
+	mutex   sync.Mutex
+	entries map[string]outputCacheEntry
+	stale   []string // kapps whose outputs were taken from the cache during this run
+	missing []string // kapps that declare outputs but had none in the cache during this run
+}
+
+// The format of the output cache on disk
+type outputCacheFile struct {
+	Entries map[string]outputCacheEntry
+}
+
+type outputCacheEntry struct {
+	LoadedAt time.Time `yaml:"loaded_at"`
+	Outputs  map[string]interface{}
+}
+
+// Creates an output cache in the given cache directory, loading any outputs cached by previous runs
+func NewOutputCache(cacheDir string) (*OutputCache, error) {
+	absCacheDir, err := filepath.Abs(cacheDir)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	outputCache := &OutputCache{
+		path:    filepath.Join(absCacheDir, cacher.CacheDir, outputCacheFileName),
+		entries: map[string]outputCacheEntry{},
+	}
+
+	if _, err := os.Stat(outputCache.path); err != nil {
+		log.Logger.Debugf("No output cache found at '%s'", outputCache.path)
+		return outputCache, nil
+	}
+
+	contents := outputCacheFile{}
+	err = utils.LoadYamlFile(outputCache.path, &contents)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if contents.Entries != nil {
+		outputCache.entries = contents.Entries
+	}
+
+	return outputCache, nil
+}
+
+// Returns the cached outputs for a node, recording whether they were stale or missing
+func (o *OutputCache) lookup(node NamedNode) map[string]interface{} {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	if !node.installableObj.HasOutputs() {
+		return nil
+	}
+
+	entry, ok := o.entries[node.name]
+	if !ok {
+		log.Logger.Warnf("No cached outputs for kapp '%s'", node.name)
+		o.missing = append(o.missing, node.name)
+		return nil
+	}
+
+	log.Logger.Infof("Using outputs for kapp '%s' cached at %s", node.name, entry.LoadedAt)
+	o.stale = append(o.stale, fmt.Sprintf("%s (cached at %s)", node.name,
+		entry.LoadedAt.Format(time.RFC3339)))
+
+	return entry.Outputs
+}
+
+// Caches the outputs of a node and writes the cache to disk
+func (o *OutputCache) record(node NamedNode, outputs map[string]interface{}) error {
+	if outputs == nil {
+		return nil
+	}
+
+	// don't write sensitive outputs to disk
+	if hasSensitiveOutputs(node.installableObj) {
+		log.Logger.Debugf("Not caching outputs of kapp '%s' because it has sensitive outputs", node.name)
+		return nil
+	}
+
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	o.entries[node.name] = outputCacheEntry{
+		LoadedAt: time.Now().UTC(),
+		Outputs:  outputs,
+	}
+
+	err := writeYamlFile(o.path, outputCacheFile{Entries: o.entries})
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	log.Logger.Debugf("Cached outputs of kapp '%s' in '%s'", node.name, o.path)
+
+	return nil
+}
+
+// Prints a warning listing kapps whose outputs were stale or missing during this run
+func (o *OutputCache) PrintWarnings(writer io.Writer) error {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	if len(o.stale) == 0 && len(o.missing) == 0 {
+		return nil
+	}
+
+	sort.Strings(o.stale)
+	sort.Strings(o.missing)
+
+	_, err := fmt.Fprintf(writer, "\nWARNING: Outputs of unmarked kapps weren't reloaded:\n")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	for _, name := range o.stale {
+		_, err = fmt.Fprintf(writer, "  stale:   %s\n", name)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	}
+
+	for _, name := range o.missing {
+		_, err = fmt.Fprintf(writer, "  missing: %s\n", name)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	}
+
+	_, err = fmt.Fprintf(writer, "\n")
+	return errors.WithStack(err)
+}
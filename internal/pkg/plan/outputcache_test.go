@@ -0,0 +1,81 @@
+/*
+ * Copyright 2019 The Sugarkube Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package plan
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"github.com/sugarkube/sugarkube/internal/pkg/structs"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestOutputCache(t *testing.T) {
+	cacheDir, err := ioutil.TempDir("", "outputs-")
+	assert.Nil(t, err)
+	defer os.RemoveAll(cacheDir)
+
+	withOutputs := map[string]structs.Output{"out": {Id: "out", Format: "json"}}
+	nodes := createTestDag(t,
+		structs.KappDescriptorWithMaps{Id: "cached", Outputs: withOutputs},
+		structs.KappDescriptorWithMaps{Id: "uncached", Outputs: withOutputs},
+		structs.KappDescriptorWithMaps{Id: "secret", Outputs: map[string]structs.Output{
+			"creds": {Id: "creds", Format: "text", Sensitive: true}}},
+		structs.KappDescriptorWithMaps{Id: "none"}).nodesByName()
+	cached := nodes["manifest:cached"]
+	uncached := nodes["manifest:uncached"]
+	secret := nodes["manifest:secret"]
+	noOutputs := nodes["manifest:none"]
+	outputs := map[string]interface{}{"out": "value"}
+
+	outputCache, err := NewOutputCache(cacheDir)
+	assert.Nil(t, err)
+	assert.Nil(t, outputCache.record(cached, outputs))
+	assert.Nil(t, outputCache.record(secret, map[string]interface{}{"creds": "password"}))
+
+	outputCache, err = NewOutputCache(cacheDir)
+	assert.Nil(t, err)
+
+	assert.Equal(t, outputs, outputCache.lookup(cached))
+	assert.Nil(t, outputCache.lookup(uncached))
+	assert.Nil(t, outputCache.lookup(secret))
+	assert.Nil(t, outputCache.lookup(noOutputs))
+
+	var buffer bytes.Buffer
+	assert.Nil(t, outputCache.PrintWarnings(&buffer))
+
+	lines := strings.Split(strings.TrimSpace(buffer.String()), "\n")
+	assert.Equal(t, 4, len(lines))
+	assert.True(t, strings.HasPrefix(lines[1], "  stale:   manifest:cached (cached at "))
+	assert.Equal(t, "  missing: manifest:secret", lines[2])
+	assert.Equal(t, "  missing: manifest:uncached", lines[3])
+}
+
+func TestOutputCacheNoWarnings(t *testing.T) {
+	cacheDir, err := ioutil.TempDir("", "outputs-")
+	assert.Nil(t, err)
+	defer os.RemoveAll(cacheDir)
+
+	outputCache, err := NewOutputCache(cacheDir)
+	assert.Nil(t, err)
+
+	var buffer bytes.Buffer
+	assert.Nil(t, outputCache.PrintWarnings(&buffer))
+	assert.Equal(t, "", buffer.String())
+}
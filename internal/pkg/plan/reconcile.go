@@ -0,0 +1,167 @@
+/*
+ * Copyright 2019 The Sugarkube Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package plan
+
+import (
+	"context"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/sugarkube/sugarkube/internal/pkg/config"
+	"github.com/sugarkube/sugarkube/internal/pkg/constants"
+	"github.com/sugarkube/sugarkube/internal/pkg/log"
+	"gonum.org/v1/gonum/graph/simple"
+	"io"
+	"strings"
+)
+
+// Returns whether a node should be installed or deleted when applying the DAG. Marked nodes whose
+// state is absent are deleted. All other nodes are installed (or just have their outputs loaded if
+// they aren't marked).
+func reconcileAction(node NamedNode) string {
+	if node.marked && node.installableObj != nil && node.installableObj.State() == constants.AbsentKey {
+		return constants.DagActionDelete
+	}
+
+	return constants.DagActionInstall
+}
+
+// Returns the action to run on a node. When applying the DAG it depends on the node's state.
+func nodeAction(action string, node NamedNode) string {
+	if action == constants.DagActionApply {
+		return reconcileAction(node)
+	}
+
+	return action
+}
+
+// Returns a DAG containing the same nodes as this one with edges ordering them for applying, i.e.
+// so that walking down it installs present kapps after their parents and deletes absent kapps
+// before their parents:
+//
+//   - If a parent and child are both installed the parent is installed first
+//   - If a parent and child are both deleted the child is deleted first
+//   - If the parent is installed and the child is deleted, the parent is installed first since
+//     deleting the child may require the parent (e.g. a cluster) to exist
+//   - A child can't be installed if its parent is deleted, so an error is returned
+//
+// Since no edge points from a deleted node to an installed one the returned graph is acyclic.
+// The returned DAG must only be used to order nodes. Parent registries must still be taken from
+// the original DAG.
+func (g *Dag) reconciled() (*Dag, error) {
+	graphObj := simple.NewDirectedGraph()
+
+	nodes := g.graph.Nodes()
+	for nodes.Next() {
+		graphObj.AddNode(nodes.Node())
+	}
+
+	edges := g.graph.Edges()
+	for edges.Next() {
+		edge := edges.Edge()
+		parent := edge.From().(NamedNode)
+		child := edge.To().(NamedNode)
+
+		parentDeleted := reconcileAction(parent) == constants.DagActionDelete
+		childDeleted := reconcileAction(child) == constants.DagActionDelete
+
+		switch {
+		case parentDeleted && !childDeleted:
+			return nil, errors.New(fmt.Sprintf("Kapp '%s' is present but depends on '%s' which is "+
+				"absent. Either make '%s' absent too or make '%s' present", child.name, parent.name,
+				child.name, parent.name))
+		case parentDeleted && childDeleted:
+			graphObj.SetEdge(dependencyEdge{from: child, to: parent, edgeType: edgeType(edge)})
+		default:
+			graphObj.SetEdge(dependencyEdge{from: parent, to: child, edgeType: edgeType(edge)})
+		}
+	}
+
+	return &Dag{
+		graph:      graphObj,
+		Journal:    g.Journal,
+		Outputs:    g.Outputs,
+		OnlyMarked: g.OnlyMarked,
+		Durations:  g.Durations,
+		excluded:   g.excluded,
+	}, nil
+}
+
+// Returns true if applying the DAG would delete any kapps
+func (g *Dag) hasDeletions() bool {
+	nodes := g.graph.Nodes()
+	for nodes.Next() {
+		if reconcileAction(nodes.Node().(NamedNode)) == constants.DagActionDelete {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Prints the order in which kapps will be installed and deleted when applying the DAG
+func (g *Dag) PrintApplyPlan(writer io.Writer) error {
+	reconciledDag, err := g.reconciled()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	_, err = fmt.Fprintf(writer, "Kapps will be applied in the following order. Kapps marked with a %s "+
+		"will be installed or deleted, others will only have their outputs loaded: \n", markedNodeStr)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	numWorkers := config.CurrentConfig.NumWorkers
+
+	processCh := make(chan NamedNode)
+	doneCh := make(chan nodeResult, numWorkers)
+	finishedCh := reconciledDag.walkDown(context.Background(), true, walkOptions{}, processCh, doneCh)
+
+	go func() {
+		for node := range processCh {
+			predecessors := reconciledDag.graph.To(node.ID())
+
+			predecessorNames := make([]string, 0)
+			for predecessors.Next() {
+				predecessorNames = append(predecessorNames, predecessors.Node().(NamedNode).name)
+			}
+
+			marked := ""
+			if node.marked {
+				marked = fmt.Sprintf("%s ", markedNodeStr)
+			}
+			_, err := fmt.Fprintf(writer, "  %s%s %s - after: %s\n", marked,
+				reconcileAction(node), node.name, strings.Join(predecessorNames, ", "))
+			doneCh <- nodeResult{node: node, err: err}
+		}
+	}()
+
+	summary := <-finishedCh
+	failed := summary.Failed()
+	if len(failed) > 0 {
+		return errors.WithStack(failed[0].Err)
+	}
+
+	_, err = fmt.Fprintf(writer, "\n")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	log.Logger.Debug("Apply plan printed")
+
+	return nil
+}
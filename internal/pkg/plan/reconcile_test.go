@@ -0,0 +1,106 @@
+/*
+ * Copyright 2019 The Sugarkube Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package plan
+
+import (
+	"bytes"
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/sugarkube/sugarkube/internal/pkg/config"
+	"github.com/sugarkube/sugarkube/internal/pkg/constants"
+	"github.com/sugarkube/sugarkube/internal/pkg/structs"
+	"testing"
+)
+
+func TestReconciled(t *testing.T) {
+	previousConfig := config.CurrentConfig
+	config.CurrentConfig = &config.Config{NumWorkers: 1}
+	defer func() { config.CurrentConfig = previousConfig }()
+
+	// the cluster and web kapps are present, and the db kapp and the app kapp that depends on it are absent
+	dag := createTestDag(t,
+		structs.KappDescriptorWithMaps{Id: "cluster", KappConfig: structs.KappConfig{State: constants.PresentKey}},
+		structs.KappDescriptorWithMaps{Id: "web", KappConfig: structs.KappConfig{State: constants.PresentKey,
+			DependsOn: []string{"cluster"}}},
+		structs.KappDescriptorWithMaps{Id: "db", KappConfig: structs.KappConfig{State: constants.AbsentKey,
+			DependsOn: []string{"cluster"}}},
+		structs.KappDescriptorWithMaps{Id: "app", KappConfig: structs.KappConfig{State: constants.AbsentKey,
+			DependsOn: []string{"db"}}})
+	assert.True(t, dag.hasDeletions())
+
+	reconciledDag, err := dag.reconciled()
+	assert.Nil(t, err)
+
+	graphObj, err := reconciledDag.Export()
+	assert.Nil(t, err)
+	assert.Equal(t, []GraphEdge{
+		{From: "manifest:app", To: "manifest:db", Type: constants.EdgeTypeDependsOn},
+		{From: "manifest:cluster", To: "manifest:db", Type: constants.EdgeTypeDependsOn},
+		{From: "manifest:cluster", To: "manifest:web", Type: constants.EdgeTypeDependsOn},
+	}, graphObj.Edges)
+
+	processCh := make(chan NamedNode)
+	doneCh := make(chan nodeResult)
+
+	order := make([]string, 0)
+	go func() {
+		for node := range processCh {
+			order = append(order, reconcileAction(node)+" "+node.name)
+			doneCh <- nodeResult{node: node}
+		}
+	}()
+
+	summary := <-reconciledDag.walkDown(context.Background(), true, walkOptions{}, processCh, doneCh)
+	assert.Equal(t, 4, len(summary.withStatus(NodeStatusSucceeded)))
+
+	position := map[string]int{}
+	for i, entry := range order {
+		position[entry] = i
+	}
+	assert.True(t, position["install manifest:cluster"] < position["install manifest:web"])
+	assert.True(t, position["install manifest:cluster"] < position["delete manifest:db"])
+	assert.True(t, position["delete manifest:app"] < position["delete manifest:db"])
+
+	var buffer bytes.Buffer
+	assert.Nil(t, dag.PrintApplyPlan(&buffer))
+
+	output := buffer.String()
+	assert.Contains(t, output, "  * install manifest:cluster - after: \n")
+	assert.Contains(t, output, "  * delete manifest:app - after: \n")
+	assert.Contains(t, output, "  * delete manifest:db - after: ")
+	assert.Contains(t, output, "  * install manifest:web - after: manifest:cluster\n")
+}
+
+func TestReconciledPresentChildOfAbsentParent(t *testing.T) {
+	dag := createTestDag(t,
+		structs.KappDescriptorWithMaps{Id: "db", KappConfig: structs.KappConfig{State: constants.AbsentKey}},
+		structs.KappDescriptorWithMaps{Id: "app", KappConfig: structs.KappConfig{State: constants.PresentKey,
+			DependsOn: []string{"db"}}})
+
+	_, err := dag.reconciled()
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(),
+		"Kapp 'manifest:app' is present but depends on 'manifest:db' which is absent")
+
+	// unmarked kapps are never deleted so their children can still be installed
+	dag, err = dag.subGraph([]string{"manifest:app"}, nil, false, false)
+	assert.Nil(t, err)
+	assert.False(t, dag.hasDeletions())
+
+	_, err = dag.reconciled()
+	assert.Nil(t, err)
+}
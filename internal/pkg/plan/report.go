@@ -0,0 +1,222 @@
+/*
+ * Copyright 2019 The Sugarkube Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package plan
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/sugarkube/sugarkube/internal/pkg/constants"
+	"github.com/sugarkube/sugarkube/internal/pkg/utils"
+	"io"
+	"strings"
+	"time"
+)
+
+// number of lines of stderr to include in reports for kapps that failed
+const stderrTailLines = 20
+
+// A machine-readable report of executing the DAG, e.g. for publishing by CI systems
+type Report struct {
+	Action          string        `json:"action"`
+	DryRun          bool          `json:"dry_run"`
+	StartedAt       time.Time     `json:"started_at"`
+	FinishedAt      time.Time     `json:"finished_at"`
+	DurationSeconds float64       `json:"duration_seconds"`
+	Kapps           []ReportEntry `json:"kapps"`
+}
+
+// The outcome of processing a single kapp
+type ReportEntry struct {
+	Id              string     `json:"id"` // fully-qualified ID
+	Manifest        string     `json:"manifest"`
+	Action          string     `json:"action"`
+	Marked          bool       `json:"marked"`
+	Planned         bool       `json:"planned"`  // true if the kapp was marked and the run planned changes
+	Approved        bool       `json:"approved"` // true if the kapp was marked and the run was approved
+	StartedAt       *time.Time `json:"started_at,omitempty"`
+	FinishedAt      *time.Time `json:"finished_at,omitempty"`
+	DurationSeconds float64    `json:"duration_seconds"`
+	Status          string     `json:"status"`
+	ExitCode        *int       `json:"exit_code,omitempty"` // only set if the kapp failed running a command
+	Error           string     `json:"error,omitempty"`
+	StderrTail      string     `json:"stderr_tail,omitempty"`
+}
+
+// Creates a report from the outcome of executing the DAG
+func (s Summary) Report() *Report {
+	report := &Report{
+		Action:          s.Action,
+		DryRun:          s.DryRun,
+		StartedAt:       s.StartedAt,
+		FinishedAt:      s.FinishedAt,
+		DurationSeconds: s.FinishedAt.Sub(s.StartedAt).Seconds(),
+		Kapps:           make([]ReportEntry, 0),
+	}
+
+	for _, outcome := range s.Outcomes {
+		// when applying, each kapp is either installed or deleted
+		action := outcome.Action
+		if action == "" {
+			action = s.Action
+		}
+
+		entry := ReportEntry{
+			Id:       outcome.Name,
+			Manifest: outcome.Manifest,
+			Action:   action,
+			Marked:   outcome.Marked,
+			Planned:  outcome.Marked && s.Plan,
+			Approved: outcome.Marked && s.Approved,
+			Status:   outcome.Status,
+		}
+
+		if !outcome.StartedAt.IsZero() {
+			startedAt := outcome.StartedAt
+			finishedAt := outcome.FinishedAt
+			entry.StartedAt = &startedAt
+			entry.FinishedAt = &finishedAt
+			entry.DurationSeconds = finishedAt.Sub(startedAt).Seconds()
+		}
+
+		if outcome.Err != nil {
+			entry.Error = outcome.Err.Error()
+
+			commandErr := utils.FindCommandError(outcome.Err)
+			if commandErr != nil {
+				exitCode := commandErr.ExitCode
+				entry.ExitCode = &exitCode
+				entry.StderrTail = commandErr.StderrTail(stderrTailLines)
+			}
+		}
+
+		report.Kapps = append(report.Kapps, entry)
+	}
+
+	return report
+}
+
+// Writes the report in the given format
+func (r *Report) Write(writer io.Writer, format string) error {
+	switch format {
+	case constants.ReportFormatJson:
+		return r.writeJson(writer)
+	case constants.ReportFormatJunit:
+		return r.writeJunit(writer)
+	default:
+		return errors.New(fmt.Sprintf("Unsupported report format '%s'. Valid formats are: %s", format,
+			strings.Join([]string{constants.ReportFormatJson, constants.ReportFormatJunit}, ", ")))
+	}
+}
+
+func (r *Report) writeJson(writer io.Writer) error {
+	jsonData, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	_, err = fmt.Fprintf(writer, "%s\n", jsonData)
+	return errors.WithStack(err)
+}
+
+type junitTestSuites struct {
+	XMLName xml.Name         `xml:"testsuites"`
+	Suites  []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	Skipped   int             `xml:"skipped,attr"`
+	Time      string          `xml:"time,attr"`
+	Timestamp string          `xml:"timestamp,attr"`
+	Cases     []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	Skipped   *struct{}     `xml:"skipped,omitempty"`
+	SystemErr string        `xml:"system-err,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// Writes the report as JUnit XML. Each manifest is written as a test suite containing a test case
+// per kapp
+func (r *Report) writeJunit(writer io.Writer) error {
+	suites := make([]junitTestSuite, 0)
+	suiteIndexes := make(map[string]int, 0)
+	suiteDurations := make([]float64, 0)
+
+	for _, entry := range r.Kapps {
+		index, ok := suiteIndexes[entry.Manifest]
+		if !ok {
+			index = len(suites)
+			suiteIndexes[entry.Manifest] = index
+			suites = append(suites, junitTestSuite{
+				Name:      entry.Manifest,
+				Timestamp: r.StartedAt.Format(time.RFC3339),
+			})
+			suiteDurations = append(suiteDurations, 0)
+		}
+
+		suite := &suites[index]
+		suiteDurations[index] += entry.DurationSeconds
+
+		testCase := junitTestCase{
+			Name:      fmt.Sprintf("%s %s", entry.Action, entry.Id),
+			ClassName: entry.Manifest,
+			Time:      fmt.Sprintf("%.3f", entry.DurationSeconds),
+			SystemErr: entry.StderrTail,
+		}
+
+		switch entry.Status {
+		case NodeStatusFailed, NodeStatusInterrupted:
+			suite.Failures++
+			testCase.Failure = &junitFailure{
+				Message: strings.SplitN(entry.Error, "\n", 2)[0],
+				Text:    entry.Error,
+			}
+		case NodeStatusSkipped:
+			suite.Skipped++
+			testCase.Skipped = &struct{}{}
+		}
+
+		suite.Tests++
+		suite.Cases = append(suite.Cases, testCase)
+	}
+
+	for i := range suites {
+		suites[i].Time = fmt.Sprintf("%.3f", suiteDurations[i])
+	}
+
+	xmlData, err := xml.MarshalIndent(junitTestSuites{Suites: suites}, "", "  ")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	_, err = fmt.Fprintf(writer, "%s%s\n", xml.Header, xmlData)
+	return errors.WithStack(err)
+}
@@ -0,0 +1,103 @@
+/*
+ * Copyright 2019 The Sugarkube Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package plan
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/sugarkube/sugarkube/internal/pkg/constants"
+	"github.com/sugarkube/sugarkube/internal/pkg/structs"
+	"github.com/sugarkube/sugarkube/internal/pkg/utils"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReport(t *testing.T) {
+	var stdoutBuf, stderrBuf bytes.Buffer
+	commandErr := utils.ExecCommand("sh", []string{"-c", "echo line1 >&2; echo line2 >&2; exit 3"},
+		map[string]string{}, &stdoutBuf, &stderrBuf, "", 0, false)
+	assert.NotNil(t, commandErr)
+
+	startedAt := time.Date(2019, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	summary := &Summary{
+		Action:     constants.DagActionInstall,
+		Plan:       true,
+		StartedAt:  startedAt,
+		FinishedAt: startedAt.Add(time.Minute),
+	}
+	nodes := createTestDag(t, structs.KappDescriptorWithMaps{Id: "kappA"},
+		structs.KappDescriptorWithMaps{Id: "kappB"}, structs.KappDescriptorWithMaps{Id: "kappC"}).nodesByName()
+	unmarked := nodes["manifest:kappB"]
+	unmarked.marked = false
+
+	summary.add(nodes["manifest:kappA"], NodeStatusSucceeded, nil, startedAt,
+		startedAt.Add(10*time.Second))
+	summary.add(unmarked, NodeStatusFailed,
+		errors.Wrap(commandErr, "Error installing kapp"), startedAt.Add(10*time.Second),
+		startedAt.Add(15*time.Second))
+	summary.add(nodes["manifest:kappC"], NodeStatusSkipped, nil, time.Time{}, time.Time{})
+
+	report := summary.Report()
+
+	assert.Equal(t, constants.DagActionInstall, report.Action)
+	assert.Equal(t, float64(60), report.DurationSeconds)
+	assert.Equal(t, 3, len(report.Kapps))
+
+	succeeded := report.Kapps[0]
+	assert.Equal(t, "manifest:kappA", succeeded.Id)
+	assert.Equal(t, "manifest", succeeded.Manifest)
+	assert.True(t, succeeded.Marked)
+	assert.True(t, succeeded.Planned)
+	assert.False(t, succeeded.Approved)
+	assert.Equal(t, float64(10), succeeded.DurationSeconds)
+	assert.Nil(t, succeeded.ExitCode)
+	assert.Equal(t, "", succeeded.Error)
+
+	failed := report.Kapps[1]
+	assert.False(t, failed.Planned)
+	assert.Equal(t, NodeStatusFailed, failed.Status)
+	assert.Equal(t, 3, *failed.ExitCode)
+	assert.Equal(t, "line1\nline2", failed.StderrTail)
+	assert.True(t, strings.HasPrefix(failed.Error, "Error installing kapp: Failed to run command"))
+
+	skipped := report.Kapps[2]
+	assert.Nil(t, skipped.StartedAt)
+	assert.Equal(t, float64(0), skipped.DurationSeconds)
+
+	// reports can be written in each format
+	var buffer bytes.Buffer
+	assert.Nil(t, report.Write(&buffer, constants.ReportFormatJson))
+
+	parsed := Report{}
+	assert.Nil(t, json.Unmarshal(buffer.Bytes(), &parsed))
+	assert.Equal(t, report.Kapps[1].StderrTail, parsed.Kapps[1].StderrTail)
+
+	buffer.Reset()
+	assert.Nil(t, report.Write(&buffer, constants.ReportFormatJunit))
+	junit := buffer.String()
+	assert.Contains(t, junit, `<testsuite name="manifest" tests="3" failures="1" skipped="1" time="15.000"`)
+	assert.Contains(t, junit, `<testcase name="install manifest:kappA" classname="manifest" time="10.000"></testcase>`)
+	assert.Contains(t, junit, `<failure message="Error installing kapp: Failed to run command in directory &#39;&#39;:">`)
+	assert.Contains(t, junit, "<system-err>line1&#xA;line2</system-err>")
+	assert.Contains(t, junit, "<skipped></skipped>")
+
+	assert.NotNil(t, report.Write(&buffer, "yaml"))
+}
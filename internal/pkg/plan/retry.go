@@ -0,0 +1,105 @@
+/*
+ * Copyright 2019 The Sugarkube Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package plan
+
+import (
+	"context"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/sugarkube/sugarkube/internal/pkg/installer"
+	"github.com/sugarkube/sugarkube/internal/pkg/interfaces"
+	"github.com/sugarkube/sugarkube/internal/pkg/log"
+	"github.com/sugarkube/sugarkube/internal/pkg/utils"
+	"time"
+)
+
+// targets that can be retried
+var retryableTargets = []string{installer.TargetInstall, installer.TargetDelete, installer.TargetOutput}
+
+// overridden in tests
+var sleep = sleepContext
+
+// Waits for the duration, returning early with the context's error if it's cancelled first
+func sleepContext(ctx context.Context, duration time.Duration) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(duration):
+		return nil
+	}
+}
+
+// Runs an installer target for a kapp, retrying it according to the kapp's retry policy if it fails.
+// Retrying stops if the context is cancelled, e.g. because the run was interrupted.
+func withRetries(ctx context.Context, installableObj interfaces.IInstallable, target string, dryRun bool,
+	fn func() error) error {
+	retry := installableObj.GetDescriptor().Retry
+
+	for _, retryTarget := range retry.Targets {
+		if !utils.InStringArray(retryableTargets, retryTarget) {
+			return errors.New(fmt.Sprintf("Invalid retry target '%s' for kapp '%s'. Valid targets "+
+				"are: %v", retryTarget, installableObj.FullyQualifiedId(), retryableTargets))
+		}
+	}
+
+	attempts := 1
+	if retry.Attempts > 1 && (len(retry.Targets) == 0 || utils.InStringArray(retry.Targets, target)) {
+		attempts = retry.Attempts
+	}
+
+	backoff := time.Duration(retry.Backoff) * time.Second
+
+	var err error
+
+	for attempt := 1; attempt <= attempts; attempt++ {
+		if attempts > 1 {
+			log.Logger.Infof("Running target '%s' on kapp '%s' (attempt %d of %d)", target,
+				installableObj.FullyQualifiedId(), attempt, attempts)
+		}
+
+		err = fn()
+		if err == nil {
+			return nil
+		}
+
+		if ctx.Err() != nil {
+			log.Logger.Warnf("Not retrying target '%s' on kapp '%s' because the run was cancelled",
+				target, installableObj.FullyQualifiedId())
+			return err
+		}
+
+		if attempt < attempts {
+			log.Logger.Warnf("Attempt %d of %d to run target '%s' on kapp '%s' failed. Retrying in %s: %v",
+				attempt, attempts, target, installableObj.FullyQualifiedId(), backoff, err)
+			if !dryRun {
+				if sleep(ctx, backoff) != nil {
+					log.Logger.Warnf("Not retrying target '%s' on kapp '%s' because the run was cancelled",
+						target, installableObj.FullyQualifiedId())
+					return err
+				}
+			}
+			backoff *= 2
+		}
+	}
+
+	if attempts > 1 {
+		return errors.Wrapf(err, "Target '%s' failed on kapp '%s' after %d attempts", target,
+			installableObj.FullyQualifiedId(), attempts)
+	}
+
+	return err
+}
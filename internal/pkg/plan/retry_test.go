@@ -0,0 +1,126 @@
+/*
+ * Copyright 2019 The Sugarkube Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package plan
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/sugarkube/sugarkube/internal/pkg/installer"
+	"github.com/sugarkube/sugarkube/internal/pkg/structs"
+	"testing"
+	"time"
+)
+
+// Returns a function that fails the given number of times before succeeding, plus a pointer to
+// the number of times it was called
+func failingFunc(numFailures int) (func() error, *int) {
+	calls := 0
+	return func() error {
+		calls++
+		if calls <= numFailures {
+			return errors.New("transient error")
+		}
+		return nil
+	}, &calls
+}
+
+func TestWithRetries(t *testing.T) {
+	sleeps := make([]time.Duration, 0)
+	sleep = func(ctx context.Context, duration time.Duration) error {
+		sleeps = append(sleeps, duration)
+		return nil
+	}
+	defer func() { sleep = sleepContext }()
+
+	installableObj := newTestManifest(t, "manifest", false, structs.KappDescriptorWithMaps{Id: "kapp",
+		KappConfig: structs.KappConfig{Retry: structs.Retry{Attempts: 3, Backoff: 2}}}).Installables()[0]
+
+	fn, calls := failingFunc(2)
+	err := withRetries(context.Background(), installableObj, installer.TargetInstall, false, fn)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, *calls)
+	assert.Equal(t, []time.Duration{2 * time.Second, 4 * time.Second}, sleeps)
+
+	fn, calls = failingFunc(3)
+	err = withRetries(context.Background(), installableObj, installer.TargetInstall, false, fn)
+	assert.NotNil(t, err)
+	assert.Equal(t, 3, *calls)
+}
+
+func TestWithRetriesTargets(t *testing.T) {
+	sleep = func(ctx context.Context, duration time.Duration) error { return nil }
+	defer func() { sleep = sleepContext }()
+
+	installables := newTestManifest(t, "manifest", false,
+		structs.KappDescriptorWithMaps{Id: "output", KappConfig: structs.KappConfig{
+			Retry: structs.Retry{Attempts: 3, Targets: []string{installer.TargetOutput}}}},
+		structs.KappDescriptorWithMaps{Id: "invalid", KappConfig: structs.KappConfig{
+			Retry: structs.Retry{Attempts: 3, Targets: []string{"clean"}}}},
+	).Installables()
+	installableObj := installables[0]
+
+	// only the output target should be retried
+	fn, calls := failingFunc(1)
+	err := withRetries(context.Background(), installableObj, installer.TargetInstall, false, fn)
+	assert.NotNil(t, err)
+	assert.Equal(t, 1, *calls)
+
+	fn, calls = failingFunc(1)
+	err = withRetries(context.Background(), installableObj, installer.TargetOutput, false, fn)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, *calls)
+
+	// invalid targets are rejected
+	installableObj = installables[1]
+	fn, calls = failingFunc(0)
+	err = withRetries(context.Background(), installableObj, installer.TargetInstall, false, fn)
+	assert.NotNil(t, err)
+	assert.Equal(t, 0, *calls)
+}
+
+func TestWithRetriesDisabled(t *testing.T) {
+	installableObj := newTestManifest(t, "manifest", false, structs.KappDescriptorWithMaps{Id: "kapp",
+		KappConfig: structs.KappConfig{Retry: structs.Retry{}}}).Installables()[0]
+
+	fn, calls := failingFunc(1)
+	err := withRetries(context.Background(), installableObj, installer.TargetDelete, false, fn)
+	assert.Equal(t, "transient error", err.Error())
+	assert.Equal(t, 1, *calls)
+}
+
+func TestWithRetriesCancelled(t *testing.T) {
+	installableObj := newTestManifest(t, "manifest", false, structs.KappDescriptorWithMaps{Id: "kapp",
+		KappConfig: structs.KappConfig{Retry: structs.Retry{Attempts: 3, Backoff: 60}}}).Installables()[0]
+
+	// cancelling the context interrupts the backoff
+	ctx, cancel := context.WithCancel(context.Background())
+	fn, calls := failingFunc(3)
+	time.AfterFunc(10*time.Millisecond, cancel)
+
+	start := time.Now()
+	err := withRetries(ctx, installableObj, installer.TargetInstall, false, fn)
+	assert.Equal(t, "transient error", err.Error())
+	assert.Equal(t, 1, *calls)
+	assert.True(t, time.Since(start) < 10*time.Second)
+
+	// targets aren't retried once the context has been cancelled
+	fn, calls = failingFunc(3)
+	err = withRetries(ctx, installableObj, installer.TargetInstall, false, fn)
+	assert.NotNil(t, err)
+	assert.Equal(t, 1, *calls)
+}
@@ -0,0 +1,175 @@
+/*
+ * Copyright 2019 The Sugarkube Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package plan
+
+import (
+	"github.com/pkg/errors"
+	"github.com/sugarkube/sugarkube/internal/pkg/config"
+	"github.com/sugarkube/sugarkube/internal/pkg/constants"
+	"gonum.org/v1/gonum/graph/topo"
+	"time"
+)
+
+// How long processing the DAG is expected to take based on the durations recorded by previous runs
+type schedule struct {
+	expected     map[int64]time.Duration // how long each node is expected to take
+	remaining    map[int64]time.Duration // the length of the longest path from each node to the end of the walk
+	criticalPath []NamedNode             // the longest path through the DAG
+	eta          time.Duration           // how long processing all nodes is expected to take
+}
+
+// Estimates how long each node will take to process for the action. Marked nodes that haven't been
+// processed before are expected to take the mean duration of those that have, and unmarked nodes
+// are expected to finish immediately since only their outputs are loaded. Returns nil if no
+// durations have been recorded for any of the marked nodes.
+func (g *Dag) schedule(action string) (*schedule, error) {
+	if g.Durations == nil {
+		return nil, nil
+	}
+
+	expected := make(map[int64]time.Duration, 0)
+	unknown := make([]int64, 0)
+	numKnown := 0
+	var total time.Duration
+
+	nodes := g.graph.Nodes()
+	for nodes.Next() {
+		node := nodes.Node().(NamedNode)
+		if !node.marked {
+			expected[node.ID()] = 0
+			continue
+		}
+
+		duration, ok := g.Durations.expected(node.name, nodeAction(action, node))
+		if !ok {
+			unknown = append(unknown, node.ID())
+			continue
+		}
+
+		expected[node.ID()] = duration
+		total += duration
+		numKnown++
+	}
+
+	if numKnown == 0 {
+		return nil, nil
+	}
+
+	mean := total / time.Duration(numKnown)
+	for _, id := range unknown {
+		expected[id] = mean
+		total += mean
+	}
+
+	down := action != constants.DagActionDelete
+	remaining, err := g.remainingPathLengths(down, expected)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	criticalPath := g.criticalPath(down, remaining)
+
+	eta := time.Duration(0)
+	if len(criticalPath) > 0 {
+		eta = remaining[criticalPath[0].ID()]
+	}
+
+	// the DAG can't be processed faster than all workers being kept busy
+	numWorkers := 1
+	if config.CurrentConfig != nil && config.CurrentConfig.NumWorkers > 1 {
+		numWorkers = config.CurrentConfig.NumWorkers
+	}
+	if busy := total / time.Duration(numWorkers); busy > eta {
+		eta = busy
+	}
+
+	return &schedule{
+		expected:     expected,
+		remaining:    remaining,
+		criticalPath: criticalPath,
+		eta:          eta,
+	}, nil
+}
+
+// Returns the length of the longest path from each node to the end of the walk in the given
+// direction, including the node itself. Nodes with the longest remaining paths should be processed
+// first to minimise how long the walk takes.
+func (g *Dag) remainingPathLengths(down bool, expected map[int64]time.Duration) (map[int64]time.Duration, error) {
+	sorted, err := topo.Sort(g.graph)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	remaining := make(map[int64]time.Duration, len(sorted))
+
+	// visit dependants before the nodes that they depend on. Sorted nodes are ordered from parents to
+	// children, so when walking down we need to iterate backwards.
+	for i := range sorted {
+		node := sorted[i]
+		if down {
+			node = sorted[len(sorted)-1-i]
+		}
+
+		var longest time.Duration
+		dependants := g.dependants(node.(NamedNode), down)
+		for dependants.Next() {
+			if length := remaining[dependants.Node().ID()]; length > longest {
+				longest = length
+			}
+		}
+
+		remaining[node.ID()] = expected[node.ID()] + longest
+	}
+
+	return remaining, nil
+}
+
+// Returns the longest path through the DAG in the given direction. Ties are broken by node name so
+// the path is stable across runs.
+func (g *Dag) criticalPath(down bool, remaining map[int64]time.Duration) []NamedNode {
+	path := make([]NamedNode, 0)
+
+	pendingById := g.numDependenciesById(down)
+	candidates := make([]NamedNode, 0)
+	nodes := g.graph.Nodes()
+	for nodes.Next() {
+		node := nodes.Node().(NamedNode)
+		if pendingById[node.ID()] == 0 {
+			candidates = append(candidates, node)
+		}
+	}
+
+	for len(candidates) > 0 {
+		next := candidates[0]
+		for _, candidate := range candidates[1:] {
+			if remaining[candidate.ID()] > remaining[next.ID()] ||
+				(remaining[candidate.ID()] == remaining[next.ID()] && candidate.name < next.name) {
+				next = candidate
+			}
+		}
+
+		path = append(path, next)
+
+		candidates = make([]NamedNode, 0)
+		dependants := g.dependants(next, down)
+		for dependants.Next() {
+			candidates = append(candidates, dependants.Node().(NamedNode))
+		}
+	}
+
+	return path
+}
@@ -0,0 +1,170 @@
+/*
+ * Copyright 2019 The Sugarkube Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package plan
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const (
+	NodeStatusSucceeded = "succeeded"
+	NodeStatusFailed    = "failed"
+	NodeStatusSkipped   = "skipped"
+	// the node was being processed when the DAG was interrupted
+	NodeStatusInterrupted = "interrupted"
+)
+
+// The outcome of processing a single node in the DAG
+type NodeOutcome struct {
+	Name       string
+	Manifest   string
+	Action     string // the action run on the node. Set by Execute
+	Marked     bool
+	Status     string
+	Err        error
+	StartedAt  time.Time // zero for skipped nodes
+	FinishedAt time.Time // zero for skipped nodes
+}
+
+// Summarises the outcome of walking the DAG. Outcomes are in the order nodes finished
+// being processed, followed by any that were skipped.
+type Summary struct {
+	Outcomes []NodeOutcome
+	// the following are set by Execute
+	Action     string
+	Plan       bool
+	Approved   bool
+	DryRun     bool
+	StartedAt  time.Time
+	FinishedAt time.Time
+}
+
+func (s *Summary) add(node NamedNode, status string, err error, startedAt time.Time, finishedAt time.Time) {
+	manifestId := ""
+	if node.installableObj != nil {
+		manifestId = node.installableObj.ManifestId()
+	}
+
+	s.Outcomes = append(s.Outcomes, NodeOutcome{
+		Name:       node.name,
+		Manifest:   manifestId,
+		Marked:     node.marked,
+		Status:     status,
+		Err:        err,
+		StartedAt:  startedAt,
+		FinishedAt: finishedAt,
+	})
+}
+
+// Returns outcomes with the given status
+func (s Summary) withStatus(status string) []NodeOutcome {
+	outcomes := make([]NodeOutcome, 0)
+	for _, outcome := range s.Outcomes {
+		if outcome.Status == status {
+			outcomes = append(outcomes, outcome)
+		}
+	}
+
+	return outcomes
+}
+
+// Returns outcomes for nodes that failed to be processed
+func (s Summary) Failed() []NodeOutcome {
+	return s.withStatus(NodeStatusFailed)
+}
+
+// Returns outcomes for nodes that weren't processed because an error occurred
+func (s Summary) Skipped() []NodeOutcome {
+	return s.withStatus(NodeStatusSkipped)
+}
+
+// Returns outcomes for nodes that were being processed when the DAG was interrupted
+func (s Summary) Interrupted() []NodeOutcome {
+	return s.withStatus(NodeStatusInterrupted)
+}
+
+// Prints a table of the outcome of processing each node to the writer
+func (s Summary) Print(writer io.Writer) error {
+	_, err := fmt.Fprintf(writer, "\nSummary (kapps marked with a %s were selected for "+
+		"processing):\n", markedNodeStr)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	tabWriter := tabwriter.NewWriter(writer, 0, 0, 2, ' ', 0)
+
+	_, err = fmt.Fprintln(tabWriter, "  KAPP\tSTATUS\tERROR")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	for _, outcome := range s.Outcomes {
+		marked := ""
+		if outcome.Marked {
+			marked = fmt.Sprintf("%s ", markedNodeStr)
+		}
+
+		errMsg := ""
+		if outcome.Err != nil {
+			// only print the first line of the error to keep the table readable
+			errMsg = strings.SplitN(outcome.Err.Error(), "\n", 2)[0]
+		}
+
+		_, err = fmt.Fprintf(tabWriter, "  %s%s\t%s\t%s\n", marked, outcome.Name,
+			outcome.Status, errMsg)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	}
+
+	err = tabWriter.Flush()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	_, err = fmt.Fprintf(writer, "\n%d succeeded, %d failed, %d skipped",
+		len(s.withStatus(NodeStatusSucceeded)), len(s.Failed()), len(s.Skipped()))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	interrupted := s.Interrupted()
+	if len(interrupted) > 0 {
+		interruptedNames := make([]string, len(interrupted))
+		for i, outcome := range interrupted {
+			interruptedNames[i] = outcome.Name
+		}
+
+		_, err = fmt.Fprintf(writer, ", %d interrupted\n\nKapps that were interrupted while running: %s",
+			len(interrupted), strings.Join(interruptedNames, ", "))
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	}
+
+	_, err = fmt.Fprint(writer, "\n\n")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return nil
+}
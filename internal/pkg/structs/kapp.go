@@ -49,6 +49,61 @@ type Action struct {
 	Params []string
 }
 
+// Configures retrying installer targets that fail, e.g. because of transient network errors
+type Retry struct {
+	Attempts int      // total number of times to run a target. Values less than 2 disable retries
+	Backoff  int      // number of seconds to wait before the first retry. Doubles after each retry
+	Targets  []string // targets to retry (install, delete and/or output). If empty all of them are retried
+}
+
+// A named group of commands run by the units installer. Each list of commands is run as a single
+// shell script in the directory containing the kapp's sugarkube.yaml file (or Dir if it's set).
+type Unit struct {
+	Id         string
+	EnvVars    map[string]string `yaml:"env_vars" mapstructure:"env_vars"` // env vars for this unit only
+	Dir        string            // directory to run commands in, relative to the kapp's sugarkube.yaml file
+	Conditions []string          // template expressions that must all be true for the unit to run
+	Init       []string          // run before every other list except clean
+	Plan       []string          // run when installing without approval
+	Apply      []string          // run when installing with approval
+	PlanDelete []string          `yaml:"plan_delete" mapstructure:"plan_delete"` // run when deleting without approval
+	Delete     []string          // run when deleting with approval
+	Output     []string          // writes outputs to files. Also run after applying so later units can use them
+	Clean      []string
+}
+
+// Configures the make installer
+type Make struct {
+	Makefile string            // path to the Makefile relative to the kapp's sugarkube.yaml file
+	Targets  map[string]string // names of the make targets to run for each target (install, delete, output or clean) if they differ
+}
+
+// Configures the terraform installer
+type Terraform struct {
+	Dir           string            // directory containing terraform configs, relative to the kapp's sugarkube.yaml file. Defaults to terraform_<provider>
+	Binary        string            // the terraform binary to run. Defaults to 'terraform'
+	BackendConfig map[string]string `yaml:"backend_config" mapstructure:"backend_config"` // passed to `terraform init` as -backend-config options
+}
+
+// Configures the helm installer
+type Helm struct {
+	Chart    string // local path to the chart relative to the kapp's sugarkube.yaml file, or a chart reference. Defaults to the directory containing sugarkube.yaml
+	Binary   string // the helm binary to run. Defaults to 'helm'
+	Diff     bool   // show changes with the helm-diff plugin instead of a dry run when not approved
+	Rollback bool   // roll back to the previous revision if upgrading fails
+}
+
+// Configures the script installer. Each command is a script or executable followed by any arguments.
+// Scripts are relative to the kapp's sugarkube.yaml file, otherwise executables are found on the PATH.
+type Script struct {
+	Init       string // run before every other command except clean, e.g. to install dependencies
+	Install    string
+	Delete     string
+	Output     string
+	Clean      string
+	VarsFormat string `yaml:"vars_format" mapstructure:"vars_format"` // format to write vars in: json (the default) or yaml
+}
+
 // A struct for an actual sugarkube.yaml file
 type KappConfig struct {
 	State                string
@@ -63,7 +118,19 @@ type KappConfig struct {
 	Templates            []Template
 	Vars                 map[string]interface{}
 	DependsOn            []string `yaml:"depends_on"`             // fully qualified IDs of other kapps this depends on
+	OptionalDependsOn    []string `yaml:"optional_depends_on"`    // as DependsOn but ignored if the other kapp isn't in the stack
+	RunsAfter            []string `yaml:"runs_after"`             // kapps this is processed after without loading their outputs
 	IgnoreGlobalDefaults bool     `yaml:"ignore_global_defaults"` // don't add globally configured defaults for each requirement
+	Timeout              int      // max number of seconds to run each install, delete or output target for. 0 means no limit
+	Retry                Retry
+	ConcurrencyGroup     string    `yaml:"concurrency_group"` // limits how many kapps in the same group are processed at once
+	When                 string    // template expression. If it's false the kapp is left out of the DAG
+	Installer            string    // name of the installer to use. Defaults to 'units' if units are declared, otherwise 'make'
+	Units                []Unit    // run by the units installer in order (or reverse order when deleting)
+	Make                 Make      // configures the make installer
+	Terraform            Terraform // configures the terraform installer
+	Helm                 Helm      // configures the helm installer
+	Script               Script    // configures the script installer
 	// todo - implement
 	//VarsTemplate string		// this will be read as a string, templated then converted to YAML and merged with the Vars map
 }
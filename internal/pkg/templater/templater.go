@@ -18,22 +18,27 @@ package templater
 
 import (
 	"bytes"
+	"fmt"
 	"github.com/Masterminds/sprig"
 	"github.com/pkg/errors"
 	"github.com/sugarkube/sugarkube/internal/pkg/log"
 	"io/ioutil"
 	"os"
+	"strconv"
+	"strings"
 	"text/template"
 )
 
 // Returns a template rendered with the given input variables
 func RenderTemplate(inputTemplate string, vars map[string]interface{}) (string, error) {
-	tpl := template.Must(
-		template.New("gotpl").Funcs(
-			sprig.TxtFuncMap()).Funcs(CustomFunctions).Parse(inputTemplate))
+	tpl, err := template.New("gotpl").Funcs(
+		sprig.TxtFuncMap()).Funcs(CustomFunctions).Parse(inputTemplate)
+	if err != nil {
+		return "", errors.Wrapf(err, "Error parsing template %s", inputTemplate)
+	}
 
 	buf := bytes.NewBuffer(nil)
-	err := tpl.Execute(buf, vars)
+	err = tpl.Execute(buf, vars)
 	if err != nil {
 		return "", errors.Wrapf(err, "Error executing template %s with vars %#v", inputTemplate, vars)
 	}
@@ -75,3 +80,31 @@ func TemplateString(src string, outBuf *bytes.Buffer, vars map[string]interface{
 
 	return nil
 }
+
+// Renders a condition and returns whether it's true. Conditions can either be complete templates
+// or bare expressions, e.g. `eq .stack.provider "aws"`. Conditions that render to an empty
+// string are false.
+func EvaluateCondition(condition string, vars map[string]interface{}) (bool, error) {
+	expression := condition
+	if !strings.Contains(expression, "{{") {
+		expression = fmt.Sprintf("{{ %s }}", expression)
+	}
+
+	rendered, err := RenderTemplate(expression, vars)
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+
+	rendered = strings.TrimSpace(rendered)
+	if rendered == "" {
+		return false, nil
+	}
+
+	result, err := strconv.ParseBool(rendered)
+	if err != nil {
+		return false, errors.New(fmt.Sprintf("The condition '%s' must evaluate to true or false "+
+			"but evaluated to '%s'", condition, rendered))
+	}
+
+	return result, nil
+}
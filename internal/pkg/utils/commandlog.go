@@ -0,0 +1,140 @@
+/*
+ * Copyright 2019 The Sugarkube Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package utils
+
+import (
+	"context"
+	"fmt"
+	"github.com/pkg/errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+type commandLogKey struct{}
+
+// values of env vars whose names contain any of these strings aren't written to command logs
+var sensitiveEnvVarNames = []string{"PASSWORD", "PASSWD", "SECRET", "TOKEN", "KEY", "CREDENTIAL"}
+
+const redactedValue = "<redacted>"
+
+// Returns a context that makes commands run with it (or a context derived from it) append their
+// command line, env vars, stdout and stderr to the file at `path`. Values of env vars that look
+// sensitive are redacted. Commands aren't logged during dry runs.
+func WithCommandLog(parent context.Context, path string) context.Context {
+	return context.WithValue(parent, commandLogKey{}, path)
+}
+
+// A log file a single command is writing to
+type commandLog struct {
+	file      *os.File
+	startedAt time.Time
+}
+
+// Opens the log file the context says commands should be logged to and writes a header describing
+// the command to it. Returns nil if the context doesn't have a command log.
+func openCommandLog(ctx context.Context, dir string, command string, args []string,
+	envVars map[string]string) (*commandLog, error) {
+	path, ok := ctx.Value(commandLogKey{}).(string)
+	if !ok || path == "" {
+		return nil, nil
+	}
+
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	commandLog := &commandLog{
+		file:      file,
+		startedAt: time.Now(),
+	}
+
+	header := []string{
+		fmt.Sprintf("=== Started at %s", commandLog.startedAt.Format(time.RFC3339)),
+		fmt.Sprintf("Directory: %s", dir),
+		fmt.Sprintf("Command: %s", strings.TrimSpace(command+" "+strings.Join(args, " "))),
+		"Environment:",
+	}
+
+	for _, envVar := range redactEnvVars(envVars) {
+		header = append(header, "  "+envVar)
+	}
+
+	_, err = fmt.Fprintf(file, "%s\n", strings.Join(header, "\n"))
+	if err != nil {
+		file.Close()
+		return nil, errors.WithStack(err)
+	}
+
+	return commandLog, nil
+}
+
+// Returns writers that write each line of stdout and stderr to the log, prefixed with the
+// stream it came from
+func (l *commandLog) writers() (*lineWriter, *lineWriter) {
+	return &lineWriter{stream: &outputStream{out: l.file, prefix: "stdout"}},
+		&lineWriter{stream: &outputStream{out: l.file, prefix: "stderr"}}
+}
+
+// Records how the command exited and closes the log
+func (l *commandLog) close(cmd *exec.Cmd, err error) error {
+	duration := time.Since(l.startedAt).Round(time.Millisecond)
+
+	var footer string
+	if cmd.ProcessState != nil && cmd.ProcessState.Exited() {
+		footer = fmt.Sprintf("=== Exited with code %d after %s", cmd.ProcessState.ExitCode(), duration)
+	} else {
+		footer = fmt.Sprintf("=== Failed after %s: %v", duration, err)
+	}
+
+	_, writeErr := fmt.Fprintf(l.file, "%s\n\n", footer)
+	closeErr := l.file.Close()
+	if writeErr != nil {
+		return errors.WithStack(writeErr)
+	}
+
+	return errors.WithStack(closeErr)
+}
+
+// Returns sorted 'name=value' pairs for env vars with the values of sensitive-looking env vars redacted
+func redactEnvVars(envVars map[string]string) []string {
+	redacted := make([]string, 0, len(envVars))
+
+	for name, value := range envVars {
+		upperName := strings.ToUpper(name)
+		for _, sensitiveName := range sensitiveEnvVarNames {
+			if strings.Contains(upperName, sensitiveName) {
+				value = redactedValue
+				break
+			}
+		}
+
+		redacted = append(redacted, fmt.Sprintf("%s=%s", name, value))
+	}
+
+	sort.Strings(redacted)
+	return redacted
+}
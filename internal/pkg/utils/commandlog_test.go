@@ -0,0 +1,76 @@
+/*
+ * Copyright 2019 The Sugarkube Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package utils
+
+import (
+	"bytes"
+	"context"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestExecCommandLogsToFile(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "command-log-")
+	assert.Nil(t, err)
+	defer os.RemoveAll(tempDir)
+
+	logPath := filepath.Join(tempDir, "logs", "run", "install.log")
+	ctx := WithCommandLog(context.Background(), logPath)
+
+	envVars := map[string]string{
+		"KAPP_ROOT":             "/kapp",
+		"AWS_SECRET_ACCESS_KEY": "hunter2",
+		"db_password":           "hunter2",
+	}
+
+	var stdoutBuf, stderrBuf bytes.Buffer
+	err = ExecCommandContext(ctx, "sh", []string{"-c", "echo one; echo two >&2"}, envVars,
+		&stdoutBuf, &stderrBuf, tempDir, 0, false)
+	assert.Nil(t, err)
+
+	// commands are appended to the log
+	err = ExecCommandContext(ctx, "sh", []string{"-c", "exit 3"}, map[string]string{},
+		&stdoutBuf, &stderrBuf, tempDir, 0, false)
+	assert.Error(t, err)
+
+	contents, err := ioutil.ReadFile(logPath)
+	assert.Nil(t, err)
+	log := string(contents)
+
+	assert.Contains(t, log, "Directory: "+tempDir+"\n")
+	assert.Contains(t, log, "Command: sh -c echo one; echo two >&2\n")
+	assert.Contains(t, log, "Environment:\n  AWS_SECRET_ACCESS_KEY=<redacted>\n  KAPP_ROOT=/kapp\n"+
+		"  db_password=<redacted>\n")
+	assert.NotContains(t, log, "hunter2")
+	assert.Contains(t, log, "[stdout] one\n")
+	assert.Contains(t, log, "[stderr] two\n")
+	assert.Contains(t, log, "=== Exited with code 0 after ")
+	assert.Contains(t, log, "Command: sh -c exit 3\n")
+	assert.Contains(t, log, "=== Exited with code 3 after ")
+
+	// dry runs aren't logged
+	err = os.Remove(logPath)
+	assert.Nil(t, err)
+	err = ExecCommandContext(ctx, "sh", []string{"-c", "echo one"}, map[string]string{},
+		&stdoutBuf, &stderrBuf, tempDir, 0, true)
+	assert.Nil(t, err)
+	_, err = os.Stat(logPath)
+	assert.True(t, os.IsNotExist(err))
+}
@@ -22,6 +22,7 @@ import (
 	"fmt"
 	"github.com/pkg/errors"
 	"github.com/sugarkube/sugarkube/internal/pkg/log"
+	"io"
 	"os"
 	"os/exec"
 	"sort"
@@ -31,10 +32,30 @@ import (
 
 // Executes a command with an optional timeout, writing stdout and stderr to
 // buffers. If `dryRun` is true, a log message of what would have been executed
-// is emitted instead.
+// is emitted instead. Use ExecCommandContext with a context from WithOutputStream
+// or WithCommandLog to also stream output while the command runs or log it to a file.
 func ExecCommand(command string, args []string, envVars map[string]string,
 	stdoutBuf *bytes.Buffer, stderrBuf *bytes.Buffer, dir string,
 	timeoutSeconds int, dryRun bool) error {
+	return ExecCommandContext(context.Background(), command, args, envVars, stdoutBuf, stderrBuf, dir,
+		timeoutSeconds, dryRun)
+}
+
+// Like ExecCommand but stops the command if the context is cancelled. If the context was created by
+// WithInterrupt the command is sent the signal that interrupted sugarkube and given a grace period to
+// exit before being killed, otherwise it's killed immediately.
+func ExecCommandContext(ctx context.Context, command string, args []string, envVars map[string]string,
+	stdoutBuf *bytes.Buffer, stderrBuf *bytes.Buffer, dir string,
+	timeoutSeconds int, dryRun bool) error {
+	return ExecCommandWithInput(ctx, command, args, envVars, nil, stdoutBuf, stderrBuf, dir,
+		timeoutSeconds, dryRun)
+}
+
+// Like ExecCommandContext but the command reads its stdin from `stdin`. If it's nil the command reads
+// from the null device.
+func ExecCommandWithInput(ctx context.Context, command string, args []string, envVars map[string]string,
+	stdin io.Reader, stdoutBuf *bytes.Buffer, stderrBuf *bytes.Buffer, dir string,
+	timeoutSeconds int, dryRun bool) error {
 
 	// reset the buffers in case they've already been used
 	stdoutBuf.Reset()
@@ -48,31 +69,32 @@ func ExecCommand(command string, args []string, envVars map[string]string,
 	// sort the env vars to simplify copying and pasting log output
 	sort.Strings(strEnvVars)
 
-	var cmd *exec.Cmd
-	var ctx context.Context
-	var cancel context.CancelFunc
+	runCtx := ctx
 
 	if timeoutSeconds > 0 {
 		log.Logger.Debugf("%s command will be run with a timeout of %d seconds",
 			command, timeoutSeconds)
 
-		ctx, cancel = context.WithTimeout(context.Background(),
-			time.Duration(timeoutSeconds)*time.Second)
+		var cancel context.CancelFunc
+		runCtx, cancel = context.WithTimeout(ctx, time.Duration(timeoutSeconds)*time.Second)
 		defer cancel() // The cancel should be deferred so resources are cleaned up
-
-		cmd = exec.CommandContext(ctx, command, args...)
-	} else {
-		cmd = exec.Command(command, args...)
 	}
 
+	cmd := exec.Command(command, args...)
 	cmd.Env = append(os.Environ(), strEnvVars...)
-	cmd.Stdout = stdoutBuf
-	cmd.Stderr = stderrBuf
+	cmd.Stdin = stdin
 
 	if dir != "" {
 		cmd.Dir = dir
 	}
 
+	// run interruptible commands in their own process group so we control which signals they
+	// receive instead of them also being sent signals by the terminal
+	interruptible := isInterruptible(ctx)
+	if interruptible {
+		setProcessGroup(cmd)
+	}
+
 	commandString := fmt.Sprintf("%s %s %s",
 		strings.TrimSpace(strings.Join(strEnvVars, " ")),
 		command, strings.Join(args, " "))
@@ -86,16 +108,164 @@ func ExecCommand(command string, args []string, envVars map[string]string,
 			cmd.Dir, commandString)
 	}
 
-	err := cmd.Run()
-	if timeoutSeconds > 0 && ctx.Err() == context.DeadlineExceeded {
-		return errors.Wrapf(ctx.Err(), "Timed out executing command in "+
-			"directory '%s':\n%s\nStdout=%s\nStderr=%s", cmd.Dir, commandString,
-			stdoutBuf.String(), stderrBuf.String())
+	stdoutWriters := []io.Writer{stdoutBuf}
+	stderrWriters := []io.Writer{stderrBuf}
+	lineWriters := make([]*lineWriter, 0)
+
+	// stream output while the command runs if the context asks for it
+	if stdoutStream := newLineWriter(ctx); stdoutStream != nil {
+		stderrStream := newLineWriter(ctx)
+		stdoutWriters = append(stdoutWriters, stdoutStream)
+		stderrWriters = append(stderrWriters, stderrStream)
+		lineWriters = append(lineWriters, stdoutStream, stderrStream)
+	}
+
+	// failing to log a command shouldn't stop it from being run
+	commandLog, err := openCommandLog(ctx, cmd.Dir, command, args, envVars)
+	if err != nil {
+		log.Logger.Warnf("Error opening command log: %v", err)
+	}
+	if commandLog != nil {
+		stdoutLog, stderrLog := commandLog.writers()
+		stdoutWriters = append(stdoutWriters, stdoutLog)
+		stderrWriters = append(stderrWriters, stderrLog)
+		lineWriters = append(lineWriters, stdoutLog, stderrLog)
+	}
+
+	cmd.Stdout = io.MultiWriter(stdoutWriters...)
+	cmd.Stderr = io.MultiWriter(stderrWriters...)
+
+	err = cmd.Start()
+	if err == nil {
+		exited := make(chan struct{})
+		go stopOnCancel(ctx, runCtx, cmd, interruptible, exited)
+		err = cmd.Wait()
+		close(exited)
+	}
+
+	for _, writer := range lineWriters {
+		_ = writer.Flush()
+	}
+
+	if commandLog != nil {
+		logErr := commandLog.close(cmd, err)
+		if logErr != nil {
+			log.Logger.Warnf("Error closing command log: %v", logErr)
+		}
+	}
+
+	if timeoutSeconds > 0 && runCtx.Err() == context.DeadlineExceeded {
+		return errors.WithStack(newCommandError(runCtx.Err(), "Timed out executing command", cmd,
+			commandString, stdoutBuf, stderrBuf))
+	}
+	if ctx.Err() != nil {
+		return errors.WithStack(newCommandError(ctx.Err(), "Interrupted command", cmd, commandString,
+			stdoutBuf, stderrBuf))
+	}
+	if err != nil {
+		return errors.WithStack(newCommandError(err, "Failed to run command", cmd, commandString,
+			stdoutBuf, stderrBuf))
+	}
+
+	return nil
+}
+
+// Stops a command if the context it's run with is cancelled or times out before it exits. Commands
+// that are interrupted are sent the interrupting signal and only killed if they don't exit within the
+// grace period.
+func stopOnCancel(ctx context.Context, runCtx context.Context, cmd *exec.Cmd, interruptible bool,
+	exited <-chan struct{}) {
+	select {
+	case <-exited:
+		return
+	case <-runCtx.Done():
+	}
+
+	signal, gracePeriod := interruptSignal(ctx)
+	if signal != nil && interruptible {
+		log.Logger.Infof("Sending %v to '%s' (pid %d). Waiting up to %s for it to exit...", signal,
+			cmd.Path, cmd.Process.Pid, gracePeriod)
+		err := signalProcessGroup(cmd, signal)
+		if err != nil {
+			log.Logger.Warnf("Error sending %v to '%s': %v", signal, cmd.Path, err)
+		}
+
+		select {
+		case <-exited:
+			return
+		case <-time.After(gracePeriod):
+			log.Logger.Warnf("'%s' (pid %d) didn't exit within %s. Killing it", cmd.Path,
+				cmd.Process.Pid, gracePeriod)
+		}
 	}
+
+	err := killProcessGroup(cmd)
 	if err != nil {
-		return errors.Wrapf(err, "Failed to run command in directory '%s':\n%s\n"+
-			"Stdout=%s\nStderr=%s", cmd.Dir, commandString, stdoutBuf.String(),
-			stderrBuf.String())
+		log.Logger.Warnf("Error killing '%s': %v", cmd.Path, err)
+	}
+}
+
+// Returned when a command fails so callers can find out what it wrote to stderr and its exit code
+type CommandError struct {
+	err      error
+	message  string
+	Dir      string
+	Command  string
+	Stdout   string
+	Stderr   string
+	ExitCode int // -1 if the command didn't exit normally, e.g. it timed out or couldn't be started
+}
+
+func newCommandError(err error, message string, cmd *exec.Cmd, commandString string,
+	stdoutBuf *bytes.Buffer, stderrBuf *bytes.Buffer) *CommandError {
+	exitCode := -1
+	if cmd.ProcessState != nil {
+		exitCode = cmd.ProcessState.ExitCode()
+	}
+
+	return &CommandError{
+		err:      err,
+		message:  message,
+		Dir:      cmd.Dir,
+		Command:  commandString,
+		Stdout:   stdoutBuf.String(),
+		Stderr:   stderrBuf.String(),
+		ExitCode: exitCode,
+	}
+}
+
+func (e *CommandError) Error() string {
+	return fmt.Sprintf("%s in directory '%s':\n%s\nStdout=%s\nStderr=%s: %v", e.message, e.Dir,
+		e.Command, e.Stdout, e.Stderr, e.err)
+}
+
+// Returns the underlying error so errors.Cause() still returns e.g. an *exec.ExitError
+func (e *CommandError) Cause() error {
+	return e.err
+}
+
+// Returns the last n lines of stderr
+func (e *CommandError) StderrTail(n int) string {
+	lines := strings.Split(strings.TrimRight(e.Stderr, "\n"), "\n")
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// Searches the chain of causes of an error for a CommandError, returning nil if there isn't one
+func FindCommandError(err error) *CommandError {
+	for err != nil {
+		if commandErr, ok := err.(*CommandError); ok {
+			return commandErr
+		}
+
+		causer, ok := err.(interface{ Cause() error })
+		if !ok {
+			return nil
+		}
+		err = causer.Cause()
 	}
 
 	return nil
@@ -0,0 +1,47 @@
+//go:build !windows
+// +build !windows
+
+/*
+ * Copyright 2019 The Sugarkube Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package utils
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// Runs the command in a new process group
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+// Sends a signal to the command's process group if it has its own, so e.g. processes run by make
+// also receive it. Otherwise only the command itself is signalled.
+func signalProcessGroup(cmd *exec.Cmd, signal os.Signal) error {
+	sysSignal, ok := signal.(syscall.Signal)
+	if !ok || cmd.SysProcAttr == nil || !cmd.SysProcAttr.Setpgid {
+		return cmd.Process.Signal(signal)
+	}
+
+	return syscall.Kill(-cmd.Process.Pid, sysSignal)
+}
+
+// Kills the command's process group if it has its own, otherwise only the command itself
+func killProcessGroup(cmd *exec.Cmd) error {
+	return signalProcessGroup(cmd, syscall.SIGKILL)
+}
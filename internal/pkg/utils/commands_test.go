@@ -0,0 +1,79 @@
+/*
+ * Copyright 2019 The Sugarkube Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package utils
+
+import (
+	"bytes"
+	"context"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// Runs a shell script, interrupting it with SIGTERM after a short delay
+func execInterrupted(t *testing.T, script string, gracePeriod time.Duration) (error, *bytes.Buffer) {
+	ctx, interrupt := WithInterrupt(context.Background(), gracePeriod)
+
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		interrupt(syscall.SIGTERM)
+	}()
+
+	var stdoutBuf, stderrBuf bytes.Buffer
+	err := ExecCommandContext(ctx, "sh", []string{"-c", script}, map[string]string{},
+		&stdoutBuf, &stderrBuf, "", 0, false)
+	return err, &stderrBuf
+}
+
+func TestExecCommandForwardsSignal(t *testing.T) {
+	start := time.Now()
+	err, stderrBuf := execInterrupted(t, "trap 'echo terminating >&2; exit 4' TERM; "+
+		"while true; do sleep 0.1; done", 10*time.Second)
+
+	assert.Error(t, err)
+	assert.True(t, time.Since(start) < 5*time.Second)
+	assert.Equal(t, context.Canceled, errors.Cause(err))
+	// the whole process group is signalled so the shell also reports that sleep was terminated
+	assert.Contains(t, stderrBuf.String(), "terminating\n")
+	assert.Equal(t, 4, FindCommandError(err).ExitCode)
+}
+
+func TestExecCommandKilledAfterGracePeriod(t *testing.T) {
+	start := time.Now()
+	err, _ := execInterrupted(t, "trap '' TERM; while true; do sleep 0.1; done",
+		200*time.Millisecond)
+
+	assert.Error(t, err)
+	assert.True(t, time.Since(start) < 5*time.Second)
+	assert.Equal(t, context.Canceled, errors.Cause(err))
+	assert.Equal(t, -1, FindCommandError(err).ExitCode)
+}
+
+func TestExecCommandError(t *testing.T) {
+	var stdoutBuf, stderrBuf bytes.Buffer
+	err := ExecCommand("sh", []string{"-c", "echo a >&2; echo b >&2; echo c >&2; exit 2"},
+		map[string]string{}, &stdoutBuf, &stderrBuf, "", 0, false)
+
+	commandErr := FindCommandError(err)
+	assert.NotNil(t, commandErr)
+	assert.Equal(t, 2, commandErr.ExitCode)
+	assert.Equal(t, "b\nc", commandErr.StderrTail(2))
+
+	assert.Nil(t, FindCommandError(errors.New("not a command error")))
+}
@@ -0,0 +1,71 @@
+/*
+ * Copyright 2019 The Sugarkube Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package utils
+
+import (
+	"context"
+	"os"
+	"sync"
+	"time"
+)
+
+type interruptionKey struct{}
+
+// Records the signal that interrupted sugarkube so it can be forwarded to running commands
+type interruption struct {
+	mutex       sync.Mutex
+	signal      os.Signal
+	gracePeriod time.Duration
+}
+
+// Returns a context that's cancelled when the returned function is called with the signal that
+// interrupted sugarkube. Commands run with the context (or a context derived from it) will be sent
+// the same signal and killed if they haven't exited within the grace period.
+func WithInterrupt(parent context.Context, gracePeriod time.Duration) (context.Context, func(os.Signal)) {
+	ctx, cancel := context.WithCancel(parent)
+	state := &interruption{gracePeriod: gracePeriod}
+
+	interrupt := func(signal os.Signal) {
+		state.mutex.Lock()
+		if state.signal == nil {
+			state.signal = signal
+		}
+		state.mutex.Unlock()
+		cancel()
+	}
+
+	return context.WithValue(ctx, interruptionKey{}, state), interrupt
+}
+
+// Returns whether the context was created by WithInterrupt
+func isInterruptible(ctx context.Context) bool {
+	_, ok := ctx.Value(interruptionKey{}).(*interruption)
+	return ok
+}
+
+// Returns the signal that interrupted the context and the grace period to allow commands to exit
+// in. The signal is nil if the context wasn't interrupted.
+func interruptSignal(ctx context.Context) (os.Signal, time.Duration) {
+	state, ok := ctx.Value(interruptionKey{}).(*interruption)
+	if !ok {
+		return nil, 0
+	}
+
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+	return state.signal, state.gracePeriod
+}
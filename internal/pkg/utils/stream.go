@@ -0,0 +1,145 @@
+/*
+ * Copyright 2019 The Sugarkube Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package utils
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"sync"
+)
+
+type outputStreamKey struct{}
+
+// Where commands should stream their output to while they run
+type outputStream struct {
+	out    io.Writer
+	prefix string
+	colour string // ANSI escape sequence to colour the prefix with. Empty for no colour
+}
+
+// serialises writes by all streams so lines from commands running in parallel aren't interleaved
+var streamMutex sync.Mutex
+
+// foreground colours prefixes are chosen from
+var streamColours = []string{
+	"\033[31m", // red
+	"\033[32m", // green
+	"\033[33m", // yellow
+	"\033[34m", // blue
+	"\033[35m", // magenta
+	"\033[36m", // cyan
+	"\033[91m", // bright red
+	"\033[92m", // bright green
+	"\033[93m", // bright yellow
+	"\033[94m", // bright blue
+	"\033[95m", // bright magenta
+	"\033[96m", // bright cyan
+}
+
+const colourReset = "\033[0m"
+
+// Returns a context that makes commands run with it (or a context derived from it) write each line
+// of their stdout and stderr to `out` as soon as it's written, prefixed with `prefix`. If `colour` is
+// true the prefix is coloured, with the colour chosen from the prefix so it's the same each time.
+// Output is still captured in the buffers passed to ExecCommandContext.
+func WithOutputStream(parent context.Context, out io.Writer, prefix string, colour bool) context.Context {
+	stream := &outputStream{
+		out:    out,
+		prefix: prefix,
+	}
+
+	if colour {
+		hash := fnv.New32a()
+		_, _ = hash.Write([]byte(prefix))
+		stream.colour = streamColours[hash.Sum32()%uint32(len(streamColours))]
+	}
+
+	return context.WithValue(parent, outputStreamKey{}, stream)
+}
+
+// Returns whether commands run with the context stream their output as they run
+func IsStreamingOutput(ctx context.Context) bool {
+	_, ok := ctx.Value(outputStreamKey{}).(*outputStream)
+	return ok
+}
+
+// Returns a writer that streams lines written to it if the context was created by WithOutputStream,
+// otherwise nil
+func newLineWriter(ctx context.Context) *lineWriter {
+	stream, ok := ctx.Value(outputStreamKey{}).(*outputStream)
+	if !ok {
+		return nil
+	}
+
+	return &lineWriter{stream: stream}
+}
+
+// Writes complete lines to a stream with the stream's prefix, buffering partial lines until
+// they're completed or the writer is flushed
+type lineWriter struct {
+	stream  *outputStream
+	partial []byte
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	data := append(w.partial, p...)
+
+	for {
+		index := bytes.IndexByte(data, '\n')
+		if index < 0 {
+			break
+		}
+
+		err := w.writeLine(data[:index])
+		if err != nil {
+			return 0, err
+		}
+		data = data[index+1:]
+	}
+
+	w.partial = append([]byte{}, data...)
+	return len(p), nil
+}
+
+// Writes any partial line that hasn't been terminated with a newline
+func (w *lineWriter) Flush() error {
+	if len(w.partial) == 0 {
+		return nil
+	}
+
+	err := w.writeLine(w.partial)
+	w.partial = nil
+	return err
+}
+
+func (w *lineWriter) writeLine(line []byte) error {
+	line = bytes.TrimSuffix(line, []byte("\r"))
+
+	prefix := w.stream.prefix
+	if w.stream.colour != "" {
+		prefix = w.stream.colour + prefix + colourReset
+	}
+
+	streamMutex.Lock()
+	defer streamMutex.Unlock()
+
+	_, err := fmt.Fprintf(w.stream.out, "[%s] %s\n", prefix, line)
+	return err
+}
@@ -0,0 +1,79 @@
+/*
+ * Copyright 2019 The Sugarkube Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package utils
+
+import (
+	"bytes"
+	"context"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+func TestExecCommandStreamsOutput(t *testing.T) {
+	assert.False(t, IsStreamingOutput(context.Background()))
+
+	var streamed bytes.Buffer
+	ctx := WithOutputStream(context.Background(), &streamed, "web:wordpress", false)
+	assert.True(t, IsStreamingOutput(ctx))
+
+	var stdoutBuf, stderrBuf bytes.Buffer
+	err := ExecCommandContext(ctx, "sh", []string{"-c", "echo one; echo two >&2; printf three"},
+		map[string]string{}, &stdoutBuf, &stderrBuf, "", 0, false)
+	assert.Nil(t, err)
+
+	// output is still captured
+	assert.Equal(t, "one\nthree", stdoutBuf.String())
+	assert.Equal(t, "two\n", stderrBuf.String())
+
+	lines := strings.Split(strings.TrimSpace(streamed.String()), "\n")
+	assert.ElementsMatch(t, []string{"[web:wordpress] one", "[web:wordpress] two",
+		"[web:wordpress] three"}, lines)
+}
+
+func TestLineWriter(t *testing.T) {
+	var streamed bytes.Buffer
+	writer := newLineWriter(WithOutputStream(context.Background(), &streamed, "kapp", false))
+
+	_, err := writer.Write([]byte("hel"))
+	assert.Nil(t, err)
+	assert.Equal(t, "", streamed.String())
+
+	_, err = writer.Write([]byte("lo\r\nwor"))
+	assert.Nil(t, err)
+	assert.Equal(t, "[kapp] hello\n", streamed.String())
+
+	assert.Nil(t, writer.Flush())
+	assert.Equal(t, "[kapp] hello\n[kapp] wor\n", streamed.String())
+
+	assert.Nil(t, newLineWriter(context.Background()))
+}
+
+func TestStreamColours(t *testing.T) {
+	var first, second bytes.Buffer
+
+	for _, out := range []*bytes.Buffer{&first, &second} {
+		writer := newLineWriter(WithOutputStream(context.Background(), out, "web:wordpress", true))
+		_, err := writer.Write([]byte("line\n"))
+		assert.Nil(t, err)
+	}
+
+	// the same kapp is always the same colour
+	assert.Equal(t, first.String(), second.String())
+	assert.True(t, strings.HasPrefix(first.String(), "[\033["))
+	assert.Contains(t, first.String(), "web:wordpress"+colourReset+"] line\n")
+}